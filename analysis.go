@@ -1,68 +1,257 @@
 package main
 
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
 // Default thresholds
 const (
 	DefaultNuditySuggestiveThreshold = 0.75
 	DefaultNudityExplicitThreshold   = 0.25
 	DefaultOffensiveThreshold        = 0.25
 	DefaultAIGeneratedThreshold      = 0.60
+	DefaultDeepfakeThreshold         = 0.60
 )
 
 // Analysis is a summary of the API result
 // - Allowed: general verdict (true = no flags, false = flagged)
 // - Reasons: list of flagged reasons
+// - Details: for each reason, the subscore that tripped it and the threshold it was compared to
 // - Scores: normalised scores
 // - MediaURI: optional URI of the analysed media
+// - ImageURL: the URL that was submitted for analysis (set by the AnalyseImageURL* helpers)
+// - RequestID/MediaID: Sightengine identifiers to quote in support tickets or disputes
 type Analysis struct {
-	Allowed bool
-	Reasons []string
+	Allowed bool           `json:"allowed"`
+	Reasons []string       `json:"reasons"`
+	Details []ReasonDetail `json:"details,omitempty"`
 
 	Scores struct {
 		// Explicit nudity score (sexual_activity, sexual_display, erotica)
-		NudityExplicit float64
+		NudityExplicit float64 `json:"nudity_explicit"`
 		// Suggestive nudity score (very_suggestive, suggestive, mildly_suggestive)
-		NuditySuggestive float64
-		Offensive        float64
-		AIGenerated      float64
+		NuditySuggestive float64 `json:"nudity_suggestive"`
+		Offensive        float64 `json:"offensive"`
+		AIGenerated      float64 `json:"ai_generated"`
+		// Deepfake score (face swaps / impersonation), reported alongside ai_generated
+		Deepfake float64 `json:"deepfake"`
+	} `json:"scores"`
+	// Dominant names the subscore that produced each max-based category score (e.g. "sexual_display");
+	// empty when every subscore was 0. Suggestive is only set under SuggestiveMax
+	Dominant struct {
+		NudityExplicit   string `json:"nudity_explicit,omitempty"`
+		NuditySuggestive string `json:"nudity_suggestive,omitempty"`
+		Offensive        string `json:"offensive,omitempty"`
+	} `json:"dominant"`
+	// Models are the Sightengine models that produced this analysis; categories of other models
+	// weren't requested and their scores stay 0
+	Models []string `json:"models,omitempty"`
+	// Unevaluated lists categories whose model was requested but returned no usable result; their
+	// scores read as 0, so the verdict may under-report
+	Unevaluated []string `json:"unevaluated,omitempty"`
+	MediaURI    string   `json:"media_uri,omitempty"`
+	ImageURL    string   `json:"image_url,omitempty"`
+	RequestID   string   `json:"request_id,omitempty"`
+	MediaID     string   `json:"media_id,omitempty"`
+}
+
+// ReasonDetail explains a single flag: which subscore produced the category score and how it
+// compared with the threshold
+type ReasonDetail struct {
+	Reason    string  `json:"reason"`
+	Subscore  string  `json:"subscore"`
+	Score     float64 `json:"score"`
+	Threshold float64 `json:"threshold"`
+}
+
+// SuggestiveAggregation selects how the suggestive nudity subscores are combined
+type SuggestiveAggregation string
+
+const (
+	SuggestiveMean SuggestiveAggregation = "mean" // default
+	SuggestiveMax  SuggestiveAggregation = "max"
+)
+
+// AnalysisOptions carries per-guild scoring policy into AnalyseResult; the zero value is the default policy
+type AnalysisOptions struct {
+	SuggestiveAggregation SuggestiveAggregation
+	// MinReasons is how many categories must trip before an image is flagged; values below 1 mean 1
+	MinReasons int
+}
+
+// analysisOptionsForGuild builds the scoring policy from a guild's settings
+func analysisOptionsForGuild(guildID string) (AnalysisOptions, error) {
+	gs, err := settingsStore.Get(guildID)
+	if err != nil {
+		return AnalysisOptions{}, err
 	}
-	MediaURI string
+	return AnalysisOptions{SuggestiveAggregation: gs.SuggestiveAggregation, MinReasons: gs.MinReasons}, nil
 }
 
 // AdvancedAnalysis captures all numeric sub‑scores by category
 type AdvancedAnalysis struct {
 	Categories map[string]map[string]float64 // e.g. "nudity" -> {"none":0.95, "suggestive":0.02, ...}
 	MediaURI   string
+	// Allowed is the verdict under the guild's thresholds. Advanced output doesn't show it;
+	// it decides whether a flagged image's URL is echoed (see echo_flagged_url)
+	Allowed bool
 }
 
 // AnalyseImageURL runs the API request via sightengine and analyses the result
-func AnalyseImageURL(guildID, imageURL string) (*Analysis, error) {
-	out, err := sightengine(imageURL)
+func AnalyseImageURL(ctx context.Context, guildID, imageURL string) (*Analysis, error) {
+	models, err := settingsStore.EnabledModels(guildID)
+	if err != nil {
+		return nil, err
+	}
+	a, err := AnalyseImageURLWithModels(ctx, guildID, imageURL, models)
+	if err != nil {
+		return nil, err
+	}
+	analysisHistory.Record(guildID, a)
+	return a, nil
+}
+
+// AnalyseImageURLWithModels runs the standard analysis with only the given models. Results of a
+// partial selection aren't recorded in the analysis history, since the other scores read as 0
+func AnalyseImageURLWithModels(ctx context.Context, guildID, imageURL string, models []string) (*Analysis, error) {
+	out, err := sightengineWithModels(ctx, guildID, imageURL, models)
 	if err != nil {
 		return nil, err
 	}
 	// Normalise raw response into an Analysis struct using guild-specific thresholds
-	ns, ne, off, ai := thresholdsStore.GetGuildThresholds(perms, guildID)
-	a := AnalyseResult(out, ns, ne, off, ai)
+	a, err := analyseForGuild(out, guildID)
+	if err != nil {
+		return nil, err
+	}
+	a.Models = models
+	a.Unevaluated = unevaluatedCategories(out, models)
+	a.ImageURL = imageURL
 	return a, nil
 }
 
+// Scored reports whether the category of model was requested for this analysis; analyses
+// without a model list (e.g. /ai, uploads) count every category
+func (a *Analysis) Scored(model string) bool {
+	return len(a.Models) == 0 || slices.Contains(a.Models, model)
+}
+
+// AnalyseImageBytes uploads image bytes to Sightengine with the guild's models and scores the
+// result like AnalyseImageURL. The content is sniffed first so non-images fail with ErrBadImage
+// before any API call; ImageURL is left empty
+func AnalyseImageBytes(ctx context.Context, guildID string, data []byte, filename string) (*Analysis, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: the file is empty", ErrBadImage)
+	}
+	if limit := maxUploadBytes(); int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: %w (%d bytes, limit %d)", ErrBadImage, errImageTooLarge, len(data), limit)
+	}
+	// Formats the sniffer doesn't know (e.g. AVIF) come back as octet-stream; trust the extension then
+	if ct := http.DetectContentType(data); !strings.HasPrefix(ct, "image/") &&
+		!(ct == "application/octet-stream" && hasImageExtension(filename)) {
+		return nil, fmt.Errorf("%w: the file looks like %s, not an image", ErrBadImage, ct)
+	}
+	models, err := settingsStore.EnabledModels(guildID)
+	if err != nil {
+		return nil, err
+	}
+	out, err := sightengineUpload(ctx, guildID, data, filename, models)
+	if err != nil {
+		return nil, err
+	}
+	a, err := analyseForGuild(out, guildID)
+	if err != nil {
+		return nil, err
+	}
+	a.Unevaluated = unevaluatedCategories(out, models)
+	return a, nil
+}
+
+// modelCategories maps each Sightengine model to the response key holding its scores and the
+// category name shown to moderators
+var modelCategories = map[string]struct{ Key, Label string }{
+	"nudity-2.1":    {"nudity", "Nudity"},
+	"offensive-2.0": {"offensive", "Offensive"},
+	"genai":         {"type", "AI Generated"},
+	"gore-2.0":      {"gore", "Gore"},
+	"weapon":        {"weapon", "Weapons"},
+}
+
+// unevaluatedCategories lists the categories of requested models that are missing from a
+// successful response or carry an error instead of scores. Sightengine can answer
+// status=success while a single model failed, and such categories would otherwise read as 0
+func unevaluatedCategories(out map[string]any, models []string) []string {
+	var missing []string
+	for _, m := range models {
+		cat, ok := modelCategories[m]
+		if !ok {
+			continue
+		}
+		mm := getMap(out, cat.Key)
+		if mm == nil || mm["error"] != nil || mm["status"] == "failure" {
+			missing = append(missing, cat.Label)
+		}
+	}
+	return missing
+}
+
+// analyseForGuild scores a raw response with the guild's thresholds and scoring policy
+func analyseForGuild(out map[string]any, guildID string) (*Analysis, error) {
+	opts, err := analysisOptionsForGuild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	ns, ne, off, ai, df := thresholdsStore.GetGuildThresholds(perms, guildID)
+	return AnalyseResult(out, ns, ne, off, ai, df, opts), nil
+}
+
 // AnalyseImageURLAdvanced runs the API request via sightengine and returns full category/subcategory scores
-func AnalyseImageURLAdvanced(imageURL string) (*AdvancedAnalysis, error) {
-	out, err := sightengine(imageURL)
+func AnalyseImageURLAdvanced(ctx context.Context, guildID, imageURL string) (*AdvancedAnalysis, error) {
+	out, err := sightengine(ctx, guildID, imageURL)
 	if err != nil {
 		return nil, err
 	}
-	return AnalyseResultAdvanced(out), nil
+	return analyseAdvancedForGuild(out, guildID)
 }
 
 // AnalyseImageURLAIOnly runs the AI-only API request via sightengine and analyses the result
-func AnalyseImageURLAIOnly(guildID, imageURL string) (*Analysis, error) {
-	out, err := sightengineAIOnly(imageURL)
+func AnalyseImageURLAIOnly(ctx context.Context, guildID, imageURL string) (*Analysis, error) {
+	out, err := sightengineAIOnly(ctx, imageURL)
+	if err != nil {
+		return nil, err
+	}
+	a, err := analyseForGuild(out, guildID)
+	if err != nil {
+		return nil, err
+	}
+	a.Unevaluated = unevaluatedCategories(out, splitCSV(sightengineModelsAIOnly))
+	a.ImageURL = imageURL
+	return a, nil
+}
+
+// AnalyseImageURLAIOnlyAdvanced runs the AI-only API request and returns the full "type" category subscores
+func AnalyseImageURLAIOnlyAdvanced(ctx context.Context, guildID, imageURL string) (*AdvancedAnalysis, error) {
+	out, err := sightengineAIOnly(ctx, imageURL)
+	if err != nil {
+		return nil, err
+	}
+	return analyseAdvancedForGuild(out, guildID)
+}
+
+// analyseAdvancedForGuild extracts every subscore and records the guild's verdict alongside
+func analyseAdvancedForGuild(out map[string]any, guildID string) (*AdvancedAnalysis, error) {
+	a, err := analyseForGuild(out, guildID)
 	if err != nil {
 		return nil, err
 	}
-	ns, ne, off, ai := thresholdsStore.GetGuildThresholds(perms, guildID)
-	return AnalyseResult(out, ns, ne, off, ai), nil
+	aa := AnalyseResultAdvanced(out)
+	aa.Allowed = a.Allowed
+	return aa, nil
 }
 
 // AnalyseTempFile loads a local JSON result (e.g., 'temp.json') and analyses it
@@ -80,8 +269,8 @@ func AnalyseImageURLAIOnly(guildID, imageURL string) (*Analysis, error) {
 //	return a, nil
 //}
 
-// AnalyseResult converts the raw map into an Analysis summary using provided thresholds
-func AnalyseResult(out map[string]any, nsThresh, neThresh, offThresh, aiThresh float64) *Analysis {
+// AnalyseResult converts the raw map into an Analysis summary using provided thresholds and scoring policy
+func AnalyseResult(out map[string]any, nsThresh, neThresh, offThresh, aiThresh, dfThresh float64, opts AnalysisOptions) *Analysis {
 	a := &Analysis{}
 
 	// Extract scores
@@ -89,56 +278,66 @@ func AnalyseResult(out map[string]any, nsThresh, neThresh, offThresh, aiThresh f
 	nudity := getMap(out, "nudity")
 
 	// Explicit
-	a.Scores.NudityExplicit = maxFloat(
-		getFloat(nudity, "sexual_activity"),
-		getFloat(nudity, "sexual_display"),
-		getFloat(nudity, "erotica"),
-	)
-
-	// Suggestive
-	a.Scores.NuditySuggestive = meanFloat(
-		getFloat(nudity, "very_suggestive"),
-		getFloat(nudity, "suggestive"),
-		getFloat(nudity, "mildly_suggestive"),
-	)
-
-	// Offensive symbols score
-	off := getMap(out, "offensive")
-	a.Scores.Offensive = maxFloat(
-		getFloat(off, "nazi"),
-		getFloat(off, "asian_swastika"),
-		getFloat(off, "confederate"),
-		getFloat(off, "supremacist"),
-		getFloat(off, "terrorist"),
-	)
-
-	// AI-generated content score
+	a.Dominant.NudityExplicit, a.Scores.NudityExplicit = maxFloatLabeled(labeledScores(nudity,
+		"sexual_activity", "sexual_display", "erotica")...)
+
+	// Suggestive (mean of the subscores by default; some guilds prefer the strongest signal)
+	suggestive := labeledScores(nudity, "very_suggestive", "suggestive", "mildly_suggestive")
+	if opts.SuggestiveAggregation == SuggestiveMax {
+		a.Dominant.NuditySuggestive, a.Scores.NuditySuggestive = maxFloatLabeled(suggestive...)
+	} else {
+		vals := make([]float64, 0, len(suggestive))
+		for _, p := range suggestive {
+			vals = append(vals, p.Val)
+		}
+		a.Scores.NuditySuggestive = meanFloat(vals...)
+	}
+
+	// Offensive symbols score: the strongest of every returned class, so classes Sightengine
+	// adds later count without a code change
+	off := offensiveSubscores(getMap(out, "offensive"))
+	a.Dominant.Offensive, a.Scores.Offensive = maxFloatLabeled(labeledScores(off, sortedKeys(off)...)...)
+
+	// AI-generated and deepfake content scores
 	typ := getMap(out, "type")
 	a.Scores.AIGenerated = getFloat(typ, "ai_generated")
+	a.Scores.Deepfake = getFloat(typ, "deepfake")
 
-	// Media URI
+	// Media URI and Sightengine identifiers
 	if media := getMap(out, "media"); media != nil {
 		if uri, ok := media["uri"].(string); ok {
 			a.MediaURI = uri
 		}
+		if id, ok := media["id"].(string); ok {
+			a.MediaID = id
+		}
 	}
-
-	// Build reasons from thresholds
-	if a.Scores.NudityExplicit >= neThresh {
-		a.Reasons = append(a.Reasons, "nudity_explicit")
-	}
-	if a.Scores.NuditySuggestive >= nsThresh {
-		a.Reasons = append(a.Reasons, "nudity_suggestive")
+	if req := getMap(out, "request"); req != nil {
+		if id, ok := req["id"].(string); ok {
+			a.RequestID = id
+		}
 	}
-	if a.Scores.Offensive >= offThresh {
-		a.Reasons = append(a.Reasons, "offensive_symbols")
+
+	// Build reasons (with the subscore responsible for each) from thresholds
+	flag := func(reason, subscore string, score, threshold float64) {
+		if score >= threshold {
+			a.Reasons = append(a.Reasons, reason)
+			a.Details = append(a.Details, ReasonDetail{Reason: reason, Subscore: subscore, Score: score, Threshold: threshold})
+		}
 	}
-	if a.Scores.AIGenerated >= aiThresh {
-		a.Reasons = append(a.Reasons, "ai_generated_high")
+	suggestiveLabel := a.Dominant.NuditySuggestive
+	if opts.SuggestiveAggregation != SuggestiveMax {
+		suggestiveLabel = "mean(very_suggestive, suggestive, mildly_suggestive)"
 	}
+	flag("nudity_explicit", a.Dominant.NudityExplicit, a.Scores.NudityExplicit, neThresh)
+	flag("nudity_suggestive", suggestiveLabel, a.Scores.NuditySuggestive, nsThresh)
+	flag("offensive_symbols", a.Dominant.Offensive, a.Scores.Offensive, offThresh)
+	flag("ai_generated_high", "ai_generated", a.Scores.AIGenerated, aiThresh)
+	flag("deepfake_detected", "deepfake", a.Scores.Deepfake, dfThresh)
 
-	// Safe when no rule produced a reason
-	a.Allowed = len(a.Reasons) == 0
+	// Safe unless at least MinReasons rules produced a reason (default: any single reason flags)
+	minReasons := max(opts.MinReasons, 1)
+	a.Allowed = len(a.Reasons) < minReasons
 	return a
 }
 
@@ -148,9 +347,14 @@ func AnalyseResultAdvanced(out map[string]any) *AdvancedAnalysis {
 		Categories: make(map[string]map[string]float64),
 	}
 
-	for _, k := range []string{"nudity", "offensive", "type"} {
+	for _, k := range []string{"nudity", "offensive", "type", "gore", "weapon"} {
 		if mm := getMap(out, k); mm != nil {
-			if subs := extractNumericSubscores(mm); len(subs) > 0 {
+			subs := extractNumericSubscores(mm)
+			// gore/weapon nest their per-class scores under "classes"
+			for ck, cv := range extractNumericSubscores(getMap(mm, "classes")) {
+				subs[ck] = cv
+			}
+			if len(subs) > 0 {
 				aa.Categories[k] = subs
 			}
 		}
@@ -220,6 +424,59 @@ func extractNumericSubscores(m map[string]any) map[string]float64 {
 	return out
 }
 
+// offensiveSubscores returns every numeric class score of the offensive model, including any
+// nested under "classes" (as gore/weapon do)
+func offensiveSubscores(off map[string]any) map[string]any {
+	subs := make(map[string]any)
+	for k, v := range extractNumericSubscores(off) {
+		subs[k] = v
+	}
+	for k, v := range extractNumericSubscores(getMap(off, "classes")) {
+		subs[k] = v
+	}
+	return subs
+}
+
+// sortedKeys returns the keys of m in lexical order, so ties between subscores resolve the same way every time
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labeledScore is a subscore value together with its Sightengine key
+type labeledScore = struct {
+	Label string
+	Val   float64
+}
+
+// labeledScores reads the given subscores from m, keeping their keys
+func labeledScores(m map[string]any, keys ...string) []labeledScore {
+	out := make([]labeledScore, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, labeledScore{Label: k, Val: getFloat(m, k)})
+	}
+	return out
+}
+
+// maxFloatLabeled returns the maximum value and the label of the input that produced it
+// (the first on ties). Like maxFloat the floor is 0, in which case the label is empty
+func maxFloatLabeled(pairs ...struct {
+	Label string
+	Val   float64
+}) (string, float64) {
+	label, maximum := "", 0.0
+	for _, p := range pairs {
+		if p.Val > maximum {
+			label, maximum = p.Label, p.Val
+		}
+	}
+	return label, maximum
+}
+
 // Returns maximum of inputted values
 func maxFloat(vals ...float64) float64 {
 	maximum := 0.0
@@ -242,3 +499,50 @@ func meanFloat(vals ...float64) float64 {
 	}
 	return sum / float64(len(vals))
 }
+
+// formatAnalysisReport renders a Markdown report of a standard analysis, including the
+// scores, the thresholds they were compared against, the verdict and the reasons.
+// Intended as a downloadable record for appeals and record-keeping
+func formatAnalysisReport(a *Analysis, imageURL string, nsThresh, neThresh, offThresh, aiThresh, dfThresh float64) string {
+	var b strings.Builder
+	b.WriteString("# Image Analysis Report\n\n")
+	_, _ = fmt.Fprintf(&b, "- Image: %s\n", imageURL)
+	_, _ = fmt.Fprintf(&b, "- Generated: %s\n", time.Now().UTC().Format(time.RFC3339))
+	verdict := "Safe"
+	if !a.Allowed {
+		verdict = "Flagged"
+	}
+	_, _ = fmt.Fprintf(&b, "- Verdict: **%s**\n\n", verdict)
+
+	b.WriteString("## Scores\n\n")
+	b.WriteString("| Category | Score | Threshold | Flagged |\n")
+	b.WriteString("|---|---|---|---|\n")
+	rows := []struct {
+		name         string
+		score, limit float64
+	}{
+		{"Nudity (Explicit)", a.Scores.NudityExplicit, neThresh},
+		{"Nudity (Suggestive)", a.Scores.NuditySuggestive, nsThresh},
+		{"Offensive", a.Scores.Offensive, offThresh},
+		{"AI Generated", a.Scores.AIGenerated, aiThresh},
+		{"Deepfake", a.Scores.Deepfake, dfThresh},
+	}
+	for _, r := range rows {
+		_, _ = fmt.Fprintf(&b, "| %s | %.2f%% | %.2f%% | %t |\n", r.name, r.score*100, r.limit*100, r.score >= r.limit)
+	}
+
+	b.WriteString("\n## Reasons\n\n")
+	if len(a.Reasons) == 0 {
+		b.WriteString("None\n")
+	}
+	for _, d := range a.Details {
+		_, _ = fmt.Fprintf(&b, "- %s (%s %.2f%% ≥ %.2f%%)\n", d.Reason, d.Subscore, d.Score*100, d.Threshold*100)
+	}
+	if a.MediaURI != "" {
+		_, _ = fmt.Fprintf(&b, "\nAnalysed media: %s\n", a.MediaURI)
+	}
+	if a.RequestID != "" || a.MediaID != "" {
+		_, _ = fmt.Fprintf(&b, "\nSightengine request ID: %s  \nSightengine media ID: %s\n", a.RequestID, a.MediaID)
+	}
+	return b.String()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAnalyseImageBytesErrors(t *testing.T) {
+	t.Setenv("MAX_UPLOAD_BYTES", "32")
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	for _, tc := range []struct {
+		name  string
+		data  string
+		cause error // also wrapped, besides ErrBadImage
+	}{
+		{"empty", "", nil},
+		{"too large", png + strings.Repeat("x", 32), errImageTooLarge},
+		{"not an image", "<html><body>hi</body></html>", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := AnalyseImageBytes(context.Background(), "g1", []byte(tc.data), "a.png")
+			if !errors.Is(err, ErrBadImage) {
+				t.Fatalf("err = %v, want ErrBadImage", err)
+			}
+			if tc.cause != nil && !errors.Is(err, tc.cause) {
+				t.Errorf("err = %v, want it to wrap %v", err, tc.cause)
+			}
+			for _, other := range []error{ErrRateLimited, ErrMissingCredentials, ErrUpstreamUnavailable} {
+				if errors.Is(err, other) {
+					t.Errorf("err = %v also matches %v", err, other)
+				}
+			}
+		})
+	}
+}
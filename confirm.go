@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// confirmTimeout is how long a pending confirmation waits for a button press
+const confirmTimeout = 60 * time.Second
+
+// confirmCustomIDPrefix prefixes the custom IDs of confirm/cancel buttons: confirm:<action>:<token>
+const confirmCustomIDPrefix = "confirm"
+
+// pendingConfirm is a destructive action waiting for its invoker to press Confirm
+type pendingConfirm struct {
+	userID    string
+	origin    *discordgo.Interaction // original command interaction, edited on timeout
+	onConfirm func() string          // performs the mutation and returns the result message
+	timer     *time.Timer
+}
+
+var pendingConfirms = struct {
+	mu sync.Mutex
+	m  map[string]*pendingConfirm // token (original interaction ID) -> pending action
+}{m: make(map[string]*pendingConfirm)}
+
+// confirmAction responds with the pending change and Confirm/Cancel buttons.
+// onConfirm runs only when the invoking user presses Confirm within confirmTimeout;
+// its return value replaces the prompt
+func confirmAction(s *discordgo.Session, i *discordgo.InteractionCreate, embed *discordgo.MessageEmbed, ephemeral bool, onConfirm func() string) error {
+	token := i.ID
+	p := &pendingConfirm{userID: interactionUserID(i), origin: i.Interaction, onConfirm: onConfirm}
+	if p.userID == "" {
+		// Without an invoker anyone could press Confirm
+		_ = respondEphemeral(s, i, unknownUserMessage)
+		return errors.New("confirm: interaction has no user")
+	}
+
+	data := &discordgo.InteractionResponseData{
+		Embeds: []*discordgo.MessageEmbed{fitEmbed(embed)},
+		Components: []discordgo.MessageComponent{discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.Button{Label: "Confirm", Style: discordgo.DangerButton, CustomID: confirmCustomIDPrefix + ":yes:" + token},
+			discordgo.Button{Label: "Cancel", Style: discordgo.SecondaryButton, CustomID: confirmCustomIDPrefix + ":no:" + token},
+		}}},
+	}
+	if ephemeral {
+		data.Flags = discordgo.MessageFlagsEphemeral
+	}
+	// Register before responding so an immediate click always finds the pending action
+	pendingConfirms.mu.Lock()
+	pendingConfirms.m[token] = p
+	p.timer = time.AfterFunc(confirmTimeout, func() {
+		if takePendingConfirm(token) == nil {
+			return // already confirmed or cancelled
+		}
+		clearConfirmPrompt(s, p.origin, "Confirmation timed out. Nothing was changed.")
+	})
+	pendingConfirms.mu.Unlock()
+
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseChannelMessageWithSource, Data: data}); err != nil {
+		if takePendingConfirm(token) != nil {
+			p.timer.Stop()
+		}
+		return err
+	}
+	return nil
+}
+
+// takePendingConfirm removes and returns a pending action, or nil if it no longer exists
+func takePendingConfirm(token string) *pendingConfirm {
+	pendingConfirms.mu.Lock()
+	defer pendingConfirms.mu.Unlock()
+	p := pendingConfirms.m[token]
+	delete(pendingConfirms.m, token)
+	return p
+}
+
+// clearConfirmPrompt replaces the prompt with a plain message and removes its buttons
+func clearConfirmPrompt(s Responder, origin *discordgo.Interaction, content string) {
+	embeds := []*discordgo.MessageEmbed{}
+	components := []discordgo.MessageComponent{}
+	_, _ = s.InteractionResponseEdit(origin, &discordgo.WebhookEdit{Content: &content, Embeds: &embeds, Components: &components})
+}
+
+// handleConfirmComponent dispatches Confirm/Cancel button presses created by confirmAction
+func handleConfirmComponent(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionMessageComponent {
+		return
+	}
+	parts := strings.Split(i.MessageComponentData().CustomID, ":")
+	if len(parts) != 3 || parts[0] != confirmCustomIDPrefix {
+		return
+	}
+	choice, token := parts[1], parts[2]
+
+	pendingConfirms.mu.Lock()
+	p := pendingConfirms.m[token]
+	if p != nil && p.userID != interactionUserID(i) {
+		pendingConfirms.mu.Unlock()
+		_ = respondEphemeral(s, i, "Only the user who ran this command can confirm it.")
+		return
+	}
+	delete(pendingConfirms.m, token)
+	pendingConfirms.mu.Unlock()
+
+	if p == nil {
+		_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseUpdateMessage,
+			Data: &discordgo.InteractionResponseData{Content: "This confirmation has expired. Nothing was changed.",
+				Embeds: []*discordgo.MessageEmbed{}, Components: []discordgo.MessageComponent{}}})
+		return
+	}
+	p.timer.Stop()
+
+	// Acknowledge the click first; the mutation may take longer than the 3s response window
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredMessageUpdate})
+	result := "Cancelled. Nothing was changed."
+	if choice == "yes" {
+		result = p.onConfirm()
+	}
+	clearConfirmPrompt(s, i.Interaction, result)
+}
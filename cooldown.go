@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cooldownCommands are the expensive commands a guild can put a cooldown on (command_cooldowns)
+var cooldownCommands = []string{"ai", "analyse", "reverse"}
+
+// maxCommandCooldown caps a single command's cooldown
+const maxCommandCooldown = time.Hour
+
+// normaliseCommandCooldowns accepts "command=seconds" pairs separated by commas, e.g.
+// "analyse=30,reverse=60". Zero durations are dropped; the stored form is sorted by command
+func normaliseCommandCooldowns(in string) (string, error) {
+	if isClearValue(in) {
+		return "", nil
+	}
+	cds := make(map[string]int)
+	for _, pair := range splitCSV(strings.ToLower(in)) {
+		name, secs, ok := strings.Cut(pair, "=")
+		name = strings.TrimPrefix(strings.TrimSpace(name), "/")
+		if !ok || name == "" {
+			return "", errors.New("expected command=seconds pairs, e.g. analyse=30,reverse=60")
+		}
+		if !slices.Contains(cooldownCommands, name) {
+			return "", fmt.Errorf("cooldowns can only be set for %s", strings.Join(cooldownCommands, ", "))
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(secs))
+		if err != nil || n < 0 || time.Duration(n)*time.Second > maxCommandCooldown {
+			return "", fmt.Errorf("cooldown for %s must be a whole number of seconds between 0 and %d", name, int(maxCommandCooldown/time.Second))
+		}
+		cds[name] = n
+	}
+	names := make([]string, 0, len(cds))
+	for name, n := range cds {
+		if n > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for n, name := range names {
+		parts[n] = fmt.Sprintf("%s=%d", name, cds[name])
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// parseCommandCooldowns reads the stored form of command_cooldowns
+func parseCommandCooldowns(v string) map[string]time.Duration {
+	out := make(map[string]time.Duration)
+	for _, pair := range splitCSV(v) {
+		name, secs, _ := strings.Cut(pair, "=")
+		if n, err := strconv.Atoi(secs); err == nil && n > 0 {
+			out[name] = time.Duration(n) * time.Second
+		}
+	}
+	return out
+}
+
+// formatCommandCooldowns renders the stored form for /settings view, e.g. "/analyse 30s, /reverse 1m0s"
+func formatCommandCooldowns(v string) string {
+	cds := parseCommandCooldowns(v)
+	names := make([]string, 0, len(cds))
+	for name := range cds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for n, name := range names {
+		parts[n] = "/" + name + " " + cds[name].String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// CommandCooldowns remembers when each command last ran in each guild, so a guild-wide
+// cooldown applies across all members (unlike per-user limits)
+type CommandCooldowns struct {
+	mu   sync.Mutex
+	last map[string]time.Time // guildID|command -> last accepted invocation
+}
+
+var commandCooldowns = &CommandCooldowns{last: make(map[string]time.Time)}
+
+// Take records an invocation of command in guildID when its cooldown has passed and returns 0;
+// otherwise it returns the time left and records nothing
+func (cc *CommandCooldowns) Take(guildID, command string, cooldown time.Duration) time.Duration {
+	if guildID == "" || cooldown <= 0 {
+		return 0
+	}
+	key := guildID + "|" + command
+	now := time.Now()
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if last, ok := cc.last[key]; ok {
+		if wait := last.Add(cooldown).Sub(now); wait > 0 {
+			return wait
+		}
+	}
+	cc.last[key] = now
+	return 0
+}
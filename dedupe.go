@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for the repost dedupe window (REPOST_DEDUPE_SECONDS) and its size cap (REPOST_DEDUPE_MAX)
+const (
+	defaultRepostDedupeWindow = 5 * time.Minute
+	defaultRepostDedupeMax    = 1000
+)
+
+// recentAnalysis is a raw Sightengine response remembered for reuse until expires
+type recentAnalysis struct {
+	out     map[string]any
+	expires time.Time
+}
+
+// RecentAnalyses remembers raw Sightengine responses per guild, image and model set so an image
+// posted again within the window reuses the earlier result instead of a new API call.
+// Raw responses (not verdicts) are kept so the guild's current thresholds still apply.
+// Entries are bounded: when full, expired entries go first, then those closest to expiry
+type RecentAnalyses struct {
+	mu      sync.Mutex
+	entries map[string]recentAnalysis
+}
+
+var recentAnalyses = &RecentAnalyses{entries: make(map[string]recentAnalysis)}
+
+type repostDedupeKey struct{}
+
+// withRepostDedupe returns a context whose analyses reuse (and record) recent responses. Only
+// automated callers opt in (the JSON API); slash commands always call Sightengine, so re-runs
+// after threshold or model changes and raw/debug output are never served from the cache
+func withRepostDedupe(ctx context.Context) context.Context {
+	return context.WithValue(ctx, repostDedupeKey{}, true)
+}
+
+// repostDedupeEnabled reports whether ctx opted in with withRepostDedupe
+func repostDedupeEnabled(ctx context.Context) bool {
+	on, _ := ctx.Value(repostDedupeKey{}).(bool)
+	return on
+}
+
+// repostDedupeWindow returns REPOST_DEDUPE_SECONDS; 0 disables reuse
+func repostDedupeWindow() time.Duration {
+	v := strings.TrimSpace(os.Getenv("REPOST_DEDUPE_SECONDS"))
+	if v == "" {
+		return defaultRepostDedupeWindow
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return defaultRepostDedupeWindow
+	}
+	return time.Duration(n) * time.Second
+}
+
+// repostDedupeMax returns REPOST_DEDUPE_MAX, the most responses kept in memory
+func repostDedupeMax() int {
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("REPOST_DEDUPE_MAX"))); err == nil && n > 0 {
+		return n
+	}
+	return defaultRepostDedupeMax
+}
+
+// repostKey identifies an image for dedupe. Discord attachment links are keyed without their
+// query string, since the same attachment is re-signed (ex, is, hm) every time it is fetched
+func repostKey(guildID, models, imageURL string) string {
+	if isDiscordCDNURL(imageURL) {
+		if u, err := url.Parse(imageURL); err == nil {
+			u.RawQuery = ""
+			imageURL = u.String()
+		}
+	}
+	return thresholdsGuildKey(guildID) + "|" + models + "|" + imageURL
+}
+
+// Get returns a response recorded for key within the dedupe window
+func (ra *RecentAnalyses) Get(key string) (map[string]any, bool) {
+	if repostDedupeWindow() == 0 {
+		return nil, false
+	}
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	e, ok := ra.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(ra.entries, key)
+		return nil, false
+	}
+	return e.out, true
+}
+
+// Len returns the number of remembered responses, expired ones included until evicted
+func (ra *RecentAnalyses) Len() int {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	return len(ra.entries)
+}
+
+// Put records a response for key, evicting old entries to stay within REPOST_DEDUPE_MAX
+func (ra *RecentAnalyses) Put(key string, out map[string]any) {
+	window := repostDedupeWindow()
+	if window == 0 {
+		return
+	}
+	now := time.Now()
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	if _, exists := ra.entries[key]; !exists && len(ra.entries) >= repostDedupeMax() {
+		for k, e := range ra.entries {
+			if now.After(e.expires) {
+				delete(ra.entries, k)
+			}
+		}
+		for len(ra.entries) >= repostDedupeMax() {
+			var oldest string
+			var oldestAt time.Time
+			for k, e := range ra.entries {
+				if oldest == "" || e.expires.Before(oldestAt) {
+					oldest, oldestAt = k, e.expires
+				}
+			}
+			delete(ra.entries, oldest)
+		}
+	}
+	ra.entries[key] = recentAnalysis{out: out, expires: now.Add(window)}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// diagnosticsCheckTimeout bounds each /diagnostics check so a hung dependency can't stall the reply
+const diagnosticsCheckTimeout = 5 * time.Second
+
+// diagnosticResult is the outcome of one subsystem check. Skipped checks (e.g. no DB configured)
+// are neither pass nor fail
+type diagnosticResult struct {
+	Name    string
+	OK      bool
+	Skipped bool
+	Detail  string
+	Took    time.Duration
+}
+
+// diagnosticCheck probes one subsystem; a nil error with a detail means pass
+type diagnosticCheck struct {
+	Name string
+	Run  func(ctx context.Context) (detail string, err error)
+}
+
+// errDiagnosticSkipped marks a subsystem that isn't configured
+var errDiagnosticSkipped = errors.New("not configured")
+
+// diagnosticChecks are the subsystems /diagnostics probes, in display order
+var diagnosticChecks = []diagnosticCheck{
+	{Name: "Database", Run: checkDatabase},
+	{Name: "Sightengine", Run: checkSightengine},
+	{Name: "Reverse API", Run: checkReverseAPI},
+}
+
+// runDiagnostics runs every check concurrently, each with its own diagnosticsCheckTimeout
+func runDiagnostics(ctx context.Context) []diagnosticResult {
+	results := make([]diagnosticResult, len(diagnosticChecks))
+	var wg sync.WaitGroup
+	for n, c := range diagnosticChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, diagnosticsCheckTimeout)
+			defer cancel()
+			start := time.Now()
+			detail, err := c.Run(cctx)
+			r := diagnosticResult{Name: c.Name, OK: err == nil, Detail: detail, Took: time.Since(start)}
+			switch {
+			case errors.Is(err, errDiagnosticSkipped):
+				r.OK, r.Skipped, r.Detail = false, true, err.Error()
+			case err != nil:
+				r.Detail = err.Error()
+			}
+			results[n] = r
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// checkDatabase pings the permissions/thresholds DB
+func checkDatabase(ctx context.Context) (string, error) {
+	if perms == nil || perms.db == nil {
+		return "", fmt.Errorf("%w (in-memory/JSON storage)", errDiagnosticSkipped)
+	}
+	if err := perms.db.PingContext(ctx); err != nil {
+		return "", err
+	}
+	return perms.dialect + " reachable", nil
+}
+
+// checkSightengine validates every credential against account.json (no operations are consumed)
+func checkSightengine(ctx context.Context) (string, error) {
+	if err := defaultSightengineClient.checkCredentials(ctx); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d credential(s) valid", len(defaultSightengineClient.creds.creds)), nil
+}
+
+// checkReverseAPI sends a HEAD request to the reverse API endpoint; any response below 500 means
+// the service is up (the endpoint only accepts POST, so 404/405 are expected)
+func checkReverseAPI(ctx context.Context) (string, error) {
+	cli, err := NewReverseAPIClient()
+	if err != nil {
+		return "", fmt.Errorf("%w (%v)", errDiagnosticSkipped, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, cli.Endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := cli.Client.Do(req)
+	if err != nil {
+		return "", withoutRequestURL(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return fmt.Sprintf("reachable (HTTP %d)", resp.StatusCode), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// discordCDNHosts serve Discord attachments; their links carry expiring signatures (ex, is, hm)
+var discordCDNHosts = []string{"cdn.discordapp.com", "media.discordapp.net"}
+
+// errDiscordLinkExpired is returned when a Discord attachment link failed to download and
+// no fresh link could be obtained from the message it belongs to
+var errDiscordLinkExpired = errors.New("discord attachment link expired")
+
+// isDiscordCDNURL reports whether raw is a Discord attachment link
+// (https://cdn.discordapp.com/attachments/<channel>/<attachment>/<file>)
+func isDiscordCDNURL(raw string) bool {
+	_, _, ok := parseDiscordCDNURL(raw)
+	return ok
+}
+
+// parseDiscordCDNURL extracts the channel and attachment IDs from a Discord attachment link
+func parseDiscordCDNURL(raw string) (channelID, attachmentID string, ok bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || !hostInList(u.Hostname(), discordCDNHosts) {
+		return "", "", false
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "attachments" || parts[1] == "" || parts[2] == "" {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// isMediaDownloadError reports whether the image could not be fetched, by Sightengine or
+// (on the upload path) by the bot itself. An image over the size limit won't shrink with a
+// fresh link, so it doesn't count
+func isMediaDownloadError(err error) bool {
+	return errors.Is(err, ErrBadImage) && !errors.Is(err, errImageTooLarge)
+}
+
+// refreshDiscordCDNURL looks up the message that owns a Discord attachment and returns the
+// attachment's current (freshly signed) URL. Attachment IDs are snowflakes minted alongside
+// their message, so messages around that ID are fetched and searched for the attachment
+func refreshDiscordCDNURL(s Responder, rawURL string) (string, error) {
+	channelID, attachmentID, ok := parseDiscordCDNURL(rawURL)
+	if !ok {
+		return "", errors.New("not a Discord attachment link")
+	}
+	msgs, err := s.ChannelMessages(channelID, 50, "", "", attachmentID)
+	if err != nil {
+		return "", fmt.Errorf("fetch messages: %w", err)
+	}
+	for _, m := range msgs {
+		for _, a := range m.Attachments {
+			if a.ID == attachmentID && a.URL != "" {
+				return a.URL, nil
+			}
+		}
+	}
+	return "", errors.New("attachment not found; the message may have been deleted")
+}
+
+// withCDNRefresh runs call for imageURL and, when a Discord attachment link fails to download
+// (typically an expired signature), retries once with a refreshed link. It returns the URL
+// that was used last so results can reference the working link
+func withCDNRefresh(s Responder, imageURL string, call func(imageURL string) error) (string, error) {
+	err := call(imageURL)
+	if err == nil || !isMediaDownloadError(err) || !isDiscordCDNURL(imageURL) {
+		return imageURL, err
+	}
+	fresh, rerr := refreshDiscordCDNURL(s, imageURL)
+	if rerr != nil {
+		log.Printf("refresh discord attachment link failed: %v", rerr)
+		return imageURL, fmt.Errorf("%w: %v", errDiscordLinkExpired, err)
+	}
+	return fresh, call(fresh)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Discord embed and message content limits (https://discord.com/developers/docs/resources/message#embed-object-embed-limits)
+const (
+	embedTitleLimit       = 256
+	embedDescriptionLimit = 4096
+	embedFieldsLimit      = 25
+	embedFieldNameLimit   = 256
+	embedFieldValueLimit  = 1024
+	embedFooterLimit      = 2048
+	embedAuthorLimit      = 256
+	embedTotalLimit       = 6000
+	messageContentLimit   = 2000
+)
+
+// fitEmbed trims e in place so Discord accepts it: each part is cut to its own limit with
+// an ellipsis, extra fields are dropped, and if the combined length still exceeds
+// embedTotalLimit the longest field values are shortened, then trailing fields removed.
+// The total limit spans every embed in a message, so splitting into more embeds can't help
+func fitEmbed(e *discordgo.MessageEmbed) *discordgo.MessageEmbed {
+	if e == nil {
+		return nil
+	}
+	e.Title = truncateRunes(e.Title, embedTitleLimit)
+	e.Description = truncateRunes(e.Description, embedDescriptionLimit)
+	if e.Footer != nil {
+		e.Footer.Text = truncateRunes(e.Footer.Text, embedFooterLimit)
+	}
+	if e.Author != nil {
+		e.Author.Name = truncateRunes(e.Author.Name, embedAuthorLimit)
+	}
+	if len(e.Fields) > embedFieldsLimit {
+		e.Fields = e.Fields[:embedFieldsLimit]
+	}
+	for _, f := range e.Fields {
+		f.Name = truncateRunes(f.Name, embedFieldNameLimit)
+		f.Value = truncateRunes(f.Value, embedFieldValueLimit)
+	}
+
+	// Shorten the longest field values first, keeping a useful prefix of each
+	const minFieldValue = 64
+	for excess := embedLength(e) - embedTotalLimit; excess > 0; excess = embedLength(e) - embedTotalLimit {
+		var longest *discordgo.MessageEmbedField
+		for _, f := range e.Fields {
+			if longest == nil || utf8.RuneCountInString(f.Value) > utf8.RuneCountInString(longest.Value) {
+				longest = f
+			}
+		}
+		n := 0
+		if longest != nil {
+			n = utf8.RuneCountInString(longest.Value)
+		}
+		if n <= minFieldValue {
+			break
+		}
+		longest.Value = truncateRunes(longest.Value, max(n-excess, minFieldValue))
+	}
+	// Still too long: drop trailing fields, then fall back to trimming the description
+	for len(e.Fields) > 0 && embedLength(e) > embedTotalLimit {
+		e.Fields = e.Fields[:len(e.Fields)-1]
+	}
+	if excess := embedLength(e) - embedTotalLimit; excess > 0 {
+		e.Description = truncateRunes(e.Description, max(utf8.RuneCountInString(e.Description)-excess, 0))
+	}
+	return e
+}
+
+// fitWebhookEdit applies fitEmbed to every embed of edit and cuts its content to
+// messageContentLimit, for responses whose text comes from user input or upstream APIs
+func fitWebhookEdit(edit *discordgo.WebhookEdit) *discordgo.WebhookEdit {
+	if edit.Content != nil {
+		content := truncateRunes(*edit.Content, messageContentLimit)
+		edit.Content = &content
+	}
+	if edit.Embeds != nil {
+		for _, e := range *edit.Embeds {
+			fitEmbed(e)
+		}
+	}
+	return edit
+}
+
+// embedLength counts the characters Discord includes in the embed total
+func embedLength(e *discordgo.MessageEmbed) int {
+	n := utf8.RuneCountInString(e.Title) + utf8.RuneCountInString(e.Description)
+	if e.Footer != nil {
+		n += utf8.RuneCountInString(e.Footer.Text)
+	}
+	if e.Author != nil {
+		n += utf8.RuneCountInString(e.Author.Name)
+	}
+	for _, f := range e.Fields {
+		n += utf8.RuneCountInString(f.Name) + utf8.RuneCountInString(f.Value)
+	}
+	return n
+}
+
+// truncateRunes cuts s to at most limit characters, ending with "…" when shortened
+func truncateRunes(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	if limit <= 0 {
+		return ""
+	}
+	r := []rune(s)
+	return string(r[:limit-1]) + "…"
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// runes returns n copies of a multi-byte character, so byte and rune limits differ
+func runes(n int) string {
+	return strings.Repeat("界", n)
+}
+
+func TestFitEmbedPartLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		limit int
+		set   func(e *discordgo.MessageEmbed, s string)
+		get   func(e *discordgo.MessageEmbed) string
+	}{
+		{"title", embedTitleLimit,
+			func(e *discordgo.MessageEmbed, s string) { e.Title = s },
+			func(e *discordgo.MessageEmbed) string { return e.Title }},
+		{"description", embedDescriptionLimit,
+			func(e *discordgo.MessageEmbed, s string) { e.Description = s },
+			func(e *discordgo.MessageEmbed) string { return e.Description }},
+		{"field name", embedFieldNameLimit,
+			func(e *discordgo.MessageEmbed, s string) {
+				e.Fields = []*discordgo.MessageEmbedField{{Name: s, Value: "v"}}
+			},
+			func(e *discordgo.MessageEmbed) string { return e.Fields[0].Name }},
+		{"field value", embedFieldValueLimit,
+			func(e *discordgo.MessageEmbed, s string) {
+				e.Fields = []*discordgo.MessageEmbedField{{Name: "n", Value: s}}
+			},
+			func(e *discordgo.MessageEmbed) string { return e.Fields[0].Value }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &discordgo.MessageEmbed{}
+			tc.set(e, runes(tc.limit))
+			if got := tc.get(fitEmbed(e)); got != runes(tc.limit) {
+				t.Errorf("%d runes (exactly the limit) changed to %d runes", tc.limit, utf8.RuneCountInString(got))
+			}
+			e = &discordgo.MessageEmbed{}
+			tc.set(e, runes(tc.limit+1))
+			got := tc.get(fitEmbed(e))
+			if want := runes(tc.limit-1) + "…"; got != want {
+				t.Errorf("%d runes: got %d runes ending %q, want %d ending in …",
+					tc.limit+1, utf8.RuneCountInString(got), got[len(got)-min(len(got), 6):], tc.limit)
+			}
+			if !utf8.ValidString(got) {
+				t.Error("truncation split a multi-byte rune")
+			}
+		})
+	}
+}
+
+func TestFitEmbedFieldCount(t *testing.T) {
+	fields := func(n int) []*discordgo.MessageEmbedField {
+		out := make([]*discordgo.MessageEmbedField, n)
+		for i := range out {
+			out[i] = &discordgo.MessageEmbedField{Name: "n", Value: "v"}
+		}
+		return out
+	}
+	if got := len(fitEmbed(&discordgo.MessageEmbed{Fields: fields(embedFieldsLimit)}).Fields); got != embedFieldsLimit {
+		t.Errorf("%d fields: kept %d", embedFieldsLimit, got)
+	}
+	if got := len(fitEmbed(&discordgo.MessageEmbed{Fields: fields(embedFieldsLimit + 1)}).Fields); got != embedFieldsLimit {
+		t.Errorf("%d fields: kept %d, want %d", embedFieldsLimit+1, got, embedFieldsLimit)
+	}
+}
+
+func TestFitEmbedTotalLimit(t *testing.T) {
+	// embed is exactly embedTotalLimit runes plus extra: title 100, description 900+extra and five 1000-rune fields
+	embed := func(extra int) *discordgo.MessageEmbed {
+		e := &discordgo.MessageEmbed{Title: runes(100), Description: runes(900 + extra)}
+		for range 5 {
+			e.Fields = append(e.Fields, &discordgo.MessageEmbedField{Name: runes(10), Value: runes(990)})
+		}
+		return e
+	}
+	if e := fitEmbed(embed(0)); embedLength(e) != embedTotalLimit || e.Description != runes(900) {
+		t.Errorf("exactly %d runes: fitted to %d", embedTotalLimit, embedLength(e))
+	}
+
+	e := fitEmbed(embed(1))
+	if n := embedLength(e); n != embedTotalLimit {
+		t.Errorf("%d runes: fitted to %d, want %d", embedTotalLimit+1, n, embedTotalLimit)
+	}
+	if len(e.Fields) != 5 || e.Description != runes(901) {
+		t.Error("the total should be met by shortening a field value, not dropping fields or the description")
+	}
+
+	// Names can't be shortened, so trailing fields go once values are at their minimum
+	e = &discordgo.MessageEmbed{}
+	for range embedFieldsLimit {
+		e.Fields = append(e.Fields, &discordgo.MessageEmbedField{Name: runes(embedFieldNameLimit), Value: "v"})
+	}
+	fitEmbed(e)
+	if n := embedLength(e); n > embedTotalLimit || len(e.Fields) != embedTotalLimit/(embedFieldNameLimit+1) {
+		t.Errorf("long names: %d fields, %d runes", len(e.Fields), n)
+	}
+	for _, f := range e.Fields {
+		if !utf8.ValidString(f.Name) || !utf8.ValidString(f.Value) {
+			t.Fatal("fitting produced invalid UTF-8")
+		}
+	}
+}
+
+func TestFitWebhookEdit(t *testing.T) {
+	content := runes(messageContentLimit + 1)
+	embeds := []*discordgo.MessageEmbed{{Title: runes(embedTitleLimit + 1)}, {Description: runes(embedDescriptionLimit + 1)}}
+	edit := fitWebhookEdit(&discordgo.WebhookEdit{Content: &content, Embeds: &embeds})
+
+	if got := utf8.RuneCountInString(*edit.Content); got != messageContentLimit || !strings.HasSuffix(*edit.Content, "…") {
+		t.Errorf("content fitted to %d runes, want %d ending in …", got, messageContentLimit)
+	}
+	if content != runes(messageContentLimit+1) {
+		t.Error("fitWebhookEdit modified the caller's content string")
+	}
+	if got := utf8.RuneCountInString((*edit.Embeds)[0].Title); got != embedTitleLimit {
+		t.Errorf("title fitted to %d runes", got)
+	}
+	if got := utf8.RuneCountInString((*edit.Embeds)[1].Description); got != embedDescriptionLimit {
+		t.Errorf("description fitted to %d runes", got)
+	}
+
+	exact := runes(messageContentLimit)
+	if got := fitWebhookEdit(&discordgo.WebhookEdit{Content: &exact}); *got.Content != exact {
+		t.Error("content at exactly the limit was changed")
+	}
+	if got := fitWebhookEdit(&discordgo.WebhookEdit{}); got.Content != nil || got.Embeds != nil {
+		t.Error("empty edit gained content or embeds")
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	ascii := func(n int) string { return strings.Repeat("a", n) }
+	for _, tc := range []struct {
+		name, in string
+		limit    int
+		want     string
+	}{
+		{"ascii at limit", ascii(2000), 2000, ascii(2000)},
+		{"ascii over limit", ascii(2001), 2000, ascii(1999) + "…"},
+		{"multi-byte at limit", runes(2000), 2000, runes(2000)},
+		{"multi-byte over limit", runes(2001), 2000, runes(1999) + "…"},
+		{"emoji over limit", strings.Repeat("🙂", 3), 2, "🙂…"},
+		{"under limit", "abc", 2000, "abc"},
+		{"limit 1", "abc", 1, "…"},
+		{"limit 0", "abc", 0, ""},
+		{"empty at limit 0", "", 0, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := truncateRunes(tc.in, tc.limit)
+			if got != tc.want {
+				t.Errorf("got %d runes, want %d", utf8.RuneCountInString(got), utf8.RuneCountInString(tc.want))
+			}
+			if !utf8.ValidString(got) {
+				t.Error("result is not valid UTF-8")
+			}
+		})
+	}
+}
+
+func TestRespondEphemeralFitsMessageLimit(t *testing.T) {
+	for _, n := range []int{messageContentLimit, messageContentLimit + 1} {
+		f := &fakeResponder{}
+		if err := respondEphemeral(f, testCommand("ping"), runes(n)); err != nil {
+			t.Fatal(err)
+		}
+		got := f.responses[0].Data.Content
+		if c := utf8.RuneCountInString(got); c != messageContentLimit {
+			t.Errorf("%d runes sent as %d, want %d", n, c, messageContentLimit)
+		}
+		if wantCut := n > messageContentLimit; strings.HasSuffix(got, "…") != wantCut {
+			t.Errorf("%d runes: ellipsis = %v, want %v", n, !wantCut, wantCut)
+		}
+	}
+}
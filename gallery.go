@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Gallery limits: at most maxGalleryImages are analysed per album (each one is a Sightengine call)
+const maxGalleryImages = 10
+
+// discordAppHosts serve Discord message links (https://discord.com/channels/<guild>/<channel>/<message>)
+var discordAppHosts = []string{"discord.com", "discordapp.com"}
+
+// imgurHosts serve Imgur albums (https://imgur.com/a/<id>, https://imgur.com/gallery/<id>)
+var imgurHosts = []string{"imgur.com"}
+
+// Gallery link kinds recognised by parseGalleryLink
+const (
+	galleryDiscordMessage = "discord_message"
+	galleryImgurAlbum     = "imgur_album"
+)
+
+// galleryLink is a parsed link to something holding several images
+type galleryLink struct {
+	Kind      string
+	GuildID   string // Discord message links only
+	ChannelID string // Discord message links only
+	ID        string // message ID or Imgur album ID
+}
+
+// parseGalleryLink recognises Discord message links and Imgur album/gallery links
+func parseGalleryLink(raw string) (galleryLink, bool) {
+	s := strings.Trim(strings.TrimSpace(raw), "<>")
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return galleryLink{}, false
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case hostInList(u.Hostname(), discordAppHosts):
+		if len(parts) == 4 && parts[0] == "channels" && parts[1] != "" && parts[2] != "" && parts[3] != "" {
+			return galleryLink{Kind: galleryDiscordMessage, GuildID: parts[1], ChannelID: parts[2], ID: parts[3]}, true
+		}
+	case hostInList(u.Hostname(), imgurHosts):
+		if len(parts) == 2 && (parts[0] == "a" || parts[0] == "gallery") && parts[1] != "" {
+			// Newer links carry a title slug before the ID ("/a/some-title-AbC123")
+			id := parts[1][strings.LastIndex(parts[1], "-")+1:]
+			if id != "" {
+				return galleryLink{Kind: galleryImgurAlbum, ID: id}, true
+			}
+		}
+	}
+	return galleryLink{}, false
+}
+
+// errChannelNotReadable is returned when the invoking user can't read the linked channel
+var errChannelNotReadable = errors.New("you can only use messages from channels you can read")
+
+// resolveGallery returns the image URLs behind a gallery link. The bot reads messages with its
+// own permissions, so Discord message links must point into guildID and into a channel userID
+// can view and read the history of; otherwise members could read other servers' or private
+// channels through the bot
+func resolveGallery(ctx context.Context, s Responder, guildID, userID string, link galleryLink) ([]string, error) {
+	switch link.Kind {
+	case galleryDiscordMessage:
+		if guildID == "" || link.GuildID != guildID {
+			return nil, errors.New("message links must point to a message in this server")
+		}
+		if err := requireChannelReadable(s, userID, link.ChannelID); err != nil {
+			return nil, err
+		}
+		m, err := s.ChannelMessage(link.ChannelID, link.ID, discordgo.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("fetch message: %w", err)
+		}
+		return messageImageURLs(m), nil
+	case galleryImgurAlbum:
+		return imgurAlbumImages(ctx, link.ID)
+	}
+	return nil, errors.New("unsupported gallery link")
+}
+
+// requireChannelReadable returns errChannelNotReadable unless userID has View Channel and
+// Read Message History in channelID
+func requireChannelReadable(s Responder, userID, channelID string) error {
+	if userID == "" {
+		return errChannelNotReadable
+	}
+	p, err := s.UserChannelPermissions(userID, channelID)
+	if err != nil {
+		return fmt.Errorf("check channel permissions: %w", err)
+	}
+	const need = discordgo.PermissionViewChannel | discordgo.PermissionReadMessageHistory
+	if p&need != need {
+		return errChannelNotReadable
+	}
+	return nil
+}
+
+// errChannelNotWritable is returned when the invoking user can't post in the target channel
+var errChannelNotWritable = errors.New("you can only send alerts to channels you can post in")
+
+// requireChannelWritable returns errChannelNotWritable unless userID has View Channel and
+// Send Messages in channelID. The bot posts with its own permissions, so this keeps members
+// from pointing its output at channels they couldn't write to themselves
+func requireChannelWritable(s Responder, userID, channelID string) error {
+	if userID == "" {
+		return errChannelNotWritable
+	}
+	p, err := s.UserChannelPermissions(userID, channelID)
+	if err != nil {
+		return fmt.Errorf("check channel permissions: %w", err)
+	}
+	const need = discordgo.PermissionViewChannel | discordgo.PermissionSendMessages
+	if p&need != need {
+		return errChannelNotWritable
+	}
+	return nil
+}
+
+// messageImageURLs collects the images attached to or embedded in a message, in order
+func messageImageURLs(m *discordgo.Message) []string {
+	var urls []string
+	for _, a := range m.Attachments {
+		if strings.HasPrefix(a.ContentType, "image/") || hasImageExtension(a.Filename) {
+			urls = append(urls, a.URL)
+		}
+	}
+	for _, e := range m.Embeds {
+		switch {
+		case e.Image != nil && e.Image.URL != "":
+			urls = append(urls, e.Image.URL)
+		case e.Type == discordgo.EmbedTypeImage && e.Thumbnail != nil && e.Thumbnail.URL != "":
+			urls = append(urls, e.Thumbnail.URL)
+		}
+	}
+	return urls
+}
+
+// imgurAlbumImages lists an Imgur album's image links through the Imgur API (needs IMGUR_CLIENT_ID)
+func imgurAlbumImages(ctx context.Context, albumID string) ([]string, error) {
+	clientID := strings.TrimSpace(os.Getenv("IMGUR_CLIENT_ID"))
+	if clientID == "" {
+		return nil, errors.New("Imgur albums need IMGUR_CLIENT_ID to be configured")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.imgur.com/3/album/"+url.PathEscape(albumID)+"/images", nil)
+	if err != nil {
+		return nil, fmt.Errorf("imgur album: %w", err)
+	}
+	req.Header.Set("Authorization", "Client-ID "+clientID)
+	resp, err := sharedHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("imgur album: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.New("imgur album not found")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("imgur album: unexpected status %d", resp.StatusCode)
+	}
+	var body struct {
+		Data []struct {
+			Link string `json:"link"`
+			Type string `json:"type"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("imgur album: decode response: %w", err)
+	}
+	urls := make([]string, 0, len(body.Data))
+	for _, img := range body.Data {
+		if img.Link != "" && strings.HasPrefix(img.Type, "image/") {
+			urls = append(urls, img.Link)
+		}
+	}
+	return urls, nil
+}
@@ -1,8 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -11,42 +23,173 @@ import (
 	"github.com/bwmarrin/discordgo"
 )
 
+// interactionTokenLifetime is how long Discord accepts follow-ups/edits for an interaction
+const interactionTokenLifetime = 15 * time.Minute
+
+// interactionContext derives a context for work done on behalf of an interaction. It is
+// cancelled on shutdown and expires shortly before the interaction token does, so slow
+// upstream calls can't outlive the window in which their result could be delivered
+func interactionContext(i *discordgo.InteractionCreate) (context.Context, context.CancelFunc) {
+	created := time.Now()
+	if ts, err := discordgo.SnowflakeTimestamp(i.ID); err == nil {
+		created = ts
+	}
+	// Sightengine calls made with this context count towards the guild's monthly usage
+	return context.WithDeadline(withUsageGuild(appCtx, i.GuildID), created.Add(interactionTokenLifetime-30*time.Second))
+}
+
 // respondEphemeral sends an ephemeral message visible only to the invoking user
-func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, content string) error {
+func respondEphemeral(s Responder, i *discordgo.InteractionCreate, content string) error {
 	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
-			Content: content,
+			Content: truncateRunes(content, messageContentLimit),
 			Flags:   discordgo.MessageFlagsEphemeral,
 		},
 	})
 }
 
-// registerHandlers wires all slash command handlers onto the session
-func registerHandlers(sess *discordgo.Session) {
-	// Apply Rich Presence on READY
-	sess.AddHandler(onReadySetPresence)
+// respondNoPermission sends an ephemeral denial. When the guild enables show_allowed_roles
+// and moderator roles are configured, they are listed by name so users know whom to ask;
+// mentions are suppressed as well so nobody is pinged
+func respondNoPermission(s *discordgo.Session, i *discordgo.InteractionCreate, content string) error {
+	gs, err := settingsStore.Get(i.GuildID)
+	if err != nil {
+		log.Println("guild settings read error:", err)
+	}
+	if i.GuildID != "" && gs.ShowAllowedRoles {
+		if roles := perms.ListRoles(i.GuildID); len(roles) > 0 {
+			content += "\nRoles with access: " + FormatRoleNames(s, i.GuildID, roles)
+		}
+	}
+	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:         truncateRunes(content, messageContentLimit),
+			Flags:           discordgo.MessageFlagsEphemeral,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	})
+}
+
+// unknownUserMessage answers interactions that carry neither Member.User nor User, so actions
+// that are audited or tied to the invoker can't be attributed
+const unknownUserMessage = "Couldn't identify who ran this command. Please try again."
+
+// thresholdsNeedDBMessage answers threshold changes in file-storage mode
+const thresholdsNeedDBMessage = "Changing thresholds requires a database backend (`PERMS_DSN`); this bot is currently using file storage, so nothing was changed."
+
+// interactionHandler is the signature shared by all interaction handlers.
+// It must stay an alias: discordgo's AddHandler type-switches on the unnamed func type
+type interactionHandler = func(s *discordgo.Session, i *discordgo.InteractionCreate)
+
+// Responder is the slice of *discordgo.Session that handler bodies and reply helpers use.
+// Depending on it instead of the concrete session lets them run against a fake in tests;
+// gateway state (shards, guild cache) is still read from the session by the thin handle* wrappers
+type Responder interface {
+	InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error
+	InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	HeartbeatLatency() time.Duration
+	ChannelMessage(channelID, messageID string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	UserChannelPermissions(userID, channelID string, fetchOptions ...discordgo.RequestOption) (int64, error)
+	ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error)
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error)
+}
+
+// The real session must keep satisfying Responder
+var _ Responder = (*discordgo.Session)(nil)
 
-	// /permissions <add|remove|list>
-	sess.AddHandler(handlePermissions)
+// safeHandler wraps an interaction handler so a panic is logged with a stack trace
+// and answered with a generic ephemeral error instead of crashing the process. It is generic
+// over the Responder so it returns an interactionHandler for *discordgo.Session handlers
+// and can wrap handlers taking a fake in tests
+func safeHandler[R Responder](h func(s R, i *discordgo.InteractionCreate)) func(s R, i *discordgo.InteractionCreate) {
+	return func(s R, i *discordgo.InteractionCreate) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			name := "unknown"
+			if i.Type == discordgo.InteractionApplicationCommand {
+				name = i.ApplicationCommandData().Name
+			}
+			log.Printf("panic in handler (command=%s user=%s guild=%s): %v\n%s", name, interactionUserID(i), i.GuildID, r, debug.Stack())
+			msg := "Something went wrong while handling this command. Please try again later."
+			// Respond if nothing was sent yet, otherwise edit the deferred response
+			if err := respondEphemeral(s, i, msg); err != nil {
+				_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &msg})
+			}
+		}()
+		h(s, i)
+	}
+}
+
+// requireEnabled skips h and tells the user when the named command is disabled in this guild
+// (see /commands). Only interactions for that command are gated, so each wrapper answers at most once
+func requireEnabled(name string, h interactionHandler) interactionHandler {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		if i.Type == discordgo.InteractionApplicationCommand && i.ApplicationCommandData().Name == name {
+			enabled, err := settingsStore.CommandEnabled(i.GuildID, name)
+			if err != nil {
+				log.Println("guild settings read error:", err)
+				_ = respondEphemeral(s, i, settingsUnavailableMessage)
+				return
+			}
+			if !enabled {
+				_ = respondEphemeral(s, i, "This command is disabled on this server.")
+				return
+			}
+		}
+		h(s, i)
+	}
+}
 
-	// /analyse <image_url> [advanced]
-	sess.AddHandler(handleAnalyse)
+// settingsUnavailableMessage answers commands that need the guild's settings when they can't be
+// loaded; running with the defaults could undo restrictions the guild configured
+const settingsUnavailableMessage = "Couldn't load this server's settings. Please try again in a moment."
 
-	// /ai <image_url>
-	sess.AddHandler(handleAI)
+// videoUnsupportedMessage answers video links in commands that analyse images and GIFs
+const videoUnsupportedMessage = "Videos aren't supported; use an image or GIF link."
 
-	// /ping
-	sess.AddHandler(handlePing)
+// onCooldown answers with the time left and reports true when the guild's cooldown for command
+// (command_cooldowns) hasn't passed since its last use; otherwise it starts a new cooldown.
+// Handlers call it once the input is validated, right before deferring, so a rejected
+// invocation doesn't use up the guild's cooldown
+func onCooldown(s Responder, i *discordgo.InteractionCreate, command string) bool {
+	gs, err := settingsStore.Get(i.GuildID)
+	if err != nil {
+		log.Println("guild settings read error:", err)
+		_ = respondEphemeral(s, i, settingsUnavailableMessage)
+		return true
+	}
+	wait := commandCooldowns.Take(i.GuildID, command, gs.CommandCooldowns[command])
+	if wait <= 0 {
+		return false
+	}
+	secs := int((wait + time.Second - 1) / time.Second)
+	_ = respondEphemeral(s, i, fmt.Sprintf("/%s is on cooldown on this server. Try again in %d second(s).", command, secs))
+	return true
+}
 
-	// /help
-	sess.AddHandler(handleHelp)
+// registerHandlers wires all slash command handlers onto the session
+func registerHandlers(sess *discordgo.Session) {
+	// Apply Rich Presence on READY
+	sess.AddHandler(onReadySetPresence)
 
-	// /thresholds [list|history|set|reset]
-	sess.AddHandler(handleThresholds)
+	// Slash commands (see commandSpecs); toggleable ones are gated by /commands. Every command is
+	// logged, timed and counted for /stats by withTelemetry
+	for _, spec := range commandSpecs() {
+		handler := spec.Handler
+		if slices.Contains(toggleableCommands, spec.Command.Name) {
+			handler = requireEnabled(spec.Command.Name, handler)
+		}
+		sess.AddHandler(safeHandler(withTelemetry(spec.Command.Name, handler)))
+	}
 
-	// /reverse <image_url>
-	sess.AddHandler(handleReverse)
+	// Confirm/Cancel buttons for destructive actions (see confirmAction)
+	sess.AddHandler(safeHandler(handleConfirmComponent))
 }
 
 // -------------------------
@@ -57,21 +200,31 @@ func handlePermissions(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		return
 	}
 
+	// Role whitelists are per guild; DMs have no roles to manage
+	if i.GuildID == "" {
+		_ = respondEphemeral(s, i, "This command can only be used inside a server.")
+		return
+	}
+	if interactionUserID(i) == "" {
+		_ = respondEphemeral(s, i, unknownUserMessage)
+		return
+	}
+
 	// Only owner or admins can manage permissions
-	if !(IsOwner(i.Member.User.ID) || HasAdminContextPermission(i)) {
+	if !CanManageGuild(i) {
 		_ = respondEphemeral(s, i, "You don't have permission to manage the whitelist.")
 		return
 	}
 
 	data := i.ApplicationCommandData()
 	if len(data.Options) == 0 {
-		_ = respondEphemeral(s, i, "Missing subcommand. Use add, remove or list.")
+		_ = respondEphemeral(s, i, "Missing subcommand. Use add, bulkadd, remove, list or history.")
 		return
 	}
 
 	sub := data.Options[0]
 	switch sub.Name {
-	case "add", "remove", "list":
+	case "add", "bulkadd", "remove", "list", "history":
 	default:
 		_ = respondEphemeral(s, i, "Unknown subcommand.")
 		return
@@ -99,9 +252,12 @@ func handlePermissions(s *discordgo.Session, i *discordgo.InteractionCreate) {
 			return
 		}
 		perms.AddRole(i.GuildID, roleID)
+		if err := perms.LogChange(i.GuildID, roleID, PermActionAdd, interactionUserID(i)); err != nil {
+			log.Println("permissions history log error:", err)
+		}
 		list := perms.ListRoles(i.GuildID)
 		val := FormatRoleList(s, i.GuildID, list)
-		embed := &discordgo.MessageEmbed{
+		embed := fitEmbed(&discordgo.MessageEmbed{
 			Title:       "Permissions Updated",
 			Description: "Added role <@&" + roleID + ">",
 			Color:       0x2ECC71,
@@ -109,8 +265,66 @@ func handlePermissions(s *discordgo.Session, i *discordgo.InteractionCreate) {
 				Name:   "Allowed Roles",
 				Value:  val,
 				Inline: false}},
-			Footer: &discordgo.MessageEmbedFooter{Text: FooterText}}
-		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
+			Footer: &discordgo.MessageEmbedFooter{Text: FooterText}})
+		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed},
+			AllowedMentions: &discordgo.MessageAllowedMentions{}})
+
+	case "bulkadd":
+		var rolesArg string
+		for _, opt := range sub.Options {
+			if opt.Name == "roles" {
+				rolesArg = opt.StringValue()
+			}
+		}
+		known, err := guildRoleIDs(s, i.GuildID)
+		if err != nil {
+			log.Println("permissions bulkadd: fetch roles:", err)
+			msg := "Couldn't fetch this server's roles. Please try again."
+			_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &msg})
+			return
+		}
+		var added, unresolved []string
+		for _, tok := range strings.FieldsFunc(rolesArg, func(r rune) bool { return r == ',' || r == ' ' }) {
+			id := tok
+			if m := roleMentionRe.FindStringSubmatch(tok); m != nil {
+				id = m[1]
+			}
+			if _, ok := known[id]; !ok {
+				unresolved = append(unresolved, tok)
+				continue
+			}
+			if slices.Contains(added, id) {
+				continue
+			}
+			perms.AddRole(i.GuildID, id)
+			if err := perms.LogChange(i.GuildID, id, PermActionAdd, interactionUserID(i)); err != nil {
+				log.Println("permissions history log error:", err)
+			}
+			added = append(added, id)
+		}
+		if len(added) == 0 {
+			msg := "No roles added: none of the given values are roles in this server."
+			_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &msg, AllowedMentions: &discordgo.MessageAllowedMentions{}})
+			return
+		}
+		mentions := make([]string, len(added))
+		for n, id := range added {
+			mentions[n] = "<@&" + id + ">"
+		}
+		fields := []*discordgo.MessageEmbedField{{
+			Name:  "Allowed Roles",
+			Value: FormatRoleList(s, i.GuildID, perms.ListRoles(i.GuildID)), Inline: false}}
+		if len(unresolved) > 0 {
+			fields = append(fields, &discordgo.MessageEmbedField{Name: "Not Resolved", Value: "`" + strings.Join(unresolved, "`, `") + "`", Inline: false})
+		}
+		embed := fitEmbed(&discordgo.MessageEmbed{
+			Title:       "Permissions Updated",
+			Description: "Added roles " + strings.Join(mentions, ", "),
+			Color:       0x2ECC71,
+			Fields:      fields,
+			Footer:      &discordgo.MessageEmbedFooter{Text: FooterText}})
+		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed},
+			AllowedMentions: &discordgo.MessageAllowedMentions{}})
 
 	case "remove":
 		var roleID string
@@ -125,30 +339,72 @@ func handlePermissions(s *discordgo.Session, i *discordgo.InteractionCreate) {
 			return
 		}
 		perms.RemoveRole(i.GuildID, roleID)
+		if err := perms.LogChange(i.GuildID, roleID, PermActionRemove, interactionUserID(i)); err != nil {
+			log.Println("permissions history log error:", err)
+		}
 		list := perms.ListRoles(i.GuildID)
 		val := FormatRoleList(s, i.GuildID, list)
-		embed := &discordgo.MessageEmbed{
+		embed := fitEmbed(&discordgo.MessageEmbed{
 			Title:       "Permissions Updated",
 			Description: "Removed role <@&" + roleID + ">",
 			Color:       0xE74C3C,
 			Fields: []*discordgo.MessageEmbedField{{
 				Name:  "Allowed Roles",
 				Value: val, Inline: false}},
-			Footer: &discordgo.MessageEmbedFooter{Text: FooterText}}
-		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
+			Footer: &discordgo.MessageEmbedFooter{Text: FooterText}})
+		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed},
+			AllowedMentions: &discordgo.MessageAllowedMentions{}})
 
 	case "list":
 		list := perms.ListRoles(i.GuildID)
 		val := FormatRoleList(s, i.GuildID, list)
-		embed := &discordgo.MessageEmbed{
+		embed := fitEmbed(&discordgo.MessageEmbed{
 			Title:       "Permissions",
 			Description: "Roles allowed to use restricted commands",
 			Color:       0x3498DB,
 			Fields: []*discordgo.MessageEmbedField{{
 				Name:  "Allowed Roles",
 				Value: val, Inline: false}},
-			Footer: &discordgo.MessageEmbedFooter{Text: FooterText}}
-		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
+			Footer: &discordgo.MessageEmbedFooter{Text: FooterText}})
+		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed},
+			AllowedMentions: &discordgo.MessageAllowedMentions{}})
+
+	case "history":
+		limit := 10
+		for _, opt := range sub.Options {
+			if opt.Name == "limit" {
+				limit = int(opt.IntValue())
+			}
+		}
+		changes, err := perms.History(i.GuildID, limit)
+		if err != nil {
+			log.Println("permissions history error:", err)
+			msg := "Failed to fetch history"
+			_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &msg})
+			return
+		}
+		if len(changes) == 0 {
+			msg := "No history available."
+			_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &msg})
+			return
+		}
+		fields := make([]*discordgo.MessageEmbedField, 0, len(changes))
+		for _, c := range changes {
+			user := "unknown"
+			if c.UserID.Valid && c.UserID.String != "" {
+				user = "<@" + c.UserID.String + ">"
+			}
+			action := "Added"
+			if c.Action == PermActionRemove {
+				action = "Removed"
+			}
+			val := fmt.Sprintf("%s <@&%s>\nBy: %s\nAt: %s", action, c.RoleID, user, formatHistoryTime(c.Created))
+			fields = append(fields, &discordgo.MessageEmbedField{Name: "Change", Value: val, Inline: false})
+		}
+		embed := fitEmbed(&discordgo.MessageEmbed{Title: "Permissions History", Color: 0x8E44AD, Fields: fields, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}})
+		// Mentions render but never ping
+		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed},
+			AllowedMentions: &discordgo.MessageAllowedMentions{}})
 	}
 }
 
@@ -160,7 +416,11 @@ func handleAnalyse(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		return
 	}
 	if !perms.IsAllowedForRestricted(i) {
-		_ = respondEphemeral(s, i, "You don't have permission to use this command.")
+		_ = respondNoPermission(s, i, "You don't have permission to use this command.")
+		return
+	}
+	if usageStore.OverQuota(i.GuildID) {
+		_ = respondEphemeral(s, i, overQuotaMessage)
 		return
 	}
 	analyseCommandHandlerBody(s, i)
@@ -174,7 +434,11 @@ func handleAI(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		return
 	}
 	if !perms.IsAllowedForRestricted(i) {
-		_ = respondEphemeral(s, i, "You don't have permission to use this command.")
+		_ = respondNoPermission(s, i, "You don't have permission to use this command.")
+		return
+	}
+	if usageStore.OverQuota(i.GuildID) {
+		_ = respondEphemeral(s, i, overQuotaMessage)
 		return
 	}
 	aiCommandHandlerBody(s, i)
@@ -188,31 +452,64 @@ func handleReverse(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		return
 	}
 	if !perms.IsAllowedForRestricted(i) {
-		_ = respondEphemeral(s, i, "You don't have permission to use this command.")
+		_ = respondNoPermission(s, i, "You don't have permission to use this command.")
 		return
 	}
-	var imageURL string
+	var imageURL, region string
+	var safe *bool
 	for _, opt := range i.ApplicationCommandData().Options {
-		if opt.Name == "image_url" {
+		switch opt.Name {
+		case "image_url":
 			imageURL = opt.StringValue()
+		case "region":
+			region = opt.StringValue()
+		case "safe":
+			v := opt.BoolValue()
+			safe = &v
 		}
 	}
 	if imageURL == "" {
 		_ = respondEphemeral(s, i, "Missing `image_url`.")
 		return
 	}
+	imageURL, err := normalizeImageURL(imageURL)
+	if err != nil {
+		_ = respondEphemeral(s, i, "Invalid `image_url`: "+err.Error())
+		return
+	}
+	region, err = parseReverseRegion(region)
+	if err != nil {
+		_ = respondEphemeral(s, i, "Invalid `region`: "+err.Error())
+		return
+	}
+	if msg := stillImageOnlyMessage(mediaKind(imageURL)); msg != "" {
+		_ = respondEphemeral(s, i, msg)
+		return
+	}
+	if onCooldown(s, i, "reverse") {
+		return
+	}
 	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredChannelMessageWithSource}); err != nil {
 		log.Println("failed to defer reverse interaction:", err)
 		return
 	}
-	res, err := ReverseLookup(imageURL)
+	ctx, cancel := interactionContext(i)
+	defer cancel()
+	ctx = withReverseQueueNotice(ctx, func() {
+		msg := "Reverse image search is busy; you're in the queue and the result will appear here shortly."
+		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &msg})
+	})
+	res, err := ReverseLookup(ctx, ReverseOptions{ImageURL: imageURL, Region: region, Safe: safe})
 	if err != nil {
-		msg := fmt.Sprintf("Reverse image search failed: %v", err)
+		msg := truncateRunes(fmt.Sprintf("Reverse image search failed: %v", err), messageContentLimit)
 		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &msg})
 		return
 	}
 	color := 0x607D8B
 	desc := fmt.Sprintf("Reverse image search for: %s", imageURL)
+	if res.BestGuess != "" {
+		desc = fmt.Sprintf("**Best guess: %s**\n%s", res.BestGuess, desc)
+	}
 	fields := []*discordgo.MessageEmbedField{
 		{Name: "Success", Value: fmt.Sprintf("%t", res.Success), Inline: true},
 	}
@@ -223,7 +520,12 @@ func handleReverse(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		fields = append(fields, &discordgo.MessageEmbedField{Name: "Similar Results", Value: res.SimilarURL, Inline: false})
 	}
 	embed := &discordgo.MessageEmbed{Title: "Reverse Image Search", Description: desc, Color: color, Fields: fields, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}}
-	_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
+	if isHTTPURL(res.Thumbnail) {
+		embed.Image = &discordgo.MessageEmbedImage{URL: res.Thumbnail}
+	}
+	embed = fitEmbed(embed)
+	empty := ""
+	_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &empty, Embeds: &[]*discordgo.MessageEmbed{embed}})
 }
 
 // -------------------------
@@ -233,6 +535,12 @@ func handlePing(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	if i.Type != discordgo.InteractionApplicationCommand || i.ApplicationCommandData().Name != "ping" {
 		return
 	}
+	pingCommandHandlerBody(s, i, func() []*discordgo.MessageEmbedField { return pingDetailFields(s) })
+}
+
+// pingCommandHandlerBody measures and reports latency; details supplies the extra fields
+// for the detailed view, since those read gateway state the Responder doesn't expose
+func pingCommandHandlerBody(s Responder, i *discordgo.InteractionCreate, details func() []*discordgo.MessageEmbedField) {
 	start := time.Now()
 	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredChannelMessageWithSource}); err != nil {
 		log.Println("failed to defer ping:", err)
@@ -251,6 +559,235 @@ func handlePing(s *discordgo.Session, i *discordgo.InteractionCreate) {
 				Inline: true,
 			},
 		}, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}}
+	detailed := false
+	for _, opt := range i.ApplicationCommandData().Options {
+		if opt.Name == "detailed" {
+			detailed = opt.BoolValue()
+		}
+	}
+	if detailed {
+		embed.Fields = append(embed.Fields, details()...)
+	}
+	_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
+}
+
+// pingDetailFields breaks /ping down into gateway and DB state, so gateway, DB and API
+// slowness can be told apart in one reply
+func pingDetailFields(s *discordgo.Session) []*discordgo.MessageEmbedField {
+	shardCount := max(s.ShardCount, 1)
+	s.RLock()
+	lastAck, lastSent := s.LastHeartbeatAck, s.LastHeartbeatSent
+	s.RUnlock()
+	ack := "none yet"
+	if !lastAck.IsZero() {
+		ack = fmt.Sprintf("%s ago", time.Since(lastAck).Round(time.Millisecond))
+	}
+	sent := "none yet"
+	if !lastSent.IsZero() {
+		sent = fmt.Sprintf("%s ago", time.Since(lastSent).Round(time.Millisecond))
+	}
+	session := "no (not identified)"
+	if s.State != nil && s.State.SessionID != "" {
+		session = "yes"
+	}
+	db := "not configured (file storage)"
+	if perms != nil && perms.db != nil {
+		ctx, cancel := context.WithTimeout(appCtx, diagnosticsCheckTimeout)
+		start := time.Now()
+		if err := perms.db.PingContext(ctx); err != nil {
+			db = "failed: " + err.Error()
+		} else {
+			db = fmt.Sprintf("%d ms", time.Since(start).Milliseconds())
+		}
+		cancel()
+	}
+	return []*discordgo.MessageEmbedField{
+		{Name: "Shard", Value: fmt.Sprintf("%d / %d", s.ShardID, shardCount), Inline: true},
+		{Name: "Gateway session", Value: session, Inline: true},
+		{Name: "Last heartbeat", Value: fmt.Sprintf("Sent: %s\nAcked: %s", sent, ack), Inline: true},
+		{Name: "Database ping", Value: db, Inline: true},
+	}
+}
+
+// -------------------------
+// /stats
+// -------------------------
+func handleStats(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand || i.ApplicationCommandData().Name != "stats" {
+		return
+	}
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredChannelMessageWithSource}); err != nil {
+		log.Println("failed to defer stats:", err)
+		return
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	guilds := 0
+	if s.State != nil {
+		guilds = len(s.State.Guilds)
+	}
+	shardCount := s.ShardCount
+	if shardCount == 0 {
+		shardCount = 1
+	}
+	embed := &discordgo.MessageEmbed{Title: "Bot Statistics", Color: 0x1ABC9C,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Uptime", Value: formatUptime(time.Since(startTime)), Inline: true},
+			{Name: "Guilds", Value: fmt.Sprintf("%d", guilds), Inline: true},
+			{Name: "Shard", Value: fmt.Sprintf("%d / %d", s.ShardID, shardCount), Inline: true},
+			{Name: "Memory", Value: fmt.Sprintf("Alloc: %.1f MiB\nSys: %.1f MiB\nGC cycles: %d",
+				float64(mem.Alloc)/(1<<20), float64(mem.Sys)/(1<<20), mem.NumGC), Inline: true},
+			{Name: "Goroutines", Value: fmt.Sprintf("%d", runtime.NumGoroutine()), Inline: true},
+			{Name: "Commands served", Value: fmt.Sprintf("%d", metrics.CommandsTotal()), Inline: true},
+			{Name: "Avg command time", Value: metrics.AverageCommandTime().Round(time.Millisecond).String(), Inline: true},
+		}, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}}
+	_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
+}
+
+// formatUptime renders a duration as a compact "1d 2h 3m 4s" string
+func formatUptime(d time.Duration) string {
+	d = d.Round(time.Second)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	h := d / time.Hour
+	d -= h * time.Hour
+	m := d / time.Minute
+	d -= m * time.Minute
+	sec := d / time.Second
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh %dm %ds", days, h, m, sec)
+	}
+	return fmt.Sprintf("%dh %dm %ds", h, m, sec)
+}
+
+// -------------------------
+// /about
+// -------------------------
+func handleAbout(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand || i.ApplicationCommandData().Name != "about" {
+		return
+	}
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredChannelMessageWithSource}); err != nil {
+		log.Println("failed to defer about:", err)
+		return
+	}
+	embed := &discordgo.MessageEmbed{Title: "About", Description: "ChiefXD Art moderation bot", Color: 0x5865F2,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Version", Value: Version, Inline: true},
+			{Name: "Commit", Value: Commit, Inline: true},
+			{Name: "Built", Value: BuildDate, Inline: true},
+			{Name: "Go", Value: runtime.Version(), Inline: true},
+			{Name: "discordgo", Value: discordgo.VERSION, Inline: true},
+			{Name: "Source", Value: SourceRepoURL, Inline: false},
+		}, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}}
+	_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
+}
+
+// -------------------------
+// /guilds (owner only)
+// -------------------------
+
+// guildsPageSize is how many guilds /guilds lists per page
+const guildsPageSize = 20
+
+func handleGuilds(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand || i.ApplicationCommandData().Name != "guilds" {
+		return
+	}
+	if !IsOwner(interactionUserID(i)) {
+		_ = respondEphemeral(s, i, "Only the bot owner can list the bot's servers.")
+		return
+	}
+	page := 1
+	for _, opt := range i.ApplicationCommandData().Options {
+		if opt.Name == "page" {
+			page = int(opt.IntValue())
+		}
+	}
+
+	s.State.RLock()
+	guilds := make([]*discordgo.Guild, len(s.State.Guilds))
+	copy(guilds, s.State.Guilds)
+	s.State.RUnlock()
+	sort.Slice(guilds, func(a, b int) bool { return strings.ToLower(guilds[a].Name) < strings.ToLower(guilds[b].Name) })
+
+	pages := max((len(guilds)+guildsPageSize-1)/guildsPageSize, 1)
+	page = min(max(page, 1), pages)
+	start := (page - 1) * guildsPageSize
+	lines := make([]string, 0, guildsPageSize)
+	for _, g := range guilds[start:min(start+guildsPageSize, len(guilds))] {
+		name := g.Name
+		if name == "" {
+			name = "(unavailable)"
+		}
+		lines = append(lines, fmt.Sprintf("**%s** — `%s` — %d members", name, g.ID, g.MemberCount))
+	}
+	desc := strings.Join(lines, "\n")
+	if desc == "" {
+		desc = "The bot isn't in any servers."
+	}
+	embed := fitEmbed(&discordgo.MessageEmbed{Title: fmt.Sprintf("Servers (%d)", len(guilds)), Description: desc, Color: 0x5865F2,
+		Footer: &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("Page %d of %d • %s", page, pages, FooterText)}})
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Embeds: []*discordgo.MessageEmbed{embed}, Flags: discordgo.MessageFlagsEphemeral},
+	}); err != nil {
+		log.Println("failed to respond to guilds:", err)
+	}
+}
+
+// -------------------------
+// /diagnostics (owner only)
+// -------------------------
+func handleDiagnostics(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand || i.ApplicationCommandData().Name != "diagnostics" {
+		return
+	}
+	if !IsOwner(interactionUserID(i)) {
+		_ = respondEphemeral(s, i, "Only the bot owner can run diagnostics.")
+		return
+	}
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	}); err != nil {
+		log.Println("failed to defer diagnostics:", err)
+		return
+	}
+	ctx, cancel := interactionContext(i)
+	defer cancel()
+
+	results := runDiagnostics(ctx)
+	fields := make([]*discordgo.MessageEmbedField, 0, len(results)+2)
+	failed := 0
+	for _, r := range results {
+		status := "✅ Pass"
+		switch {
+		case r.Skipped:
+			status = "➖ Skipped"
+		case !r.OK:
+			status = "❌ Fail"
+			failed++
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{Name: r.Name, Value: fmt.Sprintf("%s (%d ms)\n%s", status, r.Took.Milliseconds(), r.Detail), Inline: false})
+	}
+	fields = append(fields, &discordgo.MessageEmbedField{Name: "Sightengine Quota", Value: latestSightengineQuota().String(), Inline: false})
+	fields = append(fields, &discordgo.MessageEmbedField{Name: "Caches",
+		Value: fmt.Sprintf("Repost dedupe: %d response(s), window %s", recentAnalyses.Len(), repostDedupeWindow()), Inline: false})
+	if i.GuildID != "" {
+		sourced := thresholdsStore.GetGuildThresholdsWithSource(perms, i.GuildID)
+		lines := make([]string, 0, len(thresholdNames))
+		for _, name := range thresholdNames {
+			t := sourced[name]
+			lines = append(lines, fmt.Sprintf("%s: %s (%s)", name, formatThresholdPercent(t.Value), t.Source))
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Thresholds (this server)", Value: strings.Join(lines, "\n"), Inline: false})
+	}
+	color, title := 0x2ECC71, "Diagnostics: all checks passed"
+	if failed > 0 {
+		color, title = 0xE74C3C, fmt.Sprintf("Diagnostics: %d check(s) failed", failed)
+	}
+	embed := fitEmbed(&discordgo.MessageEmbed{Title: title, Color: color, Fields: fields, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}})
 	_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
 }
 
@@ -265,19 +802,64 @@ func handleHelp(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		log.Println("failed to defer help:", err)
 		return
 	}
-	embed := &discordgo.MessageEmbed{Title: "Help", Description: "Available commands", Color: 0x5865F2,
-		Fields: []*discordgo.MessageEmbedField{
-			{Name: "/ai", Value: "Checks an Image URL for AI usage\nArguments: `image_url` (required)", Inline: false},
-			{Name: "/analyse", Value: "Analyses an Image URL for inappropriate content\nArguments:\n- `image_url` (required)\n- `advanced` (optional): `true` shows detailed category and subcategory scores", Inline: false},
-			{Name: "/help", Value: "Shows this message", Inline: false},
-			{Name: "/permissions", Value: "Manage which roles can use moderator-only commands (owner/admin only)", Inline: false},
-			{Name: "/ping", Value: "Displays the bot's response time", Inline: false},
-			{Name: "/reverse", Value: "Performs a reverse image search on an Image URL\nArguments: `image_url` (required)", Inline: false},
-			{Name: "/thresholds", Value: "Shows or modifies detection thresholds\nSubcommands:\n- `list`: View current thresholds\n- `history [limit] [threshold]`: View recent changes\n- `set <Threshold> <Value>`: Modify a detection threshold (owner/admin only)\n- `reset <Threshold|all>`: Resets a threshold to its default value (owner/admin only)", Inline: false},
-		}, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}}
+	// Built from the registered definitions so help can't drift from the actual commands
+	embed := fitEmbed(&discordgo.MessageEmbed{Title: "Help", Description: "Available commands", Color: 0x5865F2,
+		Fields: helpFields(commandSpecs()), Footer: &discordgo.MessageEmbedFooter{Text: FooterText}})
 	_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
 }
 
+// helpFields renders one embed field per command, sorted by name: its description followed by
+// its subcommands or arguments, as registered
+func helpFields(specs []CommandSpec) []*discordgo.MessageEmbedField {
+	sorted := slices.Clone(specs)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].Command.Name < sorted[b].Command.Name })
+	fields := make([]*discordgo.MessageEmbedField, 0, len(sorted))
+	for _, spec := range sorted {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "/" + spec.Command.Name, Value: helpCommandText(spec), Inline: false})
+	}
+	return fields
+}
+
+// helpCommandText describes a command from its spec (Help, else the registered description), e.g.
+// "Shows or modifies detection thresholds\nSubcommands:\n- `set <threshold> <value>`: ..."
+func helpCommandText(spec CommandSpec) string {
+	c := spec.Command
+	var subs, args []string
+	for _, o := range c.Options {
+		if o.Type != discordgo.ApplicationCommandOptionSubCommand {
+			args = append(args, helpOptionLine(o))
+			continue
+		}
+		usage := o.Name
+		for _, so := range o.Options {
+			if so.Required {
+				usage += " <" + so.Name + ">"
+			} else {
+				usage += " [" + so.Name + "]"
+			}
+		}
+		subs = append(subs, fmt.Sprintf("- `%s`: %s", usage, o.Description))
+	}
+	var b strings.Builder
+	b.WriteString(cmp.Or(spec.Help, c.Description))
+	if len(subs) > 0 {
+		b.WriteString("\nSubcommands:\n" + strings.Join(subs, "\n"))
+	}
+	if len(args) > 0 {
+		b.WriteString("\nArguments:\n" + strings.Join(args, "\n"))
+	}
+	return b.String()
+}
+
+// helpOptionLine describes a single argument, e.g. "- `image_url` (required): Image URL to analyse"
+func helpOptionLine(o *discordgo.ApplicationCommandOption) string {
+	need := "optional"
+	if o.Required {
+		need = "required"
+	}
+	return fmt.Sprintf("- `%s` (%s): %s", o.Name, need, o.Description)
+}
+
 // -------------------------
 // /thresholds
 // -------------------------
@@ -292,18 +874,37 @@ func handleThresholds(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	// If no subcommand or list => view only (allowed roles can view)
 	if len(data.Options) == 0 || data.Options[0].Name == "list" {
 		if !(HasAdminContextPermission(i) || perms.IsAllowedForRestricted(i)) {
-			_ = respondEphemeral(s, i, "You don't have permission to view thresholds.")
+			_ = respondNoPermission(s, i, "You don't have permission to view thresholds.")
 			return
 		}
 		if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredChannelMessageWithSource}); err != nil {
 			log.Println("failed to defer thresholds:", err)
 			return
 		}
-		ns, ne, off, ai := thresholdsStore.GetGuildThresholds(perms, guildID)
-		val := fmt.Sprintf("Nudity (Explicit): %.0f%%\nNudity (Suggestive): %.0f%%\nOffensive: %.0f%%\nAI Generated: %.0f%%",
-			ne*100, ns*100, off*100, ai*100)
-		embed := &discordgo.MessageEmbed{Title: "Detection Thresholds", Description: "Current thresholds to flag image", Color: 0x9C27B0,
-			Fields: []*discordgo.MessageEmbedField{{Name: "Thresholds", Value: val, Inline: false}}, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}}
+		ns, ne, off, ai, df := thresholdsStore.GetGuildThresholds(perms, guildID)
+		val := fmt.Sprintf("`%s` %s Nudity (Explicit)\n`%s` %s Nudity (Suggestive)\n`%s` %s Offensive\n`%s` %s AI Generated\n`%s` %s Deepfake",
+			renderBar(ne, 10), formatThresholdPercent(ne), renderBar(ns, 10), formatThresholdPercent(ns), renderBar(off, 10), formatThresholdPercent(off),
+			renderBar(ai, 10), formatThresholdPercent(ai), renderBar(df, 10), formatThresholdPercent(df))
+		fields := []*discordgo.MessageEmbedField{{Name: "Thresholds", Value: val, Inline: false}}
+		verbose := false
+		if len(data.Options) > 0 {
+			for _, opt := range data.Options[0].Options {
+				if opt.Name == "verbose" {
+					verbose = opt.BoolValue()
+				}
+			}
+		}
+		if verbose && CanManageGuild(i) {
+			sourced := thresholdsStore.GetGuildThresholdsWithSource(perms, guildID)
+			var b strings.Builder
+			for _, name := range thresholdNames {
+				st := sourced[name]
+				_, _ = fmt.Fprintf(&b, "%s: %s (%s)\n", name, formatThresholdPercent(st.Value), st.Source)
+			}
+			fields = append(fields, &discordgo.MessageEmbedField{Name: "Sources", Value: strings.TrimRight(b.String(), "\n"), Inline: false})
+		}
+		embed := fitEmbed(&discordgo.MessageEmbed{Title: "Detection Thresholds", Description: "Current thresholds to flag image", Color: 0x9C27B0,
+			Fields: fields, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}})
 		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
 		return
 	}
@@ -311,32 +912,47 @@ func handleThresholds(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	// History: view-only (allowed roles or admins)
 	if data.Options[0].Name == "history" {
 		if !(HasAdminContextPermission(i) || perms.IsAllowedForRestricted(i)) {
-			_ = respondEphemeral(s, i, "You don't have permission to view threshold history.")
+			_ = respondNoPermission(s, i, "You don't have permission to view threshold history.")
 			return
 		}
-		limit := 10
+		// One page per reply; a page can't hold more changes than an embed holds fields
+		limit, page := 10, 1
 		var nameFilter string
 		for _, opt := range data.Options[0].Options {
 			if opt.Name == "limit" {
 				limit = int(opt.IntValue())
 			}
+			if opt.Name == "page" {
+				page = max(int(opt.IntValue()), 1)
+			}
 			if opt.Name == "threshold" {
 				nameFilter = strings.TrimSpace(opt.StringValue())
 			}
 		}
+		// Threshold history is only stored in the DB, so in file-storage mode it's always empty
+		if perms == nil || perms.db == nil {
+			_ = respondEphemeral(s, i, "Threshold history requires a database backend; this bot is currently using file storage, so changes aren't recorded.")
+			return
+		}
+		if limit <= 0 {
+			limit = 10
+		}
+		limit = min(limit, embedFieldsLimit)
+		offset := (page - 1) * limit
 		var (
 			changes []ThresholdChange
 			err     error
 		)
+		// Fetch one extra change to know whether a next page exists
 		if nameFilter != "" {
 			canonical, ok := canonicalThresholdName(nameFilter)
 			if !ok {
-				_ = respondEphemeral(s, i, "Unknown threshold filter. Use NuditySuggestive, NudityExplicit, Offensive, or AIGenerated")
+				_ = respondEphemeral(s, i, "Unknown threshold filter. Use NuditySuggestive, NudityExplicit, Offensive, AIGenerated, or Deepfake")
 				return
 			}
-			changes, err = thresholdsStore.HistoryFilteredForGuild(perms, guildID, canonical, limit)
+			changes, err = thresholdsStore.HistoryFilteredForGuildPaged(perms, guildID, canonical, limit+1, offset)
 		} else {
-			changes, err = thresholdsStore.HistoryForGuild(perms, guildID, limit)
+			changes, err = thresholdsStore.HistoryForGuildPaged(perms, guildID, limit+1, offset)
 		}
 		if err != nil {
 			log.Println("thresholds history error:", err)
@@ -344,9 +960,17 @@ func handleThresholds(s *discordgo.Session, i *discordgo.InteractionCreate) {
 			return
 		}
 		if len(changes) == 0 {
-			_ = respondEphemeral(s, i, "No history available.")
+			if page > 1 {
+				_ = respondEphemeral(s, i, fmt.Sprintf("No history on page %d.", page))
+				return
+			}
+			_ = respondEphemeral(s, i, "No threshold changes have been recorded for this server yet.")
 			return
 		}
+		more := len(changes) > limit
+		if more {
+			changes = changes[:limit]
+		}
 		if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredChannelMessageWithSource}); err != nil {
 			log.Println("failed to defer thresholds history:", err)
 			return
@@ -359,31 +983,72 @@ func handleThresholds(s *discordgo.Session, i *discordgo.InteractionCreate) {
 			}
 			old := "n/a"
 			if c.OldValue.Valid {
-				old = fmt.Sprintf("%.2f%%", c.OldValue.Float64*100)
+				old = formatThresholdPercent(c.OldValue.Float64)
 			}
-			val := fmt.Sprintf("%s\nOld: %s → New: %.2f%%\nBy: %s\nAt: %s",
-				c.Name, old, c.NewValue*100, user, c.Created.Format(time.RFC3339))
+			val := fmt.Sprintf("%s\nOld: %s → New: %s\nBy: %s\nAt: %s",
+				c.Name, old, formatThresholdPercent(c.NewValue), user, formatHistoryTime(c.Created))
 			fields = append(fields, &discordgo.MessageEmbedField{Name: "Change", Value: val, Inline: false})
 		}
-		embed := &discordgo.MessageEmbed{Title: "Thresholds History", Color: 0x8E44AD, Fields: fields, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}}
+		footer := fmt.Sprintf("Page %d", page)
+		if more {
+			footer += fmt.Sprintf(" • more with page:%d", page+1)
+		}
+		embed := fitEmbed(&discordgo.MessageEmbed{Title: "Thresholds History", Color: 0x8E44AD, Fields: fields,
+			Footer: &discordgo.MessageEmbedFooter{Text: footer + " • " + FooterText}})
 		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
 		return
 	}
 
-	// set/reset require owner/admin privileges
-	if !(IsOwner(i.Member.User.ID) || HasAdminContextPermission(i)) {
-		_ = respondEphemeral(s, i, "Only server admins or the owner can modify thresholds.")
+	// Preview: dry-run proposed thresholds against a fresh analysis (allowed roles or admins)
+	if data.Options[0].Name == "preview" {
+		if !(HasAdminContextPermission(i) || perms.IsAllowedForRestricted(i)) {
+			_ = respondNoPermission(s, i, "You don't have permission to preview thresholds.")
+			return
+		}
+		if usageStore.OverQuota(guildID) {
+			_ = respondEphemeral(s, i, overQuotaMessage)
+			return
+		}
+		thresholdsPreview(s, i, data.Options[0])
 		return
 	}
 
-	sub := data.Options[0]
-	switch sub.Name {
-	case "set":
-		var name, valueStr string
-
-		// Parse value: allow percent like 70% or 0.70
-		for _, opt := range sub.Options {
-			if opt.Name == "threshold" {
+	// Simulate: replay stored analyses under a proposed threshold (allowed roles or admins)
+	if data.Options[0].Name == "simulate" {
+		if !(HasAdminContextPermission(i) || perms.IsAllowedForRestricted(i)) {
+			_ = respondNoPermission(s, i, "You don't have permission to simulate thresholds.")
+			return
+		}
+		thresholdsSimulate(s, i, data.Options[0])
+		return
+	}
+
+	// set/reset require owner/admin privileges (in DMs only the owner qualifies)
+	userID := interactionUserID(i)
+	if userID == "" {
+		_ = respondEphemeral(s, i, unknownUserMessage)
+		return
+	}
+	if !CanManageGuild(i) {
+		_ = respondEphemeral(s, i, "Only server admins or the owner can modify thresholds.")
+		return
+	}
+
+	// Per-server (and DM) thresholds are only stored in the DB; don't report changes that
+	// would be lost
+	if !thresholdsStore.GuildStorageAvailable(perms) {
+		_ = respondEphemeral(s, i, thresholdsNeedDBMessage)
+		return
+	}
+
+	sub := data.Options[0]
+	switch sub.Name {
+	case "set":
+		var name, valueStr string
+
+		// Parse value: allow percent like 70% or 0.70
+		for _, opt := range sub.Options {
+			if opt.Name == "threshold" {
 				name = strings.TrimSpace(opt.StringValue())
 			}
 			if opt.Name == "value" {
@@ -397,23 +1062,82 @@ func handleThresholds(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		}
 		val, err := parseThresholdValue(valueStr)
 		if err != nil || val < 0 || val > 1 {
-			_ = respondEphemeral(s, i, "Value must be a decimal between 0.00 and 1.00, or a percentage like 70%")
+			_ = respondEphemeral(s, i, "Value must be a decimal between 0.00 and 1.00, or a percentage like 70% or 70")
 			return
 		}
 		canonical, ok := canonicalThresholdName(name)
 		if !ok {
-			_ = respondEphemeral(s, i, "Unknown threshold. Use NuditySuggestive, NudityExplicit, Offensive, or AIGenerated")
+			_ = respondEphemeral(s, i, "Unknown threshold. Use NuditySuggestive, NudityExplicit, Offensive, AIGenerated, or Deepfake")
+			return
+		}
+		oldNS, oldNE, oldOff, oldAI, oldDF := thresholdsStore.GetGuildThresholds(perms, guildID)
+		oldMap := map[string]float64{"NuditySuggestive": oldNS, "NudityExplicit": oldNE, "Offensive": oldOff, "AIGenerated": oldAI, "Deepfake": oldDF}
+		if err := thresholdsStore.EnsureGuildSnapshot(perms, guildID); err != nil {
+			log.Println("thresholds snapshot error:", err)
+			_ = respondEphemeral(s, i, "Failed to update threshold")
 			return
 		}
-		oldNS, oldNE, oldOff, oldAI := thresholdsStore.GetGuildThresholds(perms, guildID)
-		oldMap := map[string]float64{"NuditySuggestive": oldNS, "NudityExplicit": oldNE, "Offensive": oldOff, "AIGenerated": oldAI}
 		if err := thresholdsStore.SetGuild(perms, guildID, canonical, val); err != nil {
 			log.Println("thresholds set guild error:", err)
 			_ = respondEphemeral(s, i, "Failed to update threshold")
 			return
 		}
-		_ = thresholdsStore.LogChange(perms, canonical, oldMap[canonical], val, i.Member.User.ID, guildID)
-		msg := fmt.Sprintf("Set %s to %.2f%%", canonical, val*100)
+		_ = thresholdsStore.LogChange(perms, canonical, oldMap[canonical], val, userID, guildID)
+		postThresholdAudit(s, guildID, userID, []thresholdAuditChange{{Name: canonical, Old: oldMap[canonical], New: val}})
+		msg := fmt.Sprintf("Set %s to %s", canonical, formatThresholdPercent(val))
+		_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{Content: msg}})
+
+	case "setall":
+		// Map option names to canonical thresholds, preserving a stable order
+		optToName := []struct{ opt, name string }{
+			{"explicit", "NudityExplicit"},
+			{"suggestive", "NuditySuggestive"},
+			{"offensive", "Offensive"},
+			{"ai", "AIGenerated"},
+			{"deepfake", "Deepfake"},
+		}
+		raw := make(map[string]string, len(sub.Options))
+		for _, opt := range sub.Options {
+			raw[opt.Name] = strings.TrimSpace(opt.StringValue())
+		}
+		// deepfake is optional so existing four-value invocations keep working
+		if _, ok := raw["deepfake"]; !ok {
+			optToName = optToName[:len(optToName)-1]
+		}
+		// Validate everything before applying anything
+		values := make(map[string]float64, len(optToName))
+		for _, o := range optToName {
+			val, err := parseThresholdValue(raw[o.opt])
+			if err != nil || val < 0 || val > 1 {
+				_ = respondEphemeral(s, i, fmt.Sprintf("Invalid value for `%s`. Values must be decimals between 0.00 and 1.00, or percentages like 70%% or 70. No thresholds were changed.", o.opt))
+				return
+			}
+			values[o.name] = val
+		}
+		oldNS, oldNE, oldOff, oldAI, oldDF := thresholdsStore.GetGuildThresholds(perms, guildID)
+		oldMap := map[string]float64{"NuditySuggestive": oldNS, "NudityExplicit": oldNE, "Offensive": oldOff, "AIGenerated": oldAI, "Deepfake": oldDF}
+		if err := thresholdsStore.EnsureGuildSnapshot(perms, guildID); err != nil {
+			log.Println("thresholds snapshot error:", err)
+			_ = respondEphemeral(s, i, "Failed to update thresholds")
+			return
+		}
+		audit := make([]thresholdAuditChange, 0, len(optToName))
+		for _, o := range optToName {
+			if err := thresholdsStore.SetGuild(perms, guildID, o.name, values[o.name]); err != nil {
+				log.Println("thresholds setall guild error:", err)
+				_ = respondEphemeral(s, i, "Failed to update thresholds")
+				return
+			}
+			_ = thresholdsStore.LogChange(perms, o.name, oldMap[o.name], values[o.name], userID, guildID)
+			audit = append(audit, thresholdAuditChange{Name: o.name, Old: oldMap[o.name], New: values[o.name]})
+		}
+		postThresholdAudit(s, guildID, userID, audit)
+		parts := make([]string, 0, len(optToName))
+		for _, o := range optToName {
+			parts = append(parts, o.name+" "+formatThresholdPercent(values[o.name]))
+		}
+		msg := "Set thresholds: " + strings.Join(parts, ", ")
 		_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseChannelMessageWithSource,
 			Data: &discordgo.InteractionResponseData{Content: msg}})
 
@@ -429,160 +1153,1579 @@ func handleThresholds(s *discordgo.Session, i *discordgo.InteractionCreate) {
 			return
 		}
 		if strings.EqualFold(name, "all") {
-			oldNS, oldNE, oldOff, oldAI := thresholdsStore.GetGuildThresholds(perms, guildID)
-			if err := thresholdsStore.ResetAllGuild(perms, guildID); err != nil {
-				log.Println("thresholds reset all guild error:", err)
-				_ = respondEphemeral(s, i, "Failed to reset thresholds")
-				return
+			embed := &discordgo.MessageEmbed{Title: "Confirm Threshold Reset", Color: 0xE67E22,
+				Description: "This will reset all thresholds for this server to their defaults.",
+				Footer:      &discordgo.MessageEmbedFooter{Text: FooterText}}
+			err := confirmAction(s, i, embed, false, func() string {
+				old := thresholdsStore.GetGuildThresholdsWithSource(perms, guildID)
+				if err := thresholdsStore.ResetAllGuild(perms, guildID); err != nil {
+					log.Println("thresholds reset all guild error:", err)
+					return "Failed to reset thresholds"
+				}
+				audit := make([]thresholdAuditChange, 0, len(thresholdNames))
+				for _, name := range thresholdNames {
+					_ = thresholdsStore.LogChange(perms, name, old[name].Value, defaultThresholdValue(name), userID, guildID)
+					audit = append(audit, thresholdAuditChange{Name: name, Old: old[name].Value, New: defaultThresholdValue(name)})
+				}
+				postThresholdAudit(s, guildID, userID, audit)
+				return "Reset all thresholds to default"
+			})
+			if err != nil {
+				log.Println("thresholds reset all confirm prompt error:", err)
 			}
-			_ = thresholdsStore.LogChange(perms, "NuditySuggestive", oldNS, DefaultNuditySuggestiveThreshold, i.Member.User.ID, guildID)
-			_ = thresholdsStore.LogChange(perms, "NudityExplicit", oldNE, DefaultNudityExplicitThreshold, i.Member.User.ID, guildID)
-			_ = thresholdsStore.LogChange(perms, "Offensive", oldOff, DefaultOffensiveThreshold, i.Member.User.ID, guildID)
-			_ = thresholdsStore.LogChange(perms, "AIGenerated", oldAI, DefaultAIGeneratedThreshold, i.Member.User.ID, guildID)
-			msg := "Reset all thresholds to default"
-			_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{Content: msg}})
 			return
 		}
 		canonical, ok := canonicalThresholdName(name)
 		if !ok {
-			_ = respondEphemeral(s, i, "Unknown threshold. Use NuditySuggestive, NudityExplicit, Offensive, or AIGenerated")
+			_ = respondEphemeral(s, i, "Unknown threshold. Use NuditySuggestive, NudityExplicit, Offensive, AIGenerated, or Deepfake")
 			return
 		}
-		oldNS, oldNE, oldOff, oldAI := thresholdsStore.GetGuildThresholds(perms, guildID)
-		oldMap := map[string]float64{"NuditySuggestive": oldNS, "NudityExplicit": oldNE, "Offensive": oldOff, "AIGenerated": oldAI}
+		oldNS, oldNE, oldOff, oldAI, oldDF := thresholdsStore.GetGuildThresholds(perms, guildID)
+		oldMap := map[string]float64{"NuditySuggestive": oldNS, "NudityExplicit": oldNE, "Offensive": oldOff, "AIGenerated": oldAI, "Deepfake": oldDF}
 		if err := thresholdsStore.ResetOneGuild(perms, guildID, canonical); err != nil {
 			log.Println("thresholds reset one guild error:", err)
 			_ = respondEphemeral(s, i, "Failed to reset threshold")
 			return
 		}
 		// after reset, new value equals built-in default
-		_ = thresholdsStore.LogChange(perms, canonical, oldMap[canonical], defaultThresholdValue(canonical), i.Member.User.ID, guildID)
+		_ = thresholdsStore.LogChange(perms, canonical, oldMap[canonical], defaultThresholdValue(canonical), userID, guildID)
+		postThresholdAudit(s, guildID, userID, []thresholdAuditChange{{Name: canonical, Old: oldMap[canonical], New: defaultThresholdValue(canonical)}})
 		msg := fmt.Sprintf("Reset %s to default", canonical)
 		_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseChannelMessageWithSource,
 			Data: &discordgo.InteractionResponseData{Content: msg}})
 	}
 }
 
-// -------------------------
-// Command bodies (helpers)
-// -------------------------
-func analyseCommandHandlerBody(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	// Extract options
-	var (
-		imageURL string
-		advanced bool
-	)
-	for _, opt := range i.ApplicationCommandData().Options {
+// thresholdsPreview runs AnalyseResult over a fresh analysis with ad-hoc thresholds and
+// compares the verdict with the guild's stored thresholds; nothing is persisted
+func thresholdsPreview(s *discordgo.Session, i *discordgo.InteractionCreate, sub *discordgo.ApplicationCommandInteractionDataOption) {
+	curNS, curNE, curOff, curAI, curDF := thresholdsStore.GetGuildThresholds(perms, i.GuildID)
+	ns, ne, off, ai, df := curNS, curNE, curOff, curAI, curDF
+	var imageURL string
+	for _, opt := range sub.Options {
+		var target *float64
 		switch opt.Name {
 		case "image_url":
 			imageURL = opt.StringValue()
-		case "advanced":
-			advanced = opt.BoolValue()
+			continue
+		case "explicit":
+			target = &ne
+		case "suggestive":
+			target = &ns
+		case "offensive":
+			target = &off
+		case "ai":
+			target = &ai
+		case "deepfake":
+			target = &df
+		default:
+			continue
+		}
+		v, err := parseThresholdInput(opt.StringValue())
+		if err != nil {
+			_ = respondEphemeral(s, i, fmt.Sprintf("Invalid value for `%s`: %v", opt.Name, err))
+			return
 		}
+		*target = v
 	}
-	if imageURL == "" {
-		_ = respondEphemeral(s, i, "Missing `image_url`.")
+	imageURL, err := normalizeImageURL(imageURL)
+	if err != nil {
+		_ = respondEphemeral(s, i, "Invalid `image_url`: "+err.Error())
+		return
+	}
+	if mediaKind(imageURL) == mediaVideo {
+		_ = respondEphemeral(s, i, videoUnsupportedMessage)
 		return
 	}
 	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredChannelMessageWithSource}); err != nil {
-		log.Println("failed to defer interaction:", err)
+		log.Println("failed to defer thresholds preview:", err)
 		return
 	}
-	if advanced {
-		aa, err := AnalyseImageURLAdvanced(imageURL)
+	ctx, cancel := interactionContext(i)
+	defer cancel()
+	var out map[string]any
+	imageURL, err = withCDNRefresh(s, imageURL, func(u string) (err error) {
+		out, err = sightengine(ctx, i.GuildID, u)
+		return err
+	})
+	if err != nil {
+		respondAnalysisError(s, i, "Preview", err)
+		return
+	}
+	opts, err := analysisOptionsForGuild(i.GuildID)
+	if err != nil {
+		respondAnalysisError(s, i, "Preview", err)
+		return
+	}
+	proposed := AnalyseResult(out, ns, ne, off, ai, df, opts)
+	current := AnalyseResult(out, curNS, curNE, curOff, curAI, curDF, opts)
+
+	verdict := func(a *Analysis) string {
+		if a.Allowed {
+			return "Safe"
+		}
+		return "Flagged: " + strings.Join(a.Reasons, ", ")
+	}
+	row := func(label string, score, cur, prop float64) string {
+		return fmt.Sprintf("%s: %.0f%% (current %.0f%% → proposed %.0f%%)", label, score*100, cur*100, prop*100)
+	}
+	scores := strings.Join([]string{
+		row("Nudity (Explicit)", proposed.Scores.NudityExplicit, curNE, ne),
+		row("Nudity (Suggestive)", proposed.Scores.NuditySuggestive, curNS, ns),
+		row("Offensive", proposed.Scores.Offensive, curOff, off),
+		row("AI Generated", proposed.Scores.AIGenerated, curAI, ai),
+		row("Deepfake", proposed.Scores.Deepfake, curDF, df),
+	}, "\n")
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "Proposed Verdict", Value: verdict(proposed), Inline: true},
+		{Name: "Current Verdict", Value: verdict(current), Inline: true},
+		{Name: "Scores vs Thresholds", Value: scores, Inline: false},
+	}
+	embed := fitEmbed(&discordgo.MessageEmbed{Title: "Threshold Preview", Description: fmt.Sprintf("Dry run for: %s\nNo thresholds were changed.", imageURL), Color: 0x9C27B0,
+		Fields: fields, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}})
+	_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
+}
+
+// thresholdsSimulate re-applies the guild's thresholds, with one of them replaced, to the
+// scores kept in analysis history and reports which images would change verdict.
+// Stored scores are reused, so no Sightengine calls are made
+func thresholdsSimulate(s *discordgo.Session, i *discordgo.InteractionCreate, sub *discordgo.ApplicationCommandInteractionDataOption) {
+	if i.GuildID == "" {
+		_ = respondEphemeral(s, i, "Simulations use this server's analysis history, so they only work inside a server.")
+		return
+	}
+	var name, valueStr string
+	for _, opt := range sub.Options {
+		switch opt.Name {
+		case "threshold":
+			name = strings.TrimSpace(opt.StringValue())
+		case "value":
+			valueStr = opt.StringValue()
+		}
+	}
+	canonical, ok := canonicalThresholdName(name)
+	if !ok {
+		_ = respondEphemeral(s, i, "Unknown threshold. Use NuditySuggestive, NudityExplicit, Offensive, AIGenerated, or Deepfake")
+		return
+	}
+	value, err := parseThresholdInput(valueStr)
+	if err != nil {
+		_ = respondEphemeral(s, i, "Value "+err.Error())
+		return
+	}
+	gs, err := settingsStore.Get(i.GuildID)
+	if err != nil {
+		log.Println("guild settings read error:", err)
+		_ = respondEphemeral(s, i, settingsUnavailableMessage)
+		return
+	}
+	recs, err := analysisHistory.Recent(i.GuildID, analysisHistoryMemLimit)
+	if err != nil {
+		log.Println("analysis history read error:", err)
+		_ = respondEphemeral(s, i, "Failed to read analysis history.")
+		return
+	}
+	if len(recs) == 0 {
+		msg := "No stored analyses to simulate against."
+		if !gs.AnalysisHistory {
+			msg += fmt.Sprintf(" Enable them with `/settings set analysis_history on`; analyses run after that are kept for %d days.", historyRetentionDays())
+		}
+		_ = respondEphemeral(s, i, msg)
+		return
+	}
+
+	curNS, curNE, curOff, curAI, curDF := thresholdsStore.GetGuildThresholds(perms, i.GuildID)
+	ns, ne, off, ai, df := curNS, curNE, curOff, curAI, curDF
+	switch canonical {
+	case "NuditySuggestive":
+		ns = value
+	case "NudityExplicit":
+		ne = value
+	case "Offensive":
+		off = value
+	case "AIGenerated":
+		ai = value
+	case "Deepfake":
+		df = value
+	}
+	const maxExamples = 3
+	var toFlagged, toAllowed []string
+	for _, r := range recs {
+		before := r.AllowedUnder(curNS, curNE, curOff, curAI, curDF, gs.MinReasons)
+		after := r.AllowedUnder(ns, ne, off, ai, df, gs.MinReasons)
+		switch {
+		case before && !after:
+			toFlagged = append(toFlagged, r.ImageURL)
+		case !before && after:
+			toAllowed = append(toAllowed, r.ImageURL)
+		}
+	}
+	examples := func(urls []string) string {
+		if len(urls) == 0 {
+			return "None"
+		}
+		lines := make([]string, 0, maxExamples+1)
+		for _, u := range urls[:min(len(urls), maxExamples)] {
+			lines = append(lines, "- "+u)
+		}
+		if len(urls) > maxExamples {
+			lines = append(lines, fmt.Sprintf("…and %d more", len(urls)-maxExamples))
+		}
+		return strings.Join(lines, "\n")
+	}
+	unchanged := len(recs) - len(toFlagged) - len(toAllowed)
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "Allowed → Flagged", Value: fmt.Sprintf("%d", len(toFlagged)), Inline: true},
+		{Name: "Flagged → Allowed", Value: fmt.Sprintf("%d", len(toAllowed)), Inline: true},
+		{Name: "Unchanged", Value: fmt.Sprintf("%d", unchanged), Inline: true},
+		{Name: "Newly flagged examples", Value: examples(toFlagged), Inline: false},
+		{Name: "Newly allowed examples", Value: examples(toAllowed), Inline: false},
+	}
+	desc := fmt.Sprintf("%s: %s → %s over the last %d stored analyses (since %s).\nNo thresholds were changed.",
+		canonical, formatThresholdPercent(thresholdValueByName(canonical, curNS, curNE, curOff, curAI, curDF)), formatThresholdPercent(value),
+		len(recs), recs[len(recs)-1].Created.Format("2006-01-02"))
+	embed := fitEmbed(&discordgo.MessageEmbed{Title: "Threshold Simulation", Description: desc, Color: 0x9C27B0,
+		Fields: fields, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}})
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Embeds: []*discordgo.MessageEmbed{embed}},
+	}); err != nil {
+		log.Println("failed to respond to thresholds simulate:", err)
+	}
+}
+
+// thresholdValueByName picks one of the five threshold values by canonical name
+func thresholdValueByName(name string, ns, ne, off, ai, df float64) float64 {
+	switch name {
+	case "NuditySuggestive":
+		return ns
+	case "NudityExplicit":
+		return ne
+	case "Offensive":
+		return off
+	case "AIGenerated":
+		return ai
+	case "Deepfake":
+		return df
+	}
+	return 0
+}
+
+// -------------------------
+// /settings
+// -------------------------
+func handleSettings(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand || i.ApplicationCommandData().Name != "settings" {
+		return
+	}
+	if i.GuildID == "" {
+		_ = respondEphemeral(s, i, "Settings can only be managed inside a server.")
+		return
+	}
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		_ = respondEphemeral(s, i, "Missing subcommand. Use view or set.")
+		return
+	}
+	sub := data.Options[0]
+	switch sub.Name {
+	case "view":
+		if !(HasAdminContextPermission(i) || perms.IsAllowedForRestricted(i)) {
+			_ = respondNoPermission(s, i, "You don't have permission to view settings.")
+			return
+		}
+		view, err := settingsStore.View(i.GuildID)
 		if err != nil {
-			msg := fmt.Sprintf("Analysis failed: %v", err)
-			_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &msg})
+			log.Println("guild settings read error:", err)
+			_ = respondEphemeral(s, i, settingsUnavailableMessage)
 			return
 		}
-		formatScores := func(title string, m map[string]float64) *discordgo.MessageEmbedField {
-			if len(m) == 0 {
-				return &discordgo.MessageEmbedField{Name: title, Value: "none", Inline: false}
-			}
-			keys := make([]string, 0, len(m))
-			for k := range m {
-				keys = append(keys, k)
-			}
-			sort.Slice(keys, func(i, j int) bool { return m[keys[i]] > m[keys[j]] })
-			var b strings.Builder
-			for _, k := range keys {
-				_, _ = fmt.Fprintf(&b, "%s: %.0f%%\n", k, m[k]*100)
+		fields := make([]*discordgo.MessageEmbedField, 0, len(settingSpecs))
+		for _, v := range view {
+			fields = append(fields, &discordgo.MessageEmbedField{Name: v.Key, Value: v.Value + "\n*" + v.Description + "*", Inline: false})
+		}
+		embed := fitEmbed(&discordgo.MessageEmbed{Title: "Server Settings", Color: 0x607D8B, Fields: fields,
+			Footer: &discordgo.MessageEmbedFooter{Text: FooterText}})
+		_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{Embeds: []*discordgo.MessageEmbed{embed}}})
+
+	case "set":
+		if !CanManageGuild(i) {
+			_ = respondEphemeral(s, i, "Only server admins or the owner can change settings.")
+			return
+		}
+		var key, value string
+		for _, opt := range sub.Options {
+			switch opt.Name {
+			case "key":
+				key = strings.TrimSpace(opt.StringValue())
+			case "value":
+				value = strings.TrimSpace(opt.StringValue())
 			}
-			val := strings.TrimRight(b.String(), "\n")
-			return &discordgo.MessageEmbedField{Name: title, Value: val, Inline: false}
 		}
-		fields := make([]*discordgo.MessageEmbedField, 0, 6)
-		if nudity, ok := aa.Categories["nudity"]; ok {
-			fields = append(fields, formatScores("Nudity", nudity))
+		if sp, ok := findSettingSpec(key); ok && sp.Key == "owners" && !CanDelegateOwners(i) {
+			_ = respondEphemeral(s, i, "Only server admins or the bot owner can change `owners`.")
+			return
 		}
-		if offensive, ok := aa.Categories["offensive"]; ok {
-			fields = append(fields, formatScores("Offensive Content", offensive))
+		if err := settingsStore.Set(i.GuildID, key, value); err != nil {
+			_ = respondEphemeral(s, i, "Failed to update setting: "+err.Error())
+			return
 		}
-		if typ, ok := aa.Categories["type"]; ok {
-			fields = append(fields, formatScores("AI Usage", typ))
+		shown, ok, err := settingsStore.GetRaw(i.GuildID, key)
+		switch {
+		case err != nil:
+			log.Println("guild settings read error:", err)
+			shown = "the new value"
+		case !ok:
+			shown = "(default)"
+		default:
+			if sp, found := findSettingSpec(key); found && sp.Format != nil {
+				shown = sp.Format(shown)
+			}
 		}
-		embed := &discordgo.MessageEmbed{Title: "Image Analysis (Advanced)", Description: fmt.Sprintf("Analysis results for: %s", imageURL), Color: 0x4CAF50,
-			Fields: fields, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}}
-		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
+		_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{Content: fmt.Sprintf("Set `%s` to %s", key, shown),
+				AllowedMentions: &discordgo.MessageAllowedMentions{}}})
+
+	default:
+		_ = respondEphemeral(s, i, "Unknown subcommand.")
+	}
+}
+
+// -------------------------
+// /usage
+// -------------------------
+
+// overQuotaMessage is shown when a guild has used its MONTHLY_QUOTA of analysis calls
+const overQuotaMessage = "This server has used its monthly analysis quota. Analysis will be available again next month."
+
+func handleUsage(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand || i.ApplicationCommandData().Name != "usage" {
 		return
 	}
-	// Standard
-	a, err := AnalyseImageURL(i.GuildID, imageURL)
+	if !(HasAdminContextPermission(i) || perms.IsAllowedForRestricted(i)) {
+		_ = respondNoPermission(s, i, "You don't have permission to view usage.")
+		return
+	}
+	n, err := usageStore.CurrentMonth(thresholdsGuildKey(i.GuildID))
 	if err != nil {
-		msg := fmt.Sprintf("Analysis failed: %v", err)
-		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &msg})
+		log.Println("usage lookup error:", err)
+		_ = respondEphemeral(s, i, "Failed to fetch usage")
 		return
 	}
-	fields := []*discordgo.MessageEmbedField{
-		{Name: "Safe Image", Value: fmt.Sprintf("%t", a.Allowed), Inline: true},
-		{Name: "Results", Value: fmt.Sprintf("Nudity (Explicit): %.0f%%\nNudity (Suggestive): %.0f%%\nOffensive: %.0f%%\nAI Generated: %.0f%%",
-			a.Scores.NudityExplicit*100, a.Scores.NuditySuggestive*100, a.Scores.Offensive*100, a.Scores.AIGenerated*100), Inline: false},
+	period := usagePeriod(time.Now())
+	val := fmt.Sprintf("%d analysis calls (no quota)", n)
+	if quota := monthlyQuota(); quota > 0 {
+		val = fmt.Sprintf("`%s` %d / %d analysis calls", renderBar(float64(n)/float64(quota), 10), n, quota)
 	}
-	embed := &discordgo.MessageEmbed{Title: "Image Analysis", Description: fmt.Sprintf("Analysis results for: %s", imageURL), Color: 0x00BFA5,
-		Fields: fields, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}}
-	_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
+	embed := &discordgo.MessageEmbed{Title: "Analysis Usage", Description: "Sightengine calls made for this server this month (UTC)", Color: 0x2196F3,
+		Fields: []*discordgo.MessageEmbedField{{Name: period, Value: val, Inline: false}},
+		Footer: &discordgo.MessageEmbedFooter{Text: FooterText}}
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Embeds: []*discordgo.MessageEmbed{embed}, Flags: discordgo.MessageFlagsEphemeral}})
 }
 
-func aiCommandHandlerBody(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	var imageURL string
-	for _, opt := range i.ApplicationCommandData().Options {
-		if opt.Name == "image_url" {
-			imageURL = opt.StringValue()
-		}
+// -------------------------
+// /models
+// -------------------------
+func handleModels(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand || i.ApplicationCommandData().Name != "models" {
+		return
 	}
-	if imageURL == "" {
-		_ = respondEphemeral(s, i, "Missing `image_url`.")
+	if i.GuildID == "" {
+		_ = respondEphemeral(s, i, "This command can only be used inside a server.")
 		return
 	}
-	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredChannelMessageWithSource}); err != nil {
-		log.Println("failed to defer ai interaction:", err)
+	if !CanManageGuild(i) {
+		_ = respondEphemeral(s, i, "Only server admins or the owner can change analysis models.")
 		return
 	}
-	analysis, err := AnalyseImageURLAIOnly(i.GuildID, imageURL)
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		_ = respondEphemeral(s, i, "Usage: /models <enable|disable|list>")
+		return
+	}
+	sub := data.Options[0]
+	if sub.Name != "list" {
+		var model string
+		for _, opt := range sub.Options {
+			if opt.Name == "model" {
+				model = strings.TrimSpace(opt.StringValue())
+			}
+		}
+		if err := settingsStore.SetModelEnabled(i.GuildID, model, sub.Name == "enable"); err != nil {
+			_ = respondEphemeral(s, i, "Failed to update models: "+err.Error())
+			return
+		}
+	}
+	enabled, err := settingsStore.EnabledModels(i.GuildID)
 	if err != nil {
-		msg := fmt.Sprintf("AI check failed: %v", err)
-		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &msg})
+		log.Println("guild settings read error:", err)
+		_ = respondEphemeral(s, i, settingsUnavailableMessage)
 		return
 	}
-	fields := []*discordgo.MessageEmbedField{
-		{Name: "Safe Image", Value: fmt.Sprintf("%t", analysis.Allowed), Inline: true},
-		{Name: "AI Generated", Value: fmt.Sprintf("%.0f%%", analysis.Scores.AIGenerated*100), Inline: true},
+	var b strings.Builder
+	for _, m := range sightengineModels {
+		mark := "off"
+		if slices.Contains(enabled, m.Name) {
+			mark = "on"
+		}
+		_, _ = fmt.Fprintf(&b, "%s (`%s`): %s\n", m.Label, m.Name, mark)
 	}
-	embed := &discordgo.MessageEmbed{Title: "AI Usage Check", Description: fmt.Sprintf("Analysis results for: %s", imageURL), Color: 0x3F51B5,
-		Fields: fields, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}}
-	_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
+	embed := &discordgo.MessageEmbed{Title: "Analysis Models", Description: strings.TrimRight(b.String(), "\n"), Color: 0x2196F3,
+		Footer: &discordgo.MessageEmbedFooter{Text: FooterText}}
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Embeds: []*discordgo.MessageEmbed{embed}, Flags: discordgo.MessageFlagsEphemeral}})
 }
 
-func parseThresholdValue(in string) (float64, error) {
-	s := strings.TrimSpace(in)
-	if strings.HasSuffix(s, "%") {
-		p := strings.TrimSuffix(s, "%")
-		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+// -------------------------
+// /commands
+// -------------------------
+
+// toggleableCommands lists the commands /commands can disable; /permissions, /help and
+// /commands stay available so a server can always recover
+var toggleableCommands = []string{"about", "ai", "analyse", "history", "models", "monitor", "ping", "reset", "reverse", "settings", "stats", "thresholds", "usage"}
+
+func handleCommands(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand || i.ApplicationCommandData().Name != "commands" {
+		return
+	}
+	if i.GuildID == "" {
+		_ = respondEphemeral(s, i, "This command can only be used inside a server.")
+		return
+	}
+	if !CanManageGuild(i) {
+		_ = respondEphemeral(s, i, "Only server admins or the owner can enable or disable commands.")
+		return
+	}
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		_ = respondEphemeral(s, i, "Usage: /commands <enable|disable|list>")
+		return
+	}
+	sub := data.Options[0]
+	if sub.Name == "list" {
+		disabled, err := settingsStore.DisabledCommands(i.GuildID)
 		if err != nil {
-			return 0, err
+			log.Println("guild settings read error:", err)
+			_ = respondEphemeral(s, i, settingsUnavailableMessage)
+			return
 		}
-		return f / 100.0, nil
+		val := "(none)"
+		if len(disabled) > 0 {
+			val = "/" + strings.Join(disabled, ", /")
+		}
+		embed := &discordgo.MessageEmbed{Title: "Disabled Commands", Description: val, Color: 0x2196F3,
+			Footer: &discordgo.MessageEmbedFooter{Text: FooterText}}
+		_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{Embeds: []*discordgo.MessageEmbed{embed}, Flags: discordgo.MessageFlagsEphemeral}})
+		return
+	}
+	var name string
+	for _, opt := range sub.Options {
+		if opt.Name == "name" {
+			name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(opt.StringValue()), "/"))
+		}
+	}
+	if !slices.Contains(toggleableCommands, name) {
+		_ = respondEphemeral(s, i, "That command can't be enabled or disabled.")
+		return
+	}
+	enabled := sub.Name == "enable"
+	if err := settingsStore.SetCommandEnabled(i.GuildID, name, enabled); err != nil {
+		log.Println("commands toggle error:", err)
+		_ = respondEphemeral(s, i, "Failed to update command state")
+		return
+	}
+	state := "disabled"
+	if enabled {
+		state = "enabled"
+	}
+	_ = respondEphemeral(s, i, fmt.Sprintf("/%s is now %s on this server.", name, state))
+}
+
+// -------------------------
+// /reset guild
+// -------------------------
+func handleReset(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand || i.ApplicationCommandData().Name != "reset" {
+		return
+	}
+	if i.GuildID == "" {
+		_ = respondEphemeral(s, i, "This command can only be used inside a server.")
+		return
+	}
+	if !CanManageGuild(i) {
+		_ = respondEphemeral(s, i, "Only server admins or the owner can reset this server.")
+		return
+	}
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 || data.Options[0].Name != "guild" {
+		_ = respondEphemeral(s, i, "Unknown subcommand.")
+		return
+	}
+	includeHistory := false
+	for _, opt := range data.Options[0].Options {
+		if opt.Name == "include_history" {
+			includeHistory = opt.BoolValue()
+		}
+	}
+	pending := "all moderator roles, per-server thresholds, settings and monitors"
+	if includeHistory {
+		pending += ", and the threshold and permission change history and stored analyses"
+	}
+	guildID := i.GuildID
+	embed := &discordgo.MessageEmbed{Title: "Confirm Server Reset", Color: 0xE74C3C,
+		Description: "This will permanently delete " + pending + " for this server.",
+		Footer:      &discordgo.MessageEmbedFooter{Text: FooterText}}
+	err := confirmAction(s, i, embed, true, func() string {
+		var failed []string
+		if err := perms.ClearGuild(guildID); err != nil {
+			log.Println("reset guild permissions error:", err)
+			failed = append(failed, "permissions")
+		}
+		if err := thresholdsStore.ClearGuild(perms, guildID); err != nil {
+			log.Println("reset guild thresholds error:", err)
+			failed = append(failed, "thresholds")
+		}
+		if err := settingsStore.ClearGuild(guildID); err != nil {
+			log.Println("reset guild settings error:", err)
+			failed = append(failed, "settings")
+		}
+		if err := monitorStore.ClearGuild(guildID); err != nil {
+			log.Println("reset guild monitors error:", err)
+			failed = append(failed, "monitors")
+		}
+		if includeHistory {
+			if err := thresholdsStore.ClearGuildHistory(perms, guildID); err != nil {
+				log.Println("reset guild history error:", err)
+				failed = append(failed, "history")
+			}
+			if err := perms.ClearGuildHistory(guildID); err != nil {
+				log.Println("reset guild permissions history error:", err)
+				failed = append(failed, "permissions history")
+			}
+			if err := analysisHistory.ClearGuild(guildID); err != nil {
+				log.Println("reset guild analysis history error:", err)
+				failed = append(failed, "analysis history")
+			}
+		}
+		if len(failed) > 0 {
+			return "Reset partially failed for: " + strings.Join(failed, ", ") + ". Check the logs and try again."
+		}
+		return "This server's bot configuration has been reset."
+	})
+	if err != nil {
+		log.Println("reset guild confirm prompt error:", err)
+	}
+}
+
+// -------------------------
+// /history purge
+// -------------------------
+func handleHistory(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand || i.ApplicationCommandData().Name != "history" {
+		return
+	}
+	if i.GuildID == "" {
+		_ = respondEphemeral(s, i, "This command can only be used inside a server.")
+		return
+	}
+	if !CanManageGuild(i) {
+		_ = respondEphemeral(s, i, "Only server admins or the owner can purge this server's history.")
+		return
+	}
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 || data.Options[0].Name != "purge" {
+		_ = respondEphemeral(s, i, "Unknown subcommand.")
+		return
+	}
+	guildID := i.GuildID
+	embed := &discordgo.MessageEmbed{Title: "Confirm History Purge", Color: 0xE74C3C,
+		Description: fmt.Sprintf("This will permanently delete the threshold change history and stored analyses for this server now, instead of when they expire after %d days.", historyRetentionDays()),
+		Footer:      &discordgo.MessageEmbedFooter{Text: FooterText}}
+	err := confirmAction(s, i, embed, true, func() string {
+		if failed := purgeGuildHistory(guildID); len(failed) > 0 {
+			return "Purge partially failed for: " + strings.Join(failed, ", ") + ". Check the logs and try again."
+		}
+		return "This server's threshold history and stored analyses have been deleted."
+	})
+	if err != nil {
+		log.Println("history purge confirm prompt error:", err)
+	}
+}
+
+// -------------------------
+// /monitor <add | list | remove>
+// -------------------------
+func handleMonitor(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand || i.ApplicationCommandData().Name != "monitor" {
+		return
+	}
+	if i.GuildID == "" {
+		_ = respondEphemeral(s, i, "This command can only be used inside a server.")
+		return
+	}
+	if !perms.IsAllowedForRestricted(i) {
+		_ = respondNoPermission(s, i, "You don't have permission to manage monitors.")
+		return
+	}
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		_ = respondEphemeral(s, i, "Unknown subcommand.")
+		return
+	}
+	sub := data.Options[0]
+	switch sub.Name {
+	case "add":
+		var (
+			rawURL    string
+			minutes   int64
+			channelID = i.ChannelID
+		)
+		for _, opt := range sub.Options {
+			switch opt.Name {
+			case "url":
+				rawURL = opt.StringValue()
+			case "interval":
+				minutes = opt.IntValue()
+			case "channel":
+				channelID = opt.ChannelValue(nil).ID
+			}
+		}
+		interval := time.Duration(minutes) * time.Minute
+		if interval < minMonitorInterval || interval > maxMonitorInterval {
+			_ = respondEphemeral(s, i, fmt.Sprintf("`interval` must be between %d and %d minutes.",
+				int(minMonitorInterval/time.Minute), int(maxMonitorInterval/time.Minute)))
+			return
+		}
+		if _, ok := parseGalleryLink(rawURL); ok {
+			_ = respondEphemeral(s, i, "Albums and message links can't be monitored; use a direct image URL.")
+			return
+		}
+		imageURL, err := normalizeImageURL(rawURL)
+		if err != nil {
+			_ = respondEphemeral(s, i, "Invalid `url`: "+err.Error())
+			return
+		}
+		if mediaKind(imageURL) == mediaVideo {
+			_ = respondEphemeral(s, i, videoUnsupportedMessage)
+			return
+		}
+		if err := requireChannelWritable(s, interactionUserID(i), channelID); err != nil {
+			if !errors.Is(err, errChannelNotWritable) {
+				log.Println("monitor channel check error:", err)
+			}
+			_ = respondEphemeral(s, i, fmt.Sprintf("Can't alert <#%s>: %v.", channelID, err))
+			return
+		}
+		id, err := monitorStore.Add(Monitor{GuildID: i.GuildID, ChannelID: channelID, URL: imageURL, Interval: interval, CreatedBy: interactionUserID(i)})
+		if errors.Is(err, errMonitorLimit) {
+			_ = respondEphemeral(s, i, "Can't add a monitor: "+err.Error()+".")
+			return
+		}
+		if err != nil {
+			log.Println("monitor add error:", err)
+			_ = respondEphemeral(s, i, "Failed to add monitor")
+			return
+		}
+		_ = respondEphemeral(s, i, fmt.Sprintf("Monitor #%d added: %s is re-analysed every %s and <#%s> is alerted when it becomes flagged.",
+			id, imageURL, interval, channelID))
+
+	case "list":
+		monitors, err := monitorStore.List(i.GuildID)
+		if err != nil {
+			log.Println("monitor list error:", err)
+			_ = respondEphemeral(s, i, "Failed to list monitors")
+			return
+		}
+		if len(monitors) == 0 {
+			_ = respondEphemeral(s, i, "No monitors on this server. Add one with `/monitor add`.")
+			return
+		}
+		fields := make([]*discordgo.MessageEmbedField, 0, len(monitors))
+		for _, m := range monitors {
+			state := "not checked yet"
+			if m.LastAllowed != nil {
+				state = "safe"
+				if !*m.LastAllowed {
+					state = "flagged"
+				}
+				state += " (checked " + formatHistoryTime(m.LastChecked) + ")"
+			}
+			fields = append(fields, &discordgo.MessageEmbedField{Name: fmt.Sprintf("#%d", m.ID),
+				Value: fmt.Sprintf("%s\nEvery %s → <#%s>\nLast verdict: %s", m.URL, m.Interval, m.ChannelID, state), Inline: false})
+		}
+		embed := fitEmbed(&discordgo.MessageEmbed{Title: "Monitors", Color: 0x2196F3,
+			Description: fmt.Sprintf("%d of %d monitors in use", len(monitors), maxMonitorsPerGuild),
+			Fields:      fields, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}})
+		_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{Embeds: []*discordgo.MessageEmbed{embed}, Flags: discordgo.MessageFlagsEphemeral}})
+
+	case "remove":
+		var id int64
+		for _, opt := range sub.Options {
+			if opt.Name == "id" {
+				id = opt.IntValue()
+			}
+		}
+		ok, err := monitorStore.Remove(i.GuildID, id)
+		if err != nil {
+			log.Println("monitor remove error:", err)
+			_ = respondEphemeral(s, i, "Failed to remove monitor")
+			return
+		}
+		if !ok {
+			_ = respondEphemeral(s, i, fmt.Sprintf("No monitor #%d on this server. See `/monitor list`.", id))
+			return
+		}
+		_ = respondEphemeral(s, i, fmt.Sprintf("Monitor #%d removed.", id))
+
+	default:
+		_ = respondEphemeral(s, i, "Unknown subcommand.")
+	}
+}
+
+// messageFirstImage returns the first image of a message in channelID (see messageImageURLs), or
+// an empty URL and a user-facing reason when userID can't read the channel, the message can't be
+// read or it has no image
+func messageFirstImage(s Responder, userID, channelID, messageID string) (imageURL, reason string) {
+	if !snowflakeRe.MatchString(messageID) {
+		return "", "Invalid `message_id`: copy the message ID (Developer Mode → Copy Message ID)."
+	}
+	if err := requireChannelReadable(s, userID, channelID); err != nil {
+		log.Println("analyse message permission check:", err)
+		return "", "You need Read Message History in this channel to analyse one of its messages."
+	}
+	m, err := s.ChannelMessage(channelID, messageID)
+	if err != nil {
+		log.Println("analyse message fetch error:", err)
+		return "", "Couldn't read that message. It must be in this channel, still exist, and be visible to me."
+	}
+	urls := messageImageURLs(m)
+	if len(urls) == 0 {
+		return "", "That message has no image attachment or image embed to analyse."
+	}
+	return urls[0], ""
+}
+
+// -------------------------
+// Command bodies (helpers)
+// -------------------------
+func analyseCommandHandlerBody(s Responder, i *discordgo.InteractionCreate) {
+	// Extract options
+	var (
+		imageURL  string
+		messageID string
+		advanced  bool
+		raw       bool
+		export    bool
+		explain   bool
+		scorecard bool
+		asFile    bool
+		models    string
+		format    = analysisFormatDetailed
+	)
+	for _, opt := range i.ApplicationCommandData().Options {
+		switch opt.Name {
+		case "image_url":
+			imageURL = opt.StringValue()
+		case "message_id":
+			messageID = strings.TrimSpace(opt.StringValue())
+		case "advanced":
+			advanced = opt.BoolValue()
+		case "raw":
+			raw = opt.BoolValue()
+		case "export":
+			export = opt.BoolValue()
+		case "format":
+			format = opt.StringValue()
+		case "explain":
+			explain = opt.BoolValue()
+		case "scorecard":
+			scorecard = opt.BoolValue()
+		case "models":
+			models = opt.StringValue()
+		case "as_file":
+			asFile = opt.BoolValue()
+		}
+	}
+	switch {
+	case imageURL != "" && messageID != "":
+		_ = respondEphemeral(s, i, "Use either `image_url` or `message_id`, not both.")
+		return
+	case messageID != "":
+		u, msg := messageFirstImage(s, interactionUserID(i), i.ChannelID, messageID)
+		if u == "" {
+			_ = respondEphemeral(s, i, msg)
+			return
+		}
+		imageURL = u
+	case imageURL == "":
+		_ = respondEphemeral(s, i, "Missing `image_url` (or `message_id` of a message in this channel).")
+		return
+	}
+	if link, ok := parseGalleryLink(imageURL); ok {
+		if raw || advanced || export || explain || scorecard || asFile || models != "" {
+			_ = respondEphemeral(s, i, "Albums and message links support the standard analysis only (no `advanced`, `raw`, `export`, `explain`, `scorecard`, `as_file` or `models`).")
+			return
+		}
+		if format == analysisFormatJSON && !appHasPermission(i, PermAttachFiles) {
+			_ = respondEphemeral(s, i, "I don't have permission to attach files in this channel.")
+			return
+		}
+		if onCooldown(s, i, "analyse") {
+			return
+		}
+		analyseGallery(s, i, imageURL, link, format)
+		return
+	}
+	imageURL, err := normalizeImageURL(imageURL)
+	if err != nil {
+		_ = respondEphemeral(s, i, "Invalid `image_url`: "+err.Error())
+		return
+	}
+	if mediaKind(imageURL) == mediaVideo {
+		_ = respondEphemeral(s, i, videoUnsupportedMessage)
+		return
+	}
+	if raw && !IsOwner(interactionUserID(i)) {
+		_ = respondEphemeral(s, i, "Only the bot owner can request raw API output.")
+		return
+	}
+	if scorecard && (raw || advanced) {
+		_ = respondEphemeral(s, i, "`scorecard` is only available for the standard analysis.")
+		return
+	}
+	if asFile && (!advanced || raw) {
+		_ = respondEphemeral(s, i, "`as_file` is only available with `advanced:true`.")
+		return
+	}
+	if (raw || export || scorecard || asFile || format == analysisFormatJSON) && !appHasPermission(i, PermAttachFiles) {
+		_ = respondEphemeral(s, i, "I don't have permission to attach files in this channel.")
+		return
+	}
+	// A model subset only applies to the standard analysis; the default is every enabled model
+	selected, err := settingsStore.EnabledModels(i.GuildID)
+	if err != nil {
+		log.Println("guild settings read error:", err)
+		_ = respondEphemeral(s, i, settingsUnavailableMessage)
+		return
+	}
+	if models != "" {
+		if raw || advanced {
+			_ = respondEphemeral(s, i, "`models` is only available for the standard analysis.")
+			return
+		}
+		if selected, err = parseModelSelection(models, selected); err != nil {
+			_ = respondEphemeral(s, i, "Invalid `models`: "+err.Error())
+			return
+		}
+	}
+	if onCooldown(s, i, "analyse") {
+		return
+	}
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredChannelMessageWithSource}); err != nil {
+		log.Println("failed to defer interaction:", err)
+		return
+	}
+	ctx, cancel := interactionContext(i)
+	defer cancel()
+	// Raw output is for debugging, so it isn't subject to the small-image guard
+	if !raw {
+		if note := smallImageNote(ctx, i.GuildID, imageURL); note != "" {
+			_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &note})
+			return
+		}
+	}
+	if raw {
+		var out map[string]any
+		imageURL, err = withCDNRefresh(s, imageURL, func(u string) (err error) {
+			out, err = sightengine(ctx, i.GuildID, u)
+			return err
+		})
+		if err != nil {
+			respondAnalysisError(s, i, "Analysis", err)
+			return
+		}
+		b, err := rawSightengineJSON(out)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to encode raw response: %v", err)
+			respondOrFallback(s, i, &discordgo.WebhookEdit{Content: &msg})
+			return
+		}
+		msg := fmt.Sprintf("Raw Sightengine response for: %s", imageURL)
+		deliverResult(s, i, &discordgo.WebhookEdit{Content: &msg,
+			Files: []*discordgo.File{{Name: "sightengine.json", ContentType: "application/json", Reader: bytes.NewReader(b)}}})
+		return
+	}
+	if advanced {
+		var aa *AdvancedAnalysis
+		imageURL, err = withCDNRefresh(s, imageURL, func(u string) (err error) {
+			aa, err = AnalyseImageURLAdvanced(ctx, i.GuildID, u)
+			return err
+		})
+		if err != nil {
+			respondAnalysisError(s, i, "Analysis", err)
+			return
+		}
+		fields := make([]*discordgo.MessageEmbedField, 0, 6)
+		if nudity, ok := aa.Categories["nudity"]; ok {
+			fields = append(fields, formatScores("Nudity", nudity))
+		}
+		if offensive, ok := aa.Categories["offensive"]; ok {
+			fields = append(fields, formatTopScores("Offensive Content", offensive, advancedOffensiveTop))
+		}
+		if typ, ok := aa.Categories["type"]; ok {
+			fields = append(fields, formatScores("AI Usage", typ))
+		}
+		if gore, ok := aa.Categories["gore"]; ok {
+			fields = append(fields, formatScores("Gore", gore))
+		}
+		if weapon, ok := aa.Categories["weapon"]; ok {
+			fields = append(fields, formatScores("Weapons", weapon))
+		}
+		// Many subscores can push the embed past Discord's limits, which makes the edit fail
+		shownURL, mediaURI, thumbURL := imageURL, aa.MediaURI, imageURL
+		if hideFlaggedURL(i.GuildID, aa.Allowed) {
+			shownURL, mediaURI, thumbURL = hiddenFlaggedURL, "", ""
+		}
+		if isHTTPURL(mediaURI) {
+			fields = append(fields, &discordgo.MessageEmbedField{Name: "Analysed media", Value: fmt.Sprintf("[Open](%s)", mediaURI), Inline: false})
+		}
+		embed := fitEmbed(&discordgo.MessageEmbed{Title: "Image Analysis (Advanced)", Description: fmt.Sprintf("Analysis results for: %s", shownURL), Color: 0x4CAF50,
+			Fields: fields, Thumbnail: analysedThumbnail(mediaURI, thumbURL), Footer: &discordgo.MessageEmbedFooter{Text: FooterText}})
+		edit := &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}}
+		// The embed may be truncated; the file carries every subscore
+		if asFile {
+			b, err := json.MarshalIndent(aa.Categories, "", "  ")
+			if err != nil {
+				log.Println("advanced scores encode error:", err)
+			} else {
+				edit.Files = []*discordgo.File{{Name: "advanced.json", ContentType: "application/json", Reader: bytes.NewReader(b)}}
+			}
+		}
+		deliverResult(s, i, edit)
+		return
+	}
+	// Standard
+	var a *Analysis
+	imageURL, err = withCDNRefresh(s, imageURL, func(u string) (err error) {
+		if models == "" {
+			a, err = AnalyseImageURL(ctx, i.GuildID, u)
+		} else {
+			a, err = AnalyseImageURLWithModels(ctx, i.GuildID, u, selected)
+		}
+		return err
+	})
+	if err != nil {
+		respondAnalysisError(s, i, "Analysis", err)
+		return
+	}
+	edit := renderAnalysis(a, format, hideFlaggedURL(i.GuildID, a.Allowed))
+	if explain {
+		why := explainAnalysis(a)
+		switch {
+		case edit.Embeds != nil && len(*edit.Embeds) > 0:
+			e := (*edit.Embeds)[0]
+			e.Fields = append(e.Fields, &discordgo.MessageEmbedField{Name: "Why", Value: why, Inline: false})
+			fitEmbed(e)
+		case edit.Content != nil && format == analysisFormatCompact:
+			msg := *edit.Content + "\n" + why
+			edit.Content = &msg
+		}
+	}
+	if export {
+		ns, ne, off, ai, df := thresholdsStore.GetGuildThresholds(perms, i.GuildID)
+		ra, reportURL := a, imageURL
+		if hideFlaggedURL(i.GuildID, a.Allowed) {
+			ra, reportURL = withoutMediaURLs(a), hiddenFlaggedURL
+		}
+		report := formatAnalysisReport(ra, reportURL, ns, ne, off, ai, df)
+		edit.Files = append(edit.Files, &discordgo.File{Name: "analysis-report.md", ContentType: "text/markdown", Reader: strings.NewReader(report)})
+	}
+	if scorecard {
+		// A failed render only loses the image; the analysis itself is still delivered
+		if card, err := renderScorecard(a); err != nil {
+			log.Println("scorecard render error:", err)
+		} else {
+			edit.Files = append(edit.Files, &discordgo.File{Name: scorecardFile, ContentType: "image/png", Reader: bytes.NewReader(card)})
+			if edit.Embeds != nil && len(*edit.Embeds) > 0 {
+				(*edit.Embeds)[0].Image = &discordgo.MessageEmbedImage{URL: "attachment://" + scorecardFile}
+			}
+		}
+	}
+	deliverResult(s, i, edit)
+}
+
+// analyseGallery analyses every image behind a message or album link (up to maxGalleryImages)
+// and reports a combined verdict: the album is unsafe if any image is flagged
+func analyseGallery(s Responder, i *discordgo.InteractionCreate, link string, gl galleryLink, format string) {
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredChannelMessageWithSource}); err != nil {
+		log.Println("failed to defer interaction:", err)
+		return
+	}
+	ctx, cancel := interactionContext(i)
+	defer cancel()
+	urls, err := resolveGallery(ctx, s, i.GuildID, interactionUserID(i), gl)
+	if err != nil {
+		log.Printf("resolve gallery %s failed: %v", link, err)
+		msg := truncateRunes("Couldn't read the album: "+err.Error(), messageContentLimit)
+		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &msg})
+		return
+	}
+	if len(urls) == 0 {
+		msg := "No images found at that link."
+		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &msg})
+		return
+	}
+	skipped := 0
+	if len(urls) > maxGalleryImages {
+		skipped = len(urls) - maxGalleryImages
+		urls = urls[:maxGalleryImages]
+	}
+	results := runPool(ctx, urls, func(ctx context.Context, u string) (*Analysis, error) {
+		u, err := normalizeImageURL(u)
+		if err != nil {
+			return nil, err
+		}
+		var a *Analysis
+		_, err = withCDNRefresh(s, u, func(u string) (err error) {
+			a, err = AnalyseImageURL(ctx, i.GuildID, u)
+			return err
+		})
+		return a, err
+	})
+
+	flagged, failed := 0, 0
+	lines := make([]string, 0, len(results))
+	analyses := make([]*Analysis, 0, len(results))
+	for n, r := range results {
+		switch {
+		case r.Err != nil:
+			failed++
+			msg, _ := classifySightengineError(r.Err)
+			lines = append(lines, fmt.Sprintf("%d. ⚠️ Failed: %s", n+1, msg))
+		case !r.Value.Allowed:
+			flagged++
+			shownURL := r.URL
+			if hideFlaggedURL(i.GuildID, false) {
+				shownURL = hiddenFlaggedURL
+			}
+			lines = append(lines, fmt.Sprintf("%d. ⛔ Flagged (%s) — %s", n+1, strings.Join(r.Value.Reasons, ", "), shownURL))
+		default:
+			lines = append(lines, fmt.Sprintf("%d. ✅ Safe — %s", n+1, r.URL))
+		}
+		if r.Err == nil {
+			if hideFlaggedURL(i.GuildID, r.Value.Allowed) {
+				analyses = append(analyses, withoutMediaURLs(r.Value))
+			} else {
+				analyses = append(analyses, r.Value)
+			}
+		}
+	}
+	verdict := "Safe"
+	switch {
+	case flagged > 0:
+		verdict = fmt.Sprintf("Unsafe (%d of %d flagged)", flagged, len(results))
+	case failed > 0:
+		verdict = fmt.Sprintf("Inconclusive (%d of %d failed)", failed, len(results))
+	}
+	note := ""
+	if skipped > 0 {
+		note = fmt.Sprintf("\nOnly the first %d images were analysed; %d skipped.", maxGalleryImages, skipped)
+	}
+
+	switch format {
+	case analysisFormatCompact:
+		msg := fmt.Sprintf("%s: %s%s", verdict, link, note)
+		deliverResult(s, i, &discordgo.WebhookEdit{Content: &msg})
+		return
+	case analysisFormatJSON:
+		b, err := json.MarshalIndent(analyses, "", "  ")
+		if err != nil {
+			msg := fmt.Sprintf("Failed to encode analysis: %v", err)
+			respondOrFallback(s, i, &discordgo.WebhookEdit{Content: &msg})
+			return
+		}
+		msg := fmt.Sprintf("%s: %s%s", verdict, link, note)
+		deliverResult(s, i, &discordgo.WebhookEdit{Content: &msg,
+			Files: []*discordgo.File{{Name: "analysis.json", ContentType: "application/json", Reader: bytes.NewReader(b)}}})
+		return
+	}
+	color := 0x00BFA5
+	if flagged > 0 {
+		color = 0xE74C3C
+	}
+	// fitEmbed shortens the breakdown if long URLs push it past the field limit
+	embed := fitEmbed(&discordgo.MessageEmbed{Title: "Album Analysis", Description: fmt.Sprintf("Analysis results for %d images from: %s%s", len(results), link, note), Color: color,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Safe Album", Value: fmt.Sprintf("%t", flagged == 0 && failed == 0), Inline: true},
+			{Name: "Verdict", Value: verdict, Inline: true},
+			{Name: "Images", Value: strings.Join(lines, "\n"), Inline: false},
+		},
+		Footer: &discordgo.MessageEmbedFooter{Text: FooterText}})
+	deliverResult(s, i, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
+}
+
+func aiCommandHandlerBody(s Responder, i *discordgo.InteractionCreate) {
+	var (
+		imageURL string
+		advanced bool
+	)
+	for _, opt := range i.ApplicationCommandData().Options {
+		switch opt.Name {
+		case "image_url":
+			imageURL = opt.StringValue()
+		case "advanced":
+			advanced = opt.BoolValue()
+		}
+	}
+	if imageURL == "" {
+		_ = respondEphemeral(s, i, "Missing `image_url`.")
+		return
+	}
+	imageURL, err := normalizeImageURL(imageURL)
+	if err != nil {
+		_ = respondEphemeral(s, i, "Invalid `image_url`: "+err.Error())
+		return
+	}
+	if msg := stillImageOnlyMessage(mediaKind(imageURL)); msg != "" {
+		_ = respondEphemeral(s, i, msg)
+		return
+	}
+	if onCooldown(s, i, "ai") {
+		return
+	}
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredChannelMessageWithSource}); err != nil {
+		log.Println("failed to defer ai interaction:", err)
+		return
+	}
+	ctx, cancel := interactionContext(i)
+	defer cancel()
+	if advanced {
+		var aa *AdvancedAnalysis
+		imageURL, err = withCDNRefresh(s, imageURL, func(u string) (err error) {
+			aa, err = AnalyseImageURLAIOnlyAdvanced(ctx, i.GuildID, u)
+			return err
+		})
+		if err != nil {
+			respondAnalysisError(s, i, "AI check", err)
+			return
+		}
+		shownURL := imageURL
+		if hideFlaggedURL(i.GuildID, aa.Allowed) {
+			shownURL = hiddenFlaggedURL
+		}
+		embed := fitEmbed(&discordgo.MessageEmbed{Title: "AI Usage Check (Advanced)", Description: fmt.Sprintf("Analysis results for: %s", shownURL), Color: 0x3F51B5,
+			Fields: []*discordgo.MessageEmbedField{formatScores("AI Usage", aa.Categories["type"])}, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}})
+		deliverResult(s, i, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
+		return
+	}
+	var analysis *Analysis
+	imageURL, err = withCDNRefresh(s, imageURL, func(u string) (err error) {
+		analysis, err = AnalyseImageURLAIOnly(ctx, i.GuildID, u)
+		return err
+	})
+	if err != nil {
+		respondAnalysisError(s, i, "AI check", err)
+		return
+	}
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "Safe Image", Value: fmt.Sprintf("%t", analysis.Allowed), Inline: true},
+		{Name: "AI Generated", Value: fmt.Sprintf("%.0f%%", analysis.Scores.AIGenerated*100), Inline: true},
+		{Name: "Deepfake", Value: fmt.Sprintf("%.0f%%", analysis.Scores.Deepfake*100), Inline: true},
+	}
+	if note := partialResultsNote(analysis); note != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "⚠️ Partial results", Value: note, Inline: false})
+	}
+	shownURL := imageURL
+	if hideFlaggedURL(i.GuildID, analysis.Allowed) {
+		shownURL = hiddenFlaggedURL
+	}
+	embed := &discordgo.MessageEmbed{Title: "AI Usage Check", Description: fmt.Sprintf("Analysis results for: %s", shownURL), Color: 0x3F51B5,
+		Fields: fields, Footer: analysisFooter(analysis)}
+	deliverResult(s, i, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
+}
+
+// formatScores renders a category's subscores as an embed field, highest first
+func formatScores(title string, m map[string]float64) *discordgo.MessageEmbedField {
+	return formatTopScores(title, m, 0)
+}
+
+// advancedOffensiveTop is how many offensive classes the advanced view lists; the model returns
+// more classes than fit comfortably, most of them near 0
+const advancedOffensiveTop = 5
+
+// formatTopScores is formatScores limited to the top n subscores (0 lists all), noting how many were left out
+func formatTopScores(title string, m map[string]float64, n int) *discordgo.MessageEmbedField {
+	if len(m) == 0 {
+		return &discordgo.MessageEmbedField{Name: title, Value: "none", Inline: false}
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return m[keys[i]] > m[keys[j]] })
+	var b strings.Builder
+	var omitted int
+	if n > 0 && len(keys) > n {
+		keys, omitted = keys[:n], len(keys)-n
+	}
+	for _, k := range keys {
+		_, _ = fmt.Fprintf(&b, "%s: %.0f%%\n", k, m[k]*100)
+	}
+	if omitted > 0 {
+		_, _ = fmt.Fprintf(&b, "…and %d more\n", omitted)
+	}
+	val := strings.TrimRight(b.String(), "\n")
+	return &discordgo.MessageEmbedField{Name: title, Value: val, Inline: false}
+}
+
+// defaultFollowupAfter is how long after the command was invoked results switch from
+// editing the deferred response to a follow-up message (FOLLOWUP_AFTER_SECONDS overrides)
+const defaultFollowupAfter = 5 * time.Minute
+
+// followupAfter returns the elapsed-time threshold for posting results as a follow-up
+func followupAfter() time.Duration {
+	if v, err := strconv.Atoi(strings.TrimSpace(os.Getenv("FOLLOWUP_AFTER_SECONDS"))); err == nil && v > 0 {
+		return time.Duration(v) * time.Second
+	}
+	return defaultFollowupAfter
+}
+
+// deliverResult edits the deferred response with the result, or, when the analysis took
+// longer than followupAfter, posts it as a follow-up message so users get a fresh
+// notification and the result doesn't hinge on editing an old response
+func deliverResult(s Responder, i *discordgo.InteractionCreate, edit *discordgo.WebhookEdit) {
+	edit = fitWebhookEdit(edit)
+	created := time.Now()
+	if ts, err := discordgo.SnowflakeTimestamp(i.ID); err == nil {
+		created = ts
+	}
+	if time.Since(created) < followupAfter() {
+		respondOrFallback(s, i, edit)
+		return
+	}
+	params := &discordgo.WebhookParams{Files: edit.Files, AllowedMentions: edit.AllowedMentions}
+	if edit.Content != nil {
+		params.Content = *edit.Content
+	}
+	if edit.Embeds != nil {
+		params.Embeds = *edit.Embeds
+	}
+	if _, err := s.FollowupMessageCreate(i.Interaction, true, params); err != nil {
+		log.Println("failed to post follow-up result, editing original instead:", err)
+		respondOrFallback(s, i, edit)
+		return
+	}
+	done := "Finished — see the result below."
+	_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &done})
+}
+
+// respondOrFallback edits the deferred response with edit. When Discord rejects the edit because
+// the interaction token has expired (results of very long analyses), the result is posted as a
+// new message in the channel instead, mentioning the invoker, so it isn't lost
+func respondOrFallback(s Responder, i *discordgo.InteractionCreate, edit *discordgo.WebhookEdit) {
+	_, err := s.InteractionResponseEdit(i.Interaction, edit)
+	if err == nil || !isInteractionTokenExpired(err) {
+		return
+	}
+	log.Println("interaction token expired, posting result to the channel instead")
+	msg := &discordgo.MessageSend{AllowedMentions: &discordgo.MessageAllowedMentions{}}
+	if uid := interactionUserID(i); uid != "" {
+		msg.Content = "<@" + uid + "> here is your result:"
+		msg.AllowedMentions.Users = []string{uid}
+	}
+	if edit.Content != nil && *edit.Content != "" {
+		msg.Content = truncateRunes(strings.TrimSpace(msg.Content+"\n"+*edit.Content), messageContentLimit)
+	}
+	if edit.Embeds != nil {
+		msg.Embeds = *edit.Embeds
+	}
+	// The failed edit may have consumed the attachments; rewind them for the new message
+	for _, f := range edit.Files {
+		if seeker, ok := f.Reader.(io.Seeker); ok {
+			_, _ = seeker.Seek(0, io.SeekStart)
+		}
+	}
+	msg.Files = edit.Files
+	if _, err := s.ChannelMessageSendComplex(i.ChannelID, msg); err != nil {
+		log.Println("failed to post result after token expiry:", err)
+	}
+}
+
+// isInteractionTokenExpired reports whether a webhook call failed because the interaction
+// token is no longer valid (Discord answers "Invalid Webhook Token" or "Unknown Webhook")
+func isInteractionTokenExpired(err error) bool {
+	var restErr *discordgo.RESTError
+	if !errors.As(err, &restErr) || restErr.Message == nil {
+		return false
+	}
+	return restErr.Message.Code == discordgo.ErrCodeInvalidWebhookTokenProvided || restErr.Message.Code == discordgo.ErrCodeUnknownWebhook
+}
+
+// Output formats for /analyse (the format option)
+const (
+	analysisFormatCompact  = "compact"  // one-line verdict
+	analysisFormatDetailed = "detailed" // embed with every score (default)
+	analysisFormatJSON     = "json"     // Analysis as an attached JSON file
+)
+
+// renderAnalysis builds the response for a standard analysis in the requested format;
+// unknown formats fall back to detailed
+func renderAnalysis(a *Analysis, format string, hideURL bool) *discordgo.WebhookEdit {
+	shownURL, mediaURI, thumbURL := a.ImageURL, a.MediaURI, a.ImageURL
+	if hideURL {
+		shownURL, mediaURI, thumbURL = hiddenFlaggedURL, "", ""
+	}
+	switch format {
+	case analysisFormatCompact:
+		verdict := "Safe"
+		if !a.Allowed {
+			verdict = "Flagged (" + strings.Join(a.Reasons, ", ") + ")"
+		}
+		msg := fmt.Sprintf("%s: %s", verdict, shownURL)
+		if note := partialResultsNote(a); note != "" {
+			msg += "\n⚠️ " + note
+		}
+		return &discordgo.WebhookEdit{Content: &msg}
+	case analysisFormatJSON:
+		v := a
+		if hideURL {
+			v = withoutMediaURLs(a)
+		}
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			msg := fmt.Sprintf("Failed to encode analysis: %v", err)
+			return &discordgo.WebhookEdit{Content: &msg}
+		}
+		msg := fmt.Sprintf("Analysis results for: %s", shownURL)
+		return &discordgo.WebhookEdit{Content: &msg,
+			Files: []*discordgo.File{{Name: "analysis.json", ContentType: "application/json", Reader: bytes.NewReader(b)}}}
+	}
+	// Only categories of requested models are listed; the rest weren't scored
+	var results []string
+	if a.Scored("nudity-2.1") {
+		results = append(results,
+			fmt.Sprintf("Nudity (Explicit): %.0f%%%s", a.Scores.NudityExplicit*100, dominantSuffix(a.Dominant.NudityExplicit)),
+			fmt.Sprintf("Nudity (Suggestive): %.0f%%%s", a.Scores.NuditySuggestive*100, dominantSuffix(a.Dominant.NuditySuggestive)))
+	}
+	if a.Scored("offensive-2.0") {
+		results = append(results, fmt.Sprintf("Offensive: %.0f%%%s", a.Scores.Offensive*100, dominantSuffix(a.Dominant.Offensive)))
+	}
+	if a.Scored("genai") {
+		results = append(results, fmt.Sprintf("AI Generated: %.0f%%", a.Scores.AIGenerated*100),
+			fmt.Sprintf("Deepfake: %.0f%%", a.Scores.Deepfake*100))
+	}
+	if len(results) == 0 {
+		results = append(results, "No scored categories for the selected models")
+	}
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "Safe Image", Value: fmt.Sprintf("%t", a.Allowed), Inline: true},
+		{Name: "Results", Value: strings.Join(results, "\n"), Inline: false},
+	}
+	if len(a.Models) > 0 && len(a.Models) < len(sightengineModels) {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Models", Value: strings.Join(a.Models, ", "), Inline: false})
+	}
+	if note := partialResultsNote(a); note != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "⚠️ Partial results", Value: note, Inline: false})
+	}
+	if isHTTPURL(mediaURI) {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Analysed media", Value: fmt.Sprintf("[Open](%s)", mediaURI), Inline: false})
+	}
+	embed := fitEmbed(&discordgo.MessageEmbed{Title: "Image Analysis", Description: fmt.Sprintf("Analysis results for: %s", shownURL), Color: 0x00BFA5,
+		Fields: fields, Thumbnail: analysedThumbnail(mediaURI, thumbURL), Footer: analysisFooter(a)})
+	return &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}}
+}
+
+// partialResultsNote warns that some categories couldn't be evaluated, so their 0% scores and
+// the verdict shouldn't be trusted on their own; empty when every requested model answered
+func partialResultsNote(a *Analysis) string {
+	if len(a.Unevaluated) == 0 {
+		return ""
+	}
+	return "Couldn't evaluate: " + strings.Join(a.Unevaluated, ", ") + ". Those scores show as 0%, so review the image manually."
+}
+
+// hiddenFlaggedURL stands in for the image URL of flagged results when echo_flagged_url is off
+const hiddenFlaggedURL = "[hidden: flagged content]"
+
+// hideFlaggedURL reports whether a result's image URL and preview must be left out of the reply:
+// the image was flagged and the guild turned echo_flagged_url off. When the settings can't be
+// loaded, flagged URLs are hidden
+func hideFlaggedURL(guildID string, allowed bool) bool {
+	if allowed || guildID == "" {
+		return false
+	}
+	gs, err := settingsStore.Get(guildID)
+	if err != nil {
+		log.Println("guild settings read error:", err)
+		return true
+	}
+	return gs.HideFlaggedURL
+}
+
+// withoutMediaURLs returns a copy of a without the image URL and analysed media, for the
+// attachments of results whose URL is hidden
+func withoutMediaURLs(a *Analysis) *Analysis {
+	c := *a
+	c.ImageURL, c.MediaURI = "", ""
+	return &c
+}
+
+// analysedThumbnail shows the copy Sightengine analysed when it returned a link to one
+// (it was reachable by them, so it's the safest preview), else the user-supplied URL
+func analysedThumbnail(mediaURI, imageURL string) *discordgo.MessageEmbedThumbnail {
+	if isHTTPURL(mediaURI) {
+		return &discordgo.MessageEmbedThumbnail{URL: mediaURI}
+	}
+	if isHTTPURL(imageURL) {
+		return &discordgo.MessageEmbedThumbnail{URL: imageURL}
+	}
+	return nil
+}
+
+// isHTTPURL reports whether s is an absolute http(s) URL. Uploaded media report a bare
+// filename as their URI, which Discord can't render
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// dominantSuffix renders the subscore behind a category score, e.g. " (sexual_display)"
+func dominantSuffix(label string) string {
+	if label == "" {
+		return ""
+	}
+	return " (" + label + ")"
+}
+
+// explainAnalysis lists each reason with the subscore that tripped it and the margin over
+// its threshold, e.g. "nudity_explicit: sexual_display 0.41 ≥ 0.25 (+0.16)"
+func explainAnalysis(a *Analysis) string {
+	if len(a.Details) == 0 {
+		return "No category reached its threshold."
+	}
+	lines := make([]string, 0, len(a.Details))
+	for _, d := range a.Details {
+		lines = append(lines, fmt.Sprintf("%s: %s %.2f ≥ %.2f (+%.2f)", d.Reason, d.Subscore, d.Score, d.Threshold, d.Score-d.Threshold))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// analysisFooter appends the Sightengine request/media IDs to the standard footer so
+// users can reference them when disputing a result
+func analysisFooter(a *Analysis) *discordgo.MessageEmbedFooter {
+	text := FooterText
+	if a.RequestID != "" {
+		text += " • Request ID: " + a.RequestID
+	}
+	if a.MediaID != "" {
+		text += " • Media ID: " + a.MediaID
+	}
+	return &discordgo.MessageEmbedFooter{Text: text}
+}
+
+// respondAnalysisError logs the raw error and edits the deferred response with a
+// friendly embed, distinguishing temporary service outages from bad input
+func respondAnalysisError(s Responder, i *discordgo.InteractionCreate, action string, err error) {
+	log.Printf("%s failed: %v", strings.ToLower(action), err)
+	userMsg, retryable := classifySightengineError(err)
+	title := action + " Failed"
+	color := 0xE74C3C
+	if retryable {
+		title = "Service Temporarily Unavailable"
+		color = 0xF39C12
+	}
+	embed := &discordgo.MessageEmbed{Title: title, Description: userMsg, Color: color,
+		Footer: &discordgo.MessageEmbedFooter{Text: FooterText}}
+	deliverResult(s, i, &discordgo.WebhookEdit{Embeds: &[]*discordgo.MessageEmbed{embed}})
+}
+
+// renderBar draws a fixed-width text gauge for a 0..1 value, e.g. "█████░░░░░" for 0.5
+func renderBar(value float64, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if value < 0 {
+		value = 0
+	}
+	if value > 1 {
+		value = 1
+	}
+	filled := int(value*float64(width) + 0.5)
+	return strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+}
+
+// formatHistoryTime renders a stored timestamp in UTC with an explicit suffix, whichever
+// dialect or zone it was read in, e.g. "2026-10-17 14:03:09 UTC"
+func formatHistoryTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05") + " UTC"
+}
+
+// formatThresholdPercent renders a 0..1 threshold as a percentage with up to two decimals
+// and no trailing zeros ("75%", "0.5%"). Values are clamped to 0..1, and values that would
+// round to 0% or 100% without being exactly that show as "<0.01%" / ">99.99%"
+func formatThresholdPercent(v float64) string {
+	v = min(max(v, 0), 1)
+	p := v * 100
+	switch {
+	case p > 0 && p < 0.005:
+		return "<0.01%"
+	case p < 100 && p >= 99.995:
+		return ">99.99%"
+	}
+	s := strconv.FormatFloat(p, 'f', 2, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	return s + "%"
+}
+
+// errThresholdNotFinite rejects NaN and infinite thresholds, which would slip past range checks
+var errThresholdNotFinite = errors.New("must be a finite number")
+
+// parseThresholdValue reads a threshold as a 0..1 decimal ("0.25"), a percentage ("25%") or a
+// bare whole number of percent ("25"). A whole number above 1 is a percentage; "0" and "1" read
+// the same either way (0% and 100%). Anything with a decimal point is a fraction, so "1.0" is 100%
+// and "50.5" is out of range rather than 50.5%. NaN and infinities are rejected here, since they
+// compare false against any bound; range checks are left to the caller
+func parseThresholdValue(in string) (float64, error) {
+	v, err := parseThresholdNumber(in)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, errThresholdNotFinite
+	}
+	return v, nil
+}
+
+// errThresholdRange rejects thresholds outside 0..1
+var errThresholdRange = errors.New("must be a decimal between 0.00 and 1.00, or a percentage like 70% or 70")
+
+// parseThresholdInput is parseThresholdValue plus the 0..1 range check, for values typed into
+// commands
+func parseThresholdInput(in string) (float64, error) {
+	v, err := parseThresholdValue(in)
+	if err != nil || v < 0 || v > 1 {
+		return 0, errThresholdRange
+	}
+	return v, nil
+}
+
+// parseThresholdNumber reads the number for parseThresholdValue without the finiteness check
+func parseThresholdNumber(in string) (float64, error) {
+	s := strings.TrimSpace(in)
+	if strings.HasSuffix(s, "%") {
+		p := strings.TrimSuffix(s, "%")
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return 0, err
+		}
+		return f / 100.0, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n > 1 {
+		return float64(n) / 100.0, nil
 	}
 	return strconv.ParseFloat(s, 64)
 }
@@ -598,6 +2741,8 @@ func canonicalThresholdName(in string) (string, bool) {
 		return "Offensive", true
 	case "aigenerated", "ai", "genai", "ai_generated":
 		return "AIGenerated", true
+	case "deepfake", "deep_fake", "deepfake_detected":
+		return "Deepfake", true
 	default:
 		return "", false
 	}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// fakeResponder is a Responder that records what handler bodies send. Messages and channel
+// permissions are looked up in the maps; the *Err fields make the matching calls fail
+type fakeResponder struct {
+	mu        sync.Mutex
+	responses []*discordgo.InteractionResponse
+	edits     []*discordgo.WebhookEdit
+	followups []*discordgo.WebhookParams
+	sent      []*discordgo.MessageSend
+	sentTo    []string
+
+	latency     time.Duration
+	messages    map[string]*discordgo.Message // keyed by channelID + "/" + messageID
+	channelPerm map[string]int64              // invoking user's permissions, keyed by channelID
+
+	respondErr error
+	editErr    error
+}
+
+var _ Responder = (*fakeResponder)(nil)
+
+func (f *fakeResponder) InteractionRespond(_ *discordgo.Interaction, resp *discordgo.InteractionResponse, _ ...discordgo.RequestOption) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses = append(f.responses, resp)
+	return f.respondErr
+}
+
+func (f *fakeResponder) InteractionResponseEdit(_ *discordgo.Interaction, edit *discordgo.WebhookEdit, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.edits = append(f.edits, edit)
+	// Like the REST call, a failed edit consumes the attachments
+	if f.editErr != nil {
+		for _, file := range edit.Files {
+			_, _ = io.Copy(io.Discard, file.Reader)
+		}
+		return nil, f.editErr
+	}
+	return &discordgo.Message{}, nil
+}
+
+func (f *fakeResponder) FollowupMessageCreate(_ *discordgo.Interaction, _ bool, data *discordgo.WebhookParams, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.followups = append(f.followups, data)
+	return &discordgo.Message{}, nil
+}
+
+func (f *fakeResponder) HeartbeatLatency() time.Duration { return f.latency }
+
+func (f *fakeResponder) ChannelMessage(channelID, messageID string, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	if m, ok := f.messages[channelID+"/"+messageID]; ok {
+		return m, nil
+	}
+	return nil, &discordgo.RESTError{Message: &discordgo.APIErrorMessage{Code: discordgo.ErrCodeUnknownMessage, Message: "Unknown Message"}}
+}
+
+func (f *fakeResponder) ChannelMessages(string, int, string, string, string, ...discordgo.RequestOption) ([]*discordgo.Message, error) {
+	return nil, nil
+}
+
+func (f *fakeResponder) UserChannelPermissions(_, channelID string, _ ...discordgo.RequestOption) (int64, error) {
+	return f.channelPerm[channelID], nil
+}
+
+func (f *fakeResponder) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, data)
+	f.sentTo = append(f.sentTo, channelID)
+	return &discordgo.Message{}, nil
+}
+
+// lastEdit returns the most recent response edit, failing the test when there is none
+func (f *fakeResponder) lastEdit(t *testing.T) *discordgo.WebhookEdit {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.edits) == 0 {
+		t.Fatal("no response edit was sent")
+	}
+	return f.edits[len(f.edits)-1]
+}
+
+// recordingTransport answers every Discord API request with an empty success, keeping each
+// one's method, path and body, for code that still takes the concrete session
+type recordingTransport struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (rt *recordingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	var body []byte
+	if r.Body != nil {
+		body, _ = io.ReadAll(r.Body)
+	}
+	rt.mu.Lock()
+	rt.requests = append(rt.requests, r.Method+" "+r.URL.Path+" "+string(body))
+	rt.mu.Unlock()
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}},
+		Body: io.NopCloser(strings.NewReader("{}")), Request: r}, nil
+}
+
+// offlineSession returns a session whose API calls never leave the process
+func offlineSession(t *testing.T, rt *recordingTransport) *discordgo.Session {
+	t.Helper()
+	s, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Client = &http.Client{Transport: rt}
+	s.State.User = &discordgo.User{ID: "bot"}
+	return s
+}
+
+// count returns how many API requests were made
+func (rt *recordingTransport) count() int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return len(rt.requests)
+}
+
+// sent returns every recorded request, one per line
+func (rt *recordingTransport) sent() string {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return strings.Join(rt.requests, "\n")
+}
+
+// nowSnowflake returns an ID minted now, so interactionContext gives a live deadline
+func nowSnowflake() string {
+	const discordEpochMillis = 1420070400000
+	return strconv.FormatInt((time.Now().UnixMilli()-discordEpochMillis)<<22, 10)
+}
+
+// testCommand builds a guild slash-command interaction from user u1 in channel c1 of guild g1
+func testCommand(name string, opts ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:        nowSnowflake(),
+		Type:      discordgo.InteractionApplicationCommand,
+		GuildID:   "g1",
+		ChannelID: "c1",
+		Member:    &discordgo.Member{User: &discordgo.User{ID: "u1"}},
+		Data:      discordgo.ApplicationCommandInteractionData{Name: name, Options: opts},
+	}}
+}
+
+// stringOpt and boolOpt build command options
+func stringOpt(name, value string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionString, Value: value}
+}
+
+func boolOpt(name string, value bool) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionBoolean, Value: value}
+}
+
+// useTestSightengine routes analyses to an httptest server running h for the rest of the test
+func useTestSightengine(t *testing.T, h http.HandlerFunc) {
+	t.Helper()
+	prev := defaultSightengineClient
+	defaultSightengineClient = newTestSightengineClient(t, h, "u1")
+	t.Cleanup(func() { defaultSightengineClient = prev })
+}
+
+func TestPingCommandHandlerBody(t *testing.T) {
+	f := &fakeResponder{latency: 42 * time.Millisecond}
+	detailsCalled := false
+	pingCommandHandlerBody(f, testCommand("ping"), func() []*discordgo.MessageEmbedField {
+		detailsCalled = true
+		return nil
+	})
+
+	if len(f.responses) != 1 || f.responses[0].Type != discordgo.InteractionResponseDeferredChannelMessageWithSource {
+		t.Fatalf("responses = %+v, want one deferral", f.responses)
+	}
+	embeds := *f.lastEdit(t).Embeds
+	if len(embeds) != 1 || embeds[0].Title != "Pong!" {
+		t.Fatalf("embeds = %+v, want Pong!", embeds)
+	}
+	if got := embeds[0].Fields[1].Value; got != "42 ms" {
+		t.Errorf("API latency = %q, want 42 ms", got)
+	}
+	if detailsCalled {
+		t.Error("details requested without detailed:true")
+	}
+}
+
+func TestAnalyseCommandHandlerBodyRejectsBadURL(t *testing.T) {
+	f := &fakeResponder{}
+	analyseCommandHandlerBody(f, testCommand("analyse", stringOpt("image_url", "ftp://example.com/a.png")))
+
+	if len(f.responses) != 1 {
+		t.Fatalf("responses = %d, want 1", len(f.responses))
+	}
+	data := f.responses[0].Data
+	if data.Flags&discordgo.MessageFlagsEphemeral == 0 || !strings.HasPrefix(data.Content, "Invalid `image_url`") {
+		t.Errorf("response = %q (flags %d), want ephemeral invalid URL message", data.Content, data.Flags)
+	}
+	if len(f.edits) != 0 {
+		t.Error("rejected input must not be deferred and edited")
+	}
+}
+
+func TestAnalyseCommandHandlerBodyDeliversResult(t *testing.T) {
+	useTestSightengine(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"status":"success","nudity":{"sexual_activity":0.99,"sexual_display":0.01,"erotica":0.01,"none":0.01},"offensive":{"nazi":0.01},"type":{"ai_generated":0.02}}`)
+	})
+	f := &fakeResponder{}
+	analyseCommandHandlerBody(f, testCommand("analyse", stringOpt("image_url", "https://example.com/handler-body.png")))
+
+	if len(f.responses) != 1 || f.responses[0].Type != discordgo.InteractionResponseDeferredChannelMessageWithSource {
+		t.Fatalf("responses = %+v, want one deferral", f.responses)
+	}
+	edit := f.lastEdit(t)
+	if edit.Embeds == nil || len(*edit.Embeds) == 0 {
+		t.Fatalf("edit has no embed: %+v", edit)
+	}
+	e := (*edit.Embeds)[0]
+	if e.Title != "Image Analysis" {
+		t.Errorf("title = %q, want Image Analysis", e.Title)
+	}
+	if got := fieldValues(e); !strings.Contains(got, "Safe Image: false") || !strings.Contains(got, "Nudity (Explicit): 99%") {
+		t.Errorf("explicit nudity not flagged:\n%s", got)
+	}
+}
+
+// fieldValues joins an embed's field names and values for substring checks
+func fieldValues(e *discordgo.MessageEmbed) string {
+	var b strings.Builder
+	for _, f := range e.Fields {
+		b.WriteString(f.Name + ": " + f.Value + "\n")
+	}
+	return b.String()
+}
+
+func TestSafeHandlerRecoversPanic(t *testing.T) {
+	f := &fakeResponder{}
+	h := safeHandler(func(*fakeResponder, *discordgo.InteractionCreate) { panic("boom") })
+	h(f, testCommand("analyse"))
+
+	if len(f.responses) != 1 {
+		t.Fatalf("responses = %d, want 1", len(f.responses))
+	}
+	data := f.responses[0].Data
+	if data.Flags&discordgo.MessageFlagsEphemeral == 0 || !strings.Contains(data.Content, "Something went wrong") {
+		t.Errorf("response = %q (flags %d), want ephemeral error", data.Content, data.Flags)
+	}
+}
+
+func TestSafeHandlerEditsDeferredResponseAfterPanic(t *testing.T) {
+	// Once the handler has responded, a second response fails and the error replaces the deferral
+	f := &fakeResponder{respondErr: errors.New("interaction has already been acknowledged")}
+	h := safeHandler(func(*fakeResponder, *discordgo.InteractionCreate) { panic("boom") })
+	h(f, testCommand("analyse"))
+
+	if got := f.lastEdit(t).Content; got == nil || !strings.Contains(*got, "Something went wrong") {
+		t.Errorf("edit content = %v, want the error message", got)
+	}
+}
+
+func TestSafeHandlerWrapsSessionHandlers(t *testing.T) {
+	// discordgo.AddHandler only recognises the exact func type
+	var h any = safeHandler(handleAbout)
+	if _, ok := h.(func(*discordgo.Session, *discordgo.InteractionCreate)); !ok {
+		t.Errorf("safeHandler(handleAbout) has type %T, want an interaction handler", h)
+	}
+}
+
+func TestSettingsSetOwnersNeedsAdmin(t *testing.T) {
+	t.Setenv("OWNER_ID", "900000001")
+	t.Setenv("EXTRA_OWNER_IDS", "")
+	if err := settingsStore.Set("g1", "owners", "100000001"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = settingsStore.ClearGuild("g1") })
+	set := func(key, value string, memberPerms int64) string {
+		rt := &recordingTransport{}
+		sub := &discordgo.ApplicationCommandInteractionDataOption{Name: "set", Type: discordgo.ApplicationCommandOptionSubCommand,
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{stringOpt("key", key), stringOpt("value", value)}}
+		i := testCommand("settings", sub)
+		i.Member.User.ID, i.Member.Permissions = "100000001", memberPerms
+		handleSettings(offlineSession(t, rt), i)
+		return strings.Join(rt.requests, "\n")
+	}
+
+	// 100000001 is a delegated owner: other settings are fine, owners is not
+	if sent := set("show_allowed_roles", "on", 0); !strings.Contains(sent, "Set `show_allowed_roles`") {
+		t.Fatalf("delegated owner couldn't change a setting: %s", sent)
+	}
+	for _, key := range []string{"owners", " Owners "} {
+		if sent := set(key, "100000001, 100000002", 0); !strings.Contains(sent, "Only server admins") {
+			t.Fatalf("delegated owner changed %q: %s", key, sent)
+		}
+	}
+	if gs, err := settingsStore.Get("g1"); err != nil || !slices.Equal(gs.DelegatedOwners, []string{"100000001"}) {
+		t.Fatalf("owners = %v, %v after refused edits, want [100000001]", gs.DelegatedOwners, err)
+	}
+	if sent := set("owners", "100000001, 100000002", PermManageGuild); !strings.Contains(sent, "Set `owners`") {
+		t.Fatalf("admin couldn't change owners: %s", sent)
+	}
+}
+
+func TestRespondOrFallbackPostsToChannelWhenTokenExpired(t *testing.T) {
+	for _, code := range []int{discordgo.ErrCodeUnknownWebhook, discordgo.ErrCodeInvalidWebhookTokenProvided} {
+		f := &fakeResponder{editErr: &discordgo.RESTError{Message: &discordgo.APIErrorMessage{Code: code}}}
+		content := "Result"
+		file := &discordgo.File{Name: "analysis.json", Reader: strings.NewReader(`{"ok":true}`)}
+		respondOrFallback(f, testCommand("analyse"), &discordgo.WebhookEdit{Content: &content, Files: []*discordgo.File{file}})
+
+		if len(f.sent) != 1 || f.sentTo[0] != "c1" {
+			t.Fatalf("code %d: sent = %d to %v, want one post to c1", code, len(f.sent), f.sentTo)
+		}
+		msg := f.sent[0]
+		if msg.Content != "<@u1> here is your result:\nResult" {
+			t.Errorf("code %d: content = %q", code, msg.Content)
+		}
+		if len(msg.AllowedMentions.Users) != 1 || msg.AllowedMentions.Users[0] != "u1" {
+			t.Errorf("code %d: allowed mentions = %+v, want only the invoker", code, msg.AllowedMentions)
+		}
+		if len(msg.Files) != 1 {
+			t.Fatalf("code %d: files = %d, want 1", code, len(msg.Files))
+		}
+		if data, _ := io.ReadAll(msg.Files[0].Reader); string(data) != `{"ok":true}` {
+			t.Errorf("code %d: attachment = %q, want it rewound to the start", code, data)
+		}
+	}
+}
+
+func TestRespondOrFallbackIgnoresOtherErrors(t *testing.T) {
+	f := &fakeResponder{editErr: &discordgo.RESTError{Message: &discordgo.APIErrorMessage{Code: discordgo.ErrCodeMissingPermissions}}}
+	content := "Result"
+	respondOrFallback(f, testCommand("analyse"), &discordgo.WebhookEdit{Content: &content})
+	if len(f.sent) != 0 {
+		t.Errorf("sent %d channel messages for a non-expiry error, want 0", len(f.sent))
+	}
+}
+
+// dmCommand builds a DM interaction: no guild and no Member, just the invoking User
+func dmCommand(userID, name string, opts ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	i := testCommand(name, opts...)
+	i.GuildID, i.Member, i.User = "", nil, &discordgo.User{ID: userID}
+	return i
+}
+
+func TestGuildCommandGuardsWithoutMember(t *testing.T) {
+	t.Setenv("OWNER_ID", "owner")
+	t.Setenv("EXTRA_OWNER_IDS", "")
+	userInstall := testCommand("analyse")
+	userInstall.Member, userInstall.User = nil, &discordgo.User{ID: "u2"}
+	userInstall.AuthorizingIntegrationOwners = map[discordgo.ApplicationIntegrationType]string{discordgo.ApplicationIntegrationUserInstall: "u2"}
+	noUser := dmCommand("", "analyse")
+	noUser.User = nil
+
+	for _, tc := range []struct {
+		name           string
+		i              *discordgo.InteractionCreate
+		userID         string
+		allowed, admin bool
+	}{
+		{"owner in DM", dmCommand("owner", "analyse"), "owner", true, true},
+		{"other user in DM", dmCommand("u2", "analyse"), "u2", false, false},
+		{"user install in a guild", userInstall, "u2", false, false},
+		{"no user at all", noUser, "", false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := interactionUserID(tc.i); got != tc.userID {
+				t.Errorf("interactionUserID = %q, want %q", got, tc.userID)
+			}
+			if got := perms.IsAllowedForRestricted(tc.i); got != tc.allowed {
+				t.Errorf("IsAllowedForRestricted = %v, want %v", got, tc.allowed)
+			}
+			if got := CanManageGuild(tc.i); got != tc.admin {
+				t.Errorf("CanManageGuild = %v, want %v", got, tc.admin)
+			}
+			if IsGuildOwner(tc.i) || HasAdminContextPermission(tc.i) {
+				t.Error("an interaction without a Member has no guild ownership or permissions")
+			}
+		})
+	}
+}
+
+func TestCommandBodiesInDMs(t *testing.T) {
+	useTestSightengine(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"status":"success","type":{"ai_generated":0.97,"deepfake":0.01}}`)
+	})
+	for _, tc := range []struct {
+		name string
+		body func(s Responder, i *discordgo.InteractionCreate)
+		i    *discordgo.InteractionCreate
+	}{
+		{"analyse", analyseCommandHandlerBody, dmCommand("owner", "analyse", stringOpt("image_url", "https://example.com/dm.png"))},
+		{"ai", aiCommandHandlerBody, dmCommand("owner", "ai", stringOpt("image_url", "https://example.com/dm-ai.png"))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &fakeResponder{}
+			safeHandler(tc.body)(f, tc.i)
+			// A recovered panic would show up as a content-only edit
+			edit := f.lastEdit(t)
+			if edit.Embeds == nil || len(*edit.Embeds) == 0 {
+				t.Fatalf("no result embed; content %q", derefString(edit.Content))
+			}
+			if got := fieldValues((*edit.Embeds)[0]); !strings.Contains(got, "AI Generated: 97%") {
+				t.Errorf("DM result missing the AI score:\n%s", got)
+			}
+		})
+	}
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func TestCooldownOnlyStartsForValidInput(t *testing.T) {
+	useTestSightengine(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"status":"success"}`)
+	})
+	const guildID = "g-cooldown"
+	if err := settingsStore.Set(guildID, "command_cooldowns", "analyse=60,ai=60"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = settingsStore.ClearGuild(guildID)
+		commandCooldowns.mu.Lock()
+		delete(commandCooldowns.last, guildID+"|analyse")
+		delete(commandCooldowns.last, guildID+"|ai")
+		commandCooldowns.mu.Unlock()
+	})
+	inGuild := func(i *discordgo.InteractionCreate) *discordgo.InteractionCreate {
+		i.GuildID = guildID
+		return i
+	}
+
+	for _, tc := range []struct {
+		name string
+		body func(s Responder, i *discordgo.InteractionCreate)
+	}{
+		{"analyse", analyseCommandHandlerBody},
+		{"ai", aiCommandHandlerBody},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			run := func(imageURL string) *discordgo.InteractionResponse {
+				f := &fakeResponder{}
+				tc.body(f, inGuild(testCommand(tc.name, stringOpt("image_url", imageURL))))
+				if len(f.responses) == 0 {
+					t.Fatal("no response")
+				}
+				return f.responses[0]
+			}
+			// Rejected input answers with the validation error and leaves the cooldown unused
+			for range 2 {
+				if got := run("ftp://example.com/a.png"); !strings.HasPrefix(got.Data.Content, "Invalid `image_url`") {
+					t.Fatalf("invalid URL answered %q", got.Data.Content)
+				}
+			}
+			if got := run("https://example.com/cooldown.png"); got.Type != discordgo.InteractionResponseDeferredChannelMessageWithSource {
+				t.Fatalf("first valid call answered %+v, want a deferral", got.Data)
+			}
+			got := run("https://example.com/cooldown.png")
+			if got.Type == discordgo.InteractionResponseDeferredChannelMessageWithSource || !strings.Contains(got.Data.Content, "on cooldown") {
+				t.Errorf("second valid call answered %+v, want the cooldown message", got.Data)
+			}
+		})
+	}
+}
+
+func TestThresholdsSimulateRejectsNonFiniteValues(t *testing.T) {
+	for _, in := range []string{"NaN", "nan%", "Inf", "-inf", "1.5"} {
+		t.Run(in, func(t *testing.T) {
+			rt := &recordingTransport{}
+			sub := &discordgo.ApplicationCommandInteractionDataOption{Name: "simulate", Type: discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandInteractionDataOption{stringOpt("threshold", "Offensive"), stringOpt("value", in)}}
+			thresholdsSimulate(offlineSession(t, rt), testCommand("thresholds", sub), sub)
+			if len(rt.requests) != 1 || !strings.Contains(rt.requests[0], "must be a decimal between 0.00 and 1.00") {
+				t.Fatalf("simulate with %q sent %q", in, rt.requests)
+			}
+		})
+	}
+}
+
+// attachmentText reads every attached file of an edit into one string
+func attachmentText(t *testing.T, edit *discordgo.WebhookEdit) string {
+	t.Helper()
+	var b strings.Builder
+	for _, file := range edit.Files {
+		if _, err := io.Copy(&b, file.Reader); err != nil {
+			t.Fatalf("read %s: %v", file.Name, err)
+		}
+	}
+	return b.String()
+}
+
+func TestHiddenFlaggedURLStaysOutOfAttachments(t *testing.T) {
+	const flaggedURL = "https://cdn.discordapp.com/attachments/1/2/flagged.png"
+	const mediaURI = "https://media.example.com/flagged-copy.png"
+	useTestSightengine(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"status":"success","nudity":{"sexual_activity":0.99,"none":0.01},"offensive":{"nazi":0.01},"type":{"ai_generated":0.02},"media":{"id":"med_1","uri":"`+mediaURI+`"}}`)
+	})
+	if err := settingsStore.Set("g1", "echo_flagged_url", "off"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = settingsStore.ClearGuild("g1") })
+
+	t.Run("json and export", func(t *testing.T) {
+		f := &fakeResponder{}
+		analyseCommandHandlerBody(f, testCommand("analyse", stringOpt("image_url", flaggedURL), stringOpt("format", "json"), boolOpt("export", true)))
+		edit := f.lastEdit(t)
+		if len(edit.Files) != 2 {
+			t.Fatalf("files = %d, want the JSON and the report", len(edit.Files))
+		}
+		got := attachmentText(t, edit)
+		if strings.Contains(got, flaggedURL) || strings.Contains(got, mediaURI) {
+			t.Fatalf("attachments leak the flagged URL:\n%s", got)
+		}
+		if !strings.Contains(got, hiddenFlaggedURL) {
+			t.Errorf("report should show %q:\n%s", hiddenFlaggedURL, got)
+		}
+	})
+	t.Run("gallery json", func(t *testing.T) {
+		f := &fakeResponder{
+			messages:    map[string]*discordgo.Message{"c2/m1": {Attachments: []*discordgo.MessageAttachment{{URL: flaggedURL, Filename: "flagged.png", ContentType: "image/png"}}}},
+			channelPerm: map[string]int64{"c2": discordgo.PermissionViewChannel | discordgo.PermissionReadMessageHistory},
+		}
+		analyseCommandHandlerBody(f, testCommand("analyse", stringOpt("image_url", "https://discord.com/channels/g1/c2/m1"), stringOpt("format", "json")))
+		edit := f.lastEdit(t)
+		if len(edit.Files) != 1 {
+			t.Fatalf("files = %d, want the JSON (content %q)", len(edit.Files), derefString(edit.Content))
+		}
+		if got := attachmentText(t, edit); strings.Contains(got, flaggedURL) || strings.Contains(got, mediaURI) || !strings.Contains(got, `"allowed": false`) {
+			t.Fatalf("gallery JSON should list the flagged analysis without its URLs:\n%s", got)
+		}
+	})
+}
+
+func TestMonitorAddNeedsSendInTargetChannel(t *testing.T) {
+	rt := &recordingTransport{}
+	s := offlineSession(t, rt)
+	everyone := &discordgo.Role{ID: "g1", Permissions: discordgo.PermissionViewChannel | discordgo.PermissionSendMessages}
+	if err := s.State.GuildAdd(&discordgo.Guild{ID: "g1", OwnerID: "someone-else", Roles: []*discordgo.Role{everyone},
+		Channels: []*discordgo.Channel{
+			{ID: "announcements", GuildID: "g1", PermissionOverwrites: []*discordgo.PermissionOverwrite{
+				{ID: "g1", Type: discordgo.PermissionOverwriteTypeRole, Deny: discordgo.PermissionSendMessages}}},
+			{ID: "alerts", GuildID: "g1"},
+		}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.State.MemberAdd(&discordgo.Member{GuildID: "g1", User: &discordgo.User{ID: "u1"}}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		monitors, _ := monitorStore.List("g1")
+		for _, m := range monitors {
+			_, _ = monitorStore.Remove("g1", m.ID)
+		}
+	})
+	add := func(channelID string) string {
+		before := len(rt.sent())
+		sub := &discordgo.ApplicationCommandInteractionDataOption{Name: "add", Type: discordgo.ApplicationCommandOptionSubCommand,
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{stringOpt("url", "https://example.com/watched.png"),
+				{Name: "interval", Type: discordgo.ApplicationCommandOptionInteger, Value: float64(60)},
+				{Name: "channel", Type: discordgo.ApplicationCommandOptionChannel, Value: channelID}}}
+		i := testCommand("monitor", sub)
+		i.Member.Permissions = PermManageGuild // may manage monitors, but not post everywhere
+		handleMonitor(s, i)
+		return rt.sent()[before:]
+	}
+
+	if got := add("announcements"); !strings.Contains(got, "you can only send alerts to channels you can post in") {
+		t.Fatalf("monitor on a channel the user can't post in: %s", got)
+	}
+	if monitors, _ := monitorStore.List("g1"); len(monitors) != 0 {
+		t.Fatalf("refused monitor was saved: %+v", monitors)
+	}
+	if got := add("alerts"); !strings.Contains(got, "added") {
+		t.Fatalf("monitor on a writable channel: %s", got)
+	}
+	if monitors, _ := monitorStore.List("g1"); len(monitors) != 1 || monitors[0].ChannelID != "alerts" {
+		t.Fatalf("monitors = %+v, want one for alerts", monitors)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// analysisHistoryMemLimit caps the records kept per guild when there is no DB. With a DB,
+// records older than historyRetention are pruned (see retention.go)
+const analysisHistoryMemLimit = 500
+
+// AnalysisRecord is a stored standard analysis: the scores and verdict for one image
+type AnalysisRecord struct {
+	ImageURL         string
+	NudityExplicit   float64
+	NuditySuggestive float64
+	Offensive        float64
+	AIGenerated      float64
+	Deepfake         float64
+	Allowed          bool
+	Created          time.Time
+}
+
+// AllowedUnder re-applies thresholds and min_reasons to the stored scores
+func (r AnalysisRecord) AllowedUnder(ns, ne, off, ai, df float64, minReasons int) bool {
+	reasons := 0
+	for _, c := range [][2]float64{
+		{r.NudityExplicit, ne}, {r.NuditySuggestive, ns}, {r.Offensive, off}, {r.AIGenerated, ai}, {r.Deepfake, df},
+	} {
+		if c[0] >= c[1] {
+			reasons++
+		}
+	}
+	return reasons < max(minReasons, 1)
+}
+
+// AnalysisHistoryStore keeps the standard analyses of guilds that enabled the analysis_history
+// setting, in the analysis_history table when a DB is configured and in memory otherwise
+type AnalysisHistoryStore struct {
+	mu  sync.Mutex
+	ps  *PermStore
+	mem map[string][]AnalysisRecord // guildKey -> records, oldest first
+}
+
+var analysisHistory = &AnalysisHistoryStore{mem: make(map[string][]AnalysisRecord)}
+
+// Init creates the analysis_history table if a DB is available
+func (hs *AnalysisHistoryStore) Init(ps *PermStore) error {
+	hs.mu.Lock()
+	hs.ps = ps
+	hs.mu.Unlock()
+	if ps == nil || ps.db == nil {
+		return nil
+	}
+	var ddl, idx string
+	switch ps.dialect {
+	case DialectPostgres:
+		ddl = `CREATE TABLE IF NOT EXISTS analysis_history (
+			id                BIGSERIAL PRIMARY KEY,
+			guild_id          TEXT NOT NULL,
+			image_url         TEXT NOT NULL,
+			nudity_explicit   DOUBLE PRECISION NOT NULL,
+			nudity_suggestive DOUBLE PRECISION NOT NULL,
+			offensive         DOUBLE PRECISION NOT NULL,
+			ai_generated      DOUBLE PRECISION NOT NULL,
+			deepfake          DOUBLE PRECISION NOT NULL,
+			allowed           BOOLEAN NOT NULL,
+			created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`
+		idx = `CREATE INDEX IF NOT EXISTS analysis_history_guild_created ON analysis_history (guild_id, created_at)`
+	case DialectMySQL:
+		ddl = `CREATE TABLE IF NOT EXISTS analysis_history (
+			id                BIGINT AUTO_INCREMENT PRIMARY KEY,
+			guild_id          VARCHAR(64) NOT NULL,
+			image_url         TEXT NOT NULL,
+			nudity_explicit   DOUBLE NOT NULL,
+			nudity_suggestive DOUBLE NOT NULL,
+			offensive         DOUBLE NOT NULL,
+			ai_generated      DOUBLE NOT NULL,
+			deepfake          DOUBLE NOT NULL,
+			allowed           BOOLEAN NOT NULL,
+			created_at        TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			INDEX analysis_history_guild_created (guild_id, created_at)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+	default:
+		return fmt.Errorf("unsupported dialect: %s", ps.dialect)
+	}
+	if _, err := ps.db.Exec(ddl); err != nil {
+		return fmt.Errorf("create analysis_history table: %w", err)
+	}
+	if idx != "" {
+		if _, err := ps.db.Exec(idx); err != nil {
+			return fmt.Errorf("create analysis_history index: %w", err)
+		}
+	}
+	return nil
+}
+
+// Record stores a standard analysis when the guild has analysis_history enabled; errors are logged
+func (hs *AnalysisHistoryStore) Record(guildID string, a *Analysis) {
+	if guildID == "" || a == nil {
+		return
+	}
+	if gs, err := settingsStore.Get(guildID); err != nil || !gs.AnalysisHistory {
+		if err != nil {
+			log.Println("analysis history skipped, guild settings read error:", err)
+		}
+		return
+	}
+	key := thresholdsGuildKey(guildID)
+	rec := AnalysisRecord{
+		ImageURL:         a.ImageURL,
+		NudityExplicit:   a.Scores.NudityExplicit,
+		NuditySuggestive: a.Scores.NuditySuggestive,
+		Offensive:        a.Scores.Offensive,
+		AIGenerated:      a.Scores.AIGenerated,
+		Deepfake:         a.Scores.Deepfake,
+		Allowed:          a.Allowed,
+		Created:          time.Now().UTC(),
+	}
+	hs.mu.Lock()
+	ps := hs.ps
+	if ps == nil || ps.db == nil {
+		recs := append(hs.mem[key], rec)
+		if len(recs) > analysisHistoryMemLimit {
+			recs = recs[len(recs)-analysisHistoryMemLimit:]
+		}
+		hs.mem[key] = recs
+		hs.mu.Unlock()
+		return
+	}
+	hs.mu.Unlock()
+
+	var ins, prune string
+	switch ps.dialect {
+	case DialectPostgres:
+		ins = `INSERT INTO analysis_history (guild_id, image_url, nudity_explicit, nudity_suggestive, offensive, ai_generated, deepfake, allowed)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+		prune = `DELETE FROM analysis_history WHERE guild_id = $1 AND created_at < $2`
+	case DialectMySQL:
+		ins = `INSERT INTO analysis_history (guild_id, image_url, nudity_explicit, nudity_suggestive, offensive, ai_generated, deepfake, allowed)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+		prune = `DELETE FROM analysis_history WHERE guild_id = ? AND created_at < ?`
+	}
+	if _, err := ps.db.Exec(ins, key, rec.ImageURL, rec.NudityExplicit, rec.NuditySuggestive, rec.Offensive, rec.AIGenerated, rec.Deepfake, rec.Allowed); err != nil {
+		log.Println("analysis history insert error:", err)
+		return
+	}
+	if _, err := ps.db.Exec(prune, key, time.Now().UTC().Add(-historyRetention)); err != nil {
+		log.Println("analysis history prune error:", err)
+	}
+}
+
+// Recent returns up to limit stored analyses for a guild, newest first
+func (hs *AnalysisHistoryStore) Recent(guildID string, limit int) ([]AnalysisRecord, error) {
+	key := thresholdsGuildKey(guildID)
+	hs.mu.Lock()
+	ps := hs.ps
+	if ps == nil || ps.db == nil {
+		recs := hs.mem[key]
+		out := make([]AnalysisRecord, 0, min(limit, len(recs)))
+		for n := len(recs) - 1; n >= 0 && len(out) < limit; n-- {
+			out = append(out, recs[n])
+		}
+		hs.mu.Unlock()
+		return out, nil
+	}
+	hs.mu.Unlock()
+
+	var stmt string
+	switch ps.dialect {
+	case DialectPostgres:
+		stmt = `SELECT image_url, nudity_explicit, nudity_suggestive, offensive, ai_generated, deepfake, allowed, created_at
+			FROM analysis_history WHERE guild_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2`
+	case DialectMySQL:
+		stmt = `SELECT image_url, nudity_explicit, nudity_suggestive, offensive, ai_generated, deepfake, allowed, created_at
+			FROM analysis_history WHERE guild_id = ? ORDER BY created_at DESC, id DESC LIMIT ?`
+	}
+	rows, err := ps.db.Query(stmt, key, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var out []AnalysisRecord
+	for rows.Next() {
+		var r AnalysisRecord
+		if err := rows.Scan(&r.ImageURL, &r.NudityExplicit, &r.NuditySuggestive, &r.Offensive, &r.AIGenerated, &r.Deepfake, &r.Allowed, &r.Created); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// ClearGuild deletes all stored analyses for a guild
+func (hs *AnalysisHistoryStore) ClearGuild(guildID string) error {
+	key := thresholdsGuildKey(guildID)
+	hs.mu.Lock()
+	ps := hs.ps
+	if ps == nil || ps.db == nil {
+		delete(hs.mem, key)
+		hs.mu.Unlock()
+		return nil
+	}
+	hs.mu.Unlock()
+	stmt := `DELETE FROM analysis_history WHERE guild_id = ?`
+	if ps.dialect == DialectPostgres {
+		stmt = `DELETE FROM analysis_history WHERE guild_id = $1`
+	}
+	_, err := ps.db.Exec(stmt, key)
+	return err
+}
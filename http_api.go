@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// maxAPIRequestBytes caps JSON request bodies accepted by the HTTP API
+const maxAPIRequestBytes = 64 << 10
+
+// apiAnalyseRequest is the body accepted by POST /api/analyse
+type apiAnalyseRequest struct {
+	GuildID  string `json:"guild_id"`
+	ImageURL string `json:"image_url"`
+}
+
+// apiError is the JSON body returned for failed API calls
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// registerAPIRoutes mounts the JSON API on mux when API_TOKEN is set; without a token
+// the API is not exposed at all
+func registerAPIRoutes(mux *http.ServeMux) {
+	token := strings.TrimSpace(os.Getenv("API_TOKEN"))
+	if token == "" {
+		return
+	}
+	var analyse http.Handler = http.HandlerFunc(handleAPIAnalyse)
+	if secret := strings.TrimSpace(os.Getenv("API_HMAC_SECRET")); secret != "" {
+		analyse = requireSignature(secret, analyse)
+		log.Println("HTTP API: request signatures required (API_HMAC_SECRET)")
+	}
+	mux.Handle("POST /api/analyse", requireBearer(token, analyse))
+	log.Println("HTTP API enabled: POST /api/analyse")
+}
+
+// requireSignature rejects requests whose X-Signature header isn't the hex HMAC-SHA256 of the
+// raw body under secret ("sha256=" prefix optional, as sent by most webhook senders). The body
+// is buffered and handed on unchanged
+func requireSignature(secret string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAPIRequestBytes))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid request body: " + err.Error()})
+			return
+		}
+		sig := strings.TrimPrefix(strings.TrimSpace(r.Header.Get("X-Signature")), "sha256=")
+		got, err := hex.DecodeString(sig)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if err != nil || !hmac.Equal(got, mac.Sum(nil)) {
+			writeJSON(w, http.StatusUnauthorized, apiError{Error: "missing or invalid request signature"})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireBearer rejects requests without an "Authorization: Bearer <token>" header matching token
+func requireBearer(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			writeJSON(w, http.StatusUnauthorized, apiError{Error: "missing or invalid bearer token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleAPIAnalyse runs the standard analysis for a guild and returns the Analysis as JSON.
+// 400 = invalid request, 429 = guild over MONTHLY_QUOTA, 422 = image rejected upstream,
+// 502 = upstream misconfigured, 503 = upstream temporarily unavailable
+func handleAPIAnalyse(w http.ResponseWriter, r *http.Request) {
+	var req apiAnalyseRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIRequestBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid JSON body: " + err.Error()})
+		return
+	}
+	req.GuildID = strings.TrimSpace(req.GuildID)
+	if req.GuildID != "" && !snowflakeRe.MatchString(req.GuildID) {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "guild_id must be a Discord ID"})
+		return
+	}
+	imageURL, err := normalizeImageURL(req.ImageURL)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid image_url: " + err.Error()})
+		return
+	}
+	if usageStore.OverQuota(req.GuildID) {
+		writeJSON(w, http.StatusTooManyRequests, apiError{Error: "monthly analysis quota exceeded for this guild"})
+		return
+	}
+
+	// Automated callers tend to submit reposts of the same image; reuse recent responses
+	ctx, cancel := context.WithTimeout(withRepostDedupe(withUsageGuild(r.Context(), req.GuildID)), analysisCallTimeout)
+	defer cancel()
+	a, err := AnalyseImageURL(ctx, req.GuildID, imageURL)
+	if err != nil {
+		log.Printf("api analyse failed: %v", err)
+		msg, _ := classifySightengineError(err)
+		writeJSON(w, apiErrorStatus(err), apiError{Error: msg})
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+// apiErrorStatus maps an analysis error to an HTTP status code
+func apiErrorStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	switch {
+	case errors.Is(err, ErrRateLimited), errors.Is(err, ErrUpstreamUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrMissingCredentials):
+		return http.StatusBadGateway
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("api response encode error:", err)
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"syscall"
 	"time"
 )
 
@@ -27,8 +30,46 @@ var sharedHTTPClient = &http.Client{
 	Transport: sharedTransport,
 }
 
+// errPrivateAddress is returned when a user-supplied URL resolves to a non-public address
+var errPrivateAddress = errors.New("refusing to connect to a local or private network address")
+
+// userContentTransport fetches URLs supplied by users. Its dialer refuses non-public
+// addresses, so hostnames that resolve (or redirect) to the bot's own network can't be
+// reached. It has no proxy: a proxy would hide the real destination from the check
+var userContentTransport = &http.Transport{
+	DialContext: (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%w: %s", errPrivateAddress, host)
+			}
+			return nil
+		}}).DialContext,
+	ForceAttemptHTTP2:   true,
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 10,
+	IdleConnTimeout:     90 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+}
+
+// userContentHTTPClient downloads user-supplied images (see downloadImage and imageMeta)
+var userContentHTTPClient = &http.Client{
+	Timeout:   30 * time.Second,
+	Transport: userContentTransport,
+}
+
 // newHTTPClientWithTimeout returns a client that reuses the shared transport but
 // with a custom timeout, useful for APIs that need different request ceilings
 func newHTTPClientWithTimeout(d time.Duration) *http.Client {
 	return &http.Client{Timeout: d, Transport: sharedTransport}
 }
+
+// isPublicIP reports whether ip is a routable unicast address, i.e. not loopback, private,
+// link-local (including cloud metadata at 169.254.169.254), unspecified or multicast
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
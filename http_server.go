@@ -3,12 +3,33 @@ package main
 import (
 	"errors"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTP server timeout defaults (READ_TIMEOUT / WRITE_TIMEOUT, in seconds). The write timeout
+// leaves room for /api/analyse, which waits on Sightengine
+const (
+	defaultHTTPReadTimeout  = 15 * time.Second
+	defaultHTTPWriteTimeout = 90 * time.Second
+	httpReadHeaderTimeout   = 10 * time.Second
+	httpIdleTimeout         = 120 * time.Second
 )
 
 var httpServer *http.Server
 
+// envSeconds reads a whole number of seconds from the named env var, falling back to def
+func envSeconds(name string, def time.Duration) time.Duration {
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv(name))); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	return def
+}
+
 // startHTTPServer starts a minimal HTTP server
 func startHTTPServer() {
 	// Resolve port (default 8080 for Cloud Run)
@@ -28,15 +49,23 @@ func startHTTPServer() {
 		_, _ = w.Write([]byte("ok"))
 	})
 
-	// Server instance
+	// JSON API for dashboards (only when API_TOKEN is set)
+	registerAPIRoutes(mux)
+
+	// Server instance; HTTP_BIND restricts the interface (e.g. 127.0.0.1 behind a proxy)
+	addr := net.JoinHostPort(strings.TrimSpace(os.Getenv("HTTP_BIND")), port)
 	httpServer = &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		ReadTimeout:       envSeconds("READ_TIMEOUT", defaultHTTPReadTimeout),
+		WriteTimeout:      envSeconds("WRITE_TIMEOUT", defaultHTTPWriteTimeout),
+		IdleTimeout:       httpIdleTimeout,
 	}
 
 	// Run server in background to avoid blocking the bot
 	go func() {
-		log.Printf("HTTP server listening on :%s", port)
+		log.Printf("HTTP server listening on %s", addr)
 		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatal(err)
 		}
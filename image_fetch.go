@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Minimum image size guard: images smaller than MIN_IMAGE_DIMENSION pixels on either side
+// (or MIN_IMAGE_BYTES, when set) are skipped for guilds with the skip_small_images setting
+const (
+	defaultMinImageDimension = 64
+	imageMetaProbeBytes      = 64 << 10 // enough for the header of PNG, JPEG and GIF files
+)
+
+// defaultMaxUploadBytes caps images the bot downloads for upload (MAX_UPLOAD_BYTES overrides it)
+const defaultMaxUploadBytes = 10 << 20
+
+// errImageTooLarge is returned when an image is bigger than maxUploadBytes; the download stops
+// at the limit instead of buffering the whole file
+var errImageTooLarge = errors.New("image too large")
+
+// maxUploadBytes returns MAX_UPLOAD_BYTES (default 10 MB)
+func maxUploadBytes() int64 {
+	if n, err := strconv.ParseInt(strings.TrimSpace(os.Getenv("MAX_UPLOAD_BYTES")), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return defaultMaxUploadBytes
+}
+
+// errUploadHostNotAllowed is returned when the bot is asked to download an image from a host
+// that isn't listed in UPLOAD_IMAGE_HOSTS
+var errUploadHostNotAllowed = errors.New("host is not in UPLOAD_IMAGE_HOSTS")
+
+// errNotAnImage is returned when a downloaded file isn't an image
+var errNotAnImage = errors.New("not an image")
+
+// requiresUpload reports whether the URL's host is listed in UPLOAD_IMAGE_HOSTS (e.g. an
+// auth-gated CDN Sightengine can't fetch). Such images are downloaded by the bot and uploaded
+// as bytes instead. Only explicitly listed hosts qualify, so user links can't make the bot
+// fetch arbitrary addresses
+func requiresUpload(imageURL string) bool {
+	u, err := url.Parse(strings.TrimSpace(imageURL))
+	if err != nil {
+		return false
+	}
+	return uploadHostAllowed(u.Hostname())
+}
+
+// uploadHostAllowed reports whether host, or a domain it belongs to, is listed in
+// UPLOAD_IMAGE_HOSTS
+func uploadHostAllowed(host string) bool {
+	return hostInList(host, splitCSV(os.Getenv("UPLOAD_IMAGE_HOSTS")))
+}
+
+// downloadImage fetches an image for upload and returns its bytes along with a filename
+// suitable for a multipart upload. Only hosts allowed by uploadHostAllowed are fetched,
+// through userContentHTTPClient so they can't resolve to private addresses, and the response
+// must be an image. Images over maxUploadBytes fail with errImageTooLarge
+func downloadImage(ctx context.Context, imageURL string) ([]byte, string, error) {
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("download image: %w", err)
+	}
+	if !uploadHostAllowed(u.Hostname()) {
+		return nil, "", fmt.Errorf("download image: %s: %w", u.Hostname(), errUploadHostNotAllowed)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("download image: %w", err)
+	}
+	resp, err := userContentHTTPClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("download image: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("download image: unexpected status %d", resp.StatusCode)
+	}
+	limit := maxUploadBytes()
+	if resp.ContentLength > limit {
+		return nil, "", fmt.Errorf("download image: %w (%d bytes, limit %d)", errImageTooLarge, resp.ContentLength, limit)
+	}
+	// Read one byte past the limit to tell an exactly-limit-sized image from a bigger one
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("download image: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, "", fmt.Errorf("download image: %w (over %d bytes)", errImageTooLarge, limit)
+	}
+	if ct := resp.Header.Get("Content-Type"); !isImageContent(ct, data) {
+		return nil, "", fmt.Errorf("download image: %w (content type %q)", errNotAnImage, ct)
+	}
+	filename := "image"
+	if base := path.Base(u.Path); base != "" && base != "/" && base != "." {
+		filename = base
+	}
+	return data, filename, nil
+}
+
+// isImageContent reports whether a download is an image: its declared Content-Type is image/*,
+// or, when the server sends none or a generic binary type, its sniffed type is
+func isImageContent(contentType string, data []byte) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "", "application/octet-stream", "binary/octet-stream":
+		return strings.HasPrefix(http.DetectContentType(data), "image/")
+	}
+	return strings.HasPrefix(mediaType, "image/")
+}
+
+// ImageMeta describes an image as far as it can be told from its first bytes; zero fields are unknown
+type ImageMeta struct {
+	Width, Height int
+	Bytes         int64
+}
+
+// imageMeta fetches just the start of an image with a range request and reads its size from the
+// response headers and its dimensions from the file header (PNG, JPEG and GIF)
+func imageMeta(ctx context.Context, imageURL string) (ImageMeta, error) {
+	var meta ImageMeta
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return meta, fmt.Errorf("image meta: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", imageMetaProbeBytes-1))
+	resp, err := userContentHTTPClient.Do(req)
+	if err != nil {
+		return meta, fmt.Errorf("image meta: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// Content-Range: bytes 0-65535/123456
+		if _, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok {
+			if n, err := strconv.ParseInt(total, 10, 64); err == nil {
+				meta.Bytes = n
+			}
+		}
+	case http.StatusOK:
+		meta.Bytes = max(resp.ContentLength, 0)
+	default:
+		return meta, fmt.Errorf("image meta: unexpected status %d", resp.StatusCode)
+	}
+	head, err := io.ReadAll(io.LimitReader(resp.Body, imageMetaProbeBytes))
+	if err != nil {
+		return meta, fmt.Errorf("image meta: %w", err)
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(head)); err == nil {
+		meta.Width, meta.Height = cfg.Width, cfg.Height
+	}
+	return meta, nil
+}
+
+// minImageDimension returns MIN_IMAGE_DIMENSION in pixels (default 64)
+func minImageDimension() int {
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("MIN_IMAGE_DIMENSION"))); err == nil && n >= 0 {
+		return n
+	}
+	return defaultMinImageDimension
+}
+
+// minImageBytes returns MIN_IMAGE_BYTES; 0 (the default) disables the byte check
+func minImageBytes() int64 {
+	if n, err := strconv.ParseInt(strings.TrimSpace(os.Getenv("MIN_IMAGE_BYTES")), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return 0
+}
+
+// smallImageNote returns a user-facing note when the guild skips small images and imageURL is
+// below the configured minimum. Unknown sizes and probe failures let the image through
+func smallImageNote(ctx context.Context, guildID, imageURL string) string {
+	if guildID == "" {
+		return ""
+	}
+	if gs, err := settingsStore.Get(guildID); err != nil || !gs.SkipSmallImages {
+		return ""
+	}
+	meta, err := imageMeta(ctx, imageURL)
+	if err != nil {
+		return ""
+	}
+	if minDim := minImageDimension(); meta.Width > 0 && meta.Height > 0 && (meta.Width < minDim || meta.Height < minDim) {
+		return fmt.Sprintf("Skipped: the image is %d×%d px, below this server's %d px minimum. Tiny images such as emoji and thumbnails give unreliable scores.",
+			meta.Width, meta.Height, minDim)
+	}
+	if minBytes := minImageBytes(); minBytes > 0 && meta.Bytes > 0 && meta.Bytes < minBytes {
+		return fmt.Sprintf("Skipped: the image is only %d bytes, below the %d byte minimum. Tiny images such as emoji and thumbnails give unreliable scores.",
+			meta.Bytes, minBytes)
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequiresUploadOnlyListedHosts(t *testing.T) {
+	t.Setenv("UPLOAD_IMAGE_HOSTS", "private-cdn.example.com")
+	for raw, want := range map[string]bool{
+		"https://private-cdn.example.com/a.png":     true,
+		"https://img.private-cdn.example.com/a.png": true,
+		"https://example.com/a.png":                 false,
+		"http://127.0.0.1/a.png":                    false,
+		"http://169.254.169.254/a.png":              false,
+		"http://localhost/a.png":                    false,
+	} {
+		if got := requiresUpload(raw); got != want {
+			t.Errorf("requiresUpload(%q) = %t, want %t", raw, got, want)
+		}
+	}
+}
+
+func TestDownloadImageRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("private address was fetched")
+	}))
+	defer srv.Close()
+
+	t.Setenv("UPLOAD_IMAGE_HOSTS", "")
+	if _, _, err := downloadImage(context.Background(), srv.URL+"/a.png"); !errors.Is(err, errUploadHostNotAllowed) {
+		t.Errorf("unlisted host: err = %v, want errUploadHostNotAllowed", err)
+	}
+	// Even a listed host is refused when it resolves to a loopback address
+	t.Setenv("UPLOAD_IMAGE_HOSTS", "127.0.0.1")
+	if _, _, err := downloadImage(context.Background(), srv.URL+"/a.png"); !errors.Is(err, errPrivateAddress) {
+		t.Errorf("loopback host: err = %v, want errPrivateAddress", err)
+	}
+}
+
+func TestIsImageContent(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	for _, tc := range []struct {
+		contentType string
+		data        []byte
+		want        bool
+	}{
+		{"image/png", png, true},
+		{"image/jpeg; charset=binary", []byte("x"), true},
+		{"", png, true},
+		{"application/octet-stream", png, true},
+		{"application/octet-stream", []byte("{\"secret\":1}"), false},
+		{"text/html", png, false},
+		{"application/json", []byte("{}"), false},
+	} {
+		if got := isImageContent(tc.contentType, tc.data); got != tc.want {
+			t.Errorf("isImageContent(%q, %q) = %t, want %t", tc.contentType, tc.data, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// trackingParams are query parameters stripped from image URLs before analysis.
+// Discord CDN signature params (ex, is, hm) are deliberately kept: links break without them
+var trackingParams = map[string]struct{}{
+	"fbclid": {}, "gclid": {}, "dclid": {}, "msclkid": {}, "igshid": {},
+	"mc_cid": {}, "mc_eid": {}, "ref": {}, "ref_src": {}, "si": {},
+}
+
+// nonImageExtensions are path extensions that clearly aren't images
+var nonImageExtensions = map[string]struct{}{
+	".html": {}, ".htm": {}, ".php": {}, ".asp": {}, ".aspx": {}, ".jsp": {},
+	".js": {}, ".css": {}, ".json": {}, ".xml": {}, ".txt": {}, ".pdf": {},
+	".zip": {}, ".rar": {}, ".7z": {}, ".exe": {}, ".msi": {}, ".apk": {},
+}
+
+// Media kinds reported by mediaKind
+const (
+	mediaImage     = "image"
+	mediaAnimation = "animation"
+	mediaVideo     = "video"
+	mediaUnknown   = "unknown"
+)
+
+// mediaExtensions maps path extensions to media kinds. Imgur's .gifv pages are MP4 videos
+var mediaExtensions = map[string]string{
+	".png": mediaImage, ".jpg": mediaImage, ".jpeg": mediaImage, ".webp": mediaImage, ".bmp": mediaImage, ".avif": mediaImage,
+	".gif": mediaAnimation, ".apng": mediaAnimation,
+	".mp4": mediaVideo, ".webm": mediaVideo, ".mov": mediaVideo, ".mkv": mediaVideo, ".avi": mediaVideo, ".m4v": mediaVideo, ".gifv": mediaVideo,
+}
+
+// mediaKind guesses what a URL or file name points at from its extension: mediaImage,
+// mediaAnimation, mediaVideo, or mediaUnknown when there is no recognised extension
+func mediaKind(raw string) string {
+	p := raw
+	if u, err := url.Parse(raw); err == nil {
+		p = u.Path
+	}
+	if kind, ok := mediaExtensions[strings.ToLower(path.Ext(p))]; ok {
+		return kind
+	}
+	return mediaUnknown
+}
+
+// hasImageExtension reports whether name ends in a common image extension (animations included)
+func hasImageExtension(name string) bool {
+	kind := mediaKind(name)
+	return kind == mediaImage || kind == mediaAnimation
+}
+
+// stillImageOnlyMessage explains why a command that needs a still image can't take a link of the
+// given kind; empty for still images and links whose kind is unknown
+func stillImageOnlyMessage(kind string) string {
+	switch kind {
+	case mediaAnimation:
+		return "This command only supports still images, and that link looks like an animation (GIF). Try a screenshot of a single frame."
+	case mediaVideo:
+		return "This command only supports still images, and that link looks like a video. Try a screenshot of a single frame."
+	}
+	return ""
+}
+
+// normalizeImageURL trims and validates a user-supplied image URL:
+// - plain domains get an https:// scheme
+// - only http/https are accepted and a host is required
+// - hosts outside ALLOWED_IMAGE_HOSTS are rejected (when the allowlist is set)
+// - loopback, private and link-local targets are rejected (see isPrivateHost)
+// - obvious non-image endpoints (web pages, archives, scripts) are rejected
+// - known tracking query params (utm_*, fbclid, ...) are removed
+func normalizeImageURL(raw string) (string, error) {
+	s := strings.TrimSpace(raw)
+	s = strings.Trim(s, "<>") // Discord's "suppress embed" syntax
+	if s == "" {
+		return "", errors.New("the image URL is empty")
+	}
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", errors.New("the image URL is not a valid URL")
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+	default:
+		return "", errors.New("the image URL must start with http:// or https://")
+	}
+	if u.Hostname() == "" {
+		return "", errors.New("the image URL has no host")
+	}
+	if isPrivateHost(u.Hostname()) {
+		return "", errors.New("the image URL points to a local or private network address")
+	}
+	if !imageHostAllowed(u.Hostname()) {
+		return "", fmt.Errorf("images from %s are not allowed on this bot; approved hosts: %s",
+			u.Hostname(), strings.Join(splitCSV(os.Getenv("ALLOWED_IMAGE_HOSTS")), ", "))
+	}
+	if _, bad := nonImageExtensions[strings.ToLower(path.Ext(u.Path))]; bad {
+		return "", errors.New("the URL points to a web page or file, not an image; use a direct image link")
+	}
+
+	if u.RawQuery != "" {
+		q := u.Query()
+		for k := range q {
+			lk := strings.ToLower(k)
+			if _, ok := trackingParams[lk]; ok || strings.HasPrefix(lk, "utm_") {
+				q.Del(k)
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+// isPrivateHost reports whether host names this machine or a private network: localhost and
+// *.localhost, *.local and *.internal names, or a literal IP that isn't publicly routable.
+// Hostnames that resolve to such addresses are caught when dialling (see userContentHTTPClient)
+func isPrivateHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".local") || strings.HasSuffix(host, ".internal") {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return !isPublicIP(ip)
+	}
+	return false
+}
+
+// imageHostAllowed reports whether host is permitted by ALLOWED_IMAGE_HOSTS.
+// An empty allowlist allows every host
+func imageHostAllowed(host string) bool {
+	allowed := splitCSV(os.Getenv("ALLOWED_IMAGE_HOSTS"))
+	return len(allowed) == 0 || hostInList(host, allowed)
+}
+
+// hostInList reports whether host equals one of the domains or is a subdomain of one.
+// Matching is case-insensitive and on label boundaries: "cdn.example.com" matches
+// "example.com", but "badexample.com" does not
+func hostInList(host string, domains []string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return false
+	}
+	for _, d := range domains {
+		d = strings.TrimPrefix(strings.TrimSuffix(strings.ToLower(d), "."), "*.")
+		if d == "" {
+			continue
+		}
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
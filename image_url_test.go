@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeImageURLRejectsPrivateTargets(t *testing.T) {
+	t.Setenv("ALLOWED_IMAGE_HOSTS", "")
+	for _, raw := range []string{
+		"http://127.0.0.1:8080/a.png",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.5/a.png",
+		"http://192.168.1.1/a.png",
+		"http://[::1]/a.png",
+		"http://0.0.0.0/a.png",
+		"localhost/a.png",
+		"http://printer.local/a.png",
+		"http://metadata.google.internal/a.png",
+	} {
+		if _, err := normalizeImageURL(raw); err == nil || !strings.Contains(err.Error(), "private") {
+			t.Errorf("normalizeImageURL(%q) error = %v, want private address rejection", raw, err)
+		}
+	}
+	if _, err := normalizeImageURL("https://93.184.216.34/a.png"); err != nil {
+		t.Errorf("public IP rejected: %v", err)
+	}
+}
+
+func TestHostInList(t *testing.T) {
+	domains := []string{"example.com", "*.media.test", "Upper.Example.org."}
+	for _, tc := range []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"cdn.example.com", true},
+		{"a.b.example.com", true},
+		{"badexample.com", false},
+		{"example.com.evil.net", false},
+		{"example.co", false},
+		{"example.com.", true},
+		{"cdn.example.com.", true},
+		{"CDN.Example.COM", true},
+		{"img.media.test", true},
+		{"media.test", true},
+		{"notmedia.test", false},
+		{"upper.example.org", true},
+		{"x.UPPER.example.ORG.", true},
+		{"", false},
+		{".", false},
+	} {
+		if got := hostInList(tc.host, domains); got != tc.want {
+			t.Errorf("hostInList(%q) = %v, want %v", tc.host, got, tc.want)
+		}
+	}
+	if hostInList("example.com", []string{"", "."}) {
+		t.Error("empty list entries must not match")
+	}
+}
@@ -16,7 +16,15 @@ const (
 	FooterText = "Bot created by wafflerdot"
 )
 
+// startTime records when the process started (used for uptime reporting)
+var startTime time.Time
+
+// appCtx is cancelled on shutdown so in-flight API calls and batch work stop promptly
+var appCtx, appCancel = context.WithCancel(context.Background())
+
 func main() {
+	startTime = time.Now()
+
 	// Load environment variables from .env
 	_ = godotenv.Load()
 
@@ -33,6 +41,12 @@ func main() {
 			log.Fatalf("permissions DB config failed: %v", err)
 		}
 		log.Printf("permissions: DB configured (dialect=%s)", dialect)
+		// Deferred first so it runs last, after the session and HTTP server are down
+		defer func() {
+			if err := perms.Close(); err != nil {
+				log.Println("failed to close permissions DB:", err)
+			}
+		}()
 	} else {
 		permsFile := os.Getenv("PERMS_FILE")
 		if permsFile == "" {
@@ -44,13 +58,58 @@ func main() {
 		} else {
 			log.Println("permissions loaded from:", permsFile)
 		}
+		// Permission changes are written with a short delay; write any pending ones on exit
+		defer func() {
+			if err := perms.Flush(); err != nil {
+				log.Println("failed to save permissions file:", err)
+			}
+		}()
 	}
 
+	// House default thresholds from DEFAULT_* env vars (before persisted globals are loaded)
+	loadDefaultThresholds()
+
 	// Initialise thresholds store and load values from DB if present
 	if err := thresholdsStore.Init(perms); err != nil {
 		log.Println("thresholds init error:", err)
 	}
 
+	// Per-guild settings (DB-backed when configured, in-memory otherwise)
+	if err := settingsStore.Init(perms); err != nil {
+		log.Println("guild settings init error:", err)
+	}
+
+	// Monthly Sightengine usage counters (DB-backed when configured, in-memory otherwise)
+	if err := usageStore.Init(perms); err != nil {
+		log.Println("usage counters init error:", err)
+	}
+
+	// Opt-in per-guild analysis history for /thresholds simulate (DB-backed when configured)
+	if err := analysisHistory.Init(perms); err != nil {
+		log.Println("analysis history init error:", err)
+	}
+
+	// Scheduled re-analysis of monitored URLs (DB-backed when configured, in-memory otherwise)
+	if err := monitorStore.Init(perms); err != nil {
+		log.Println("monitors init error:", err)
+	}
+
+	// Delete history older than HISTORY_RETENTION_DAYS (default 90) in the background
+	configureHistoryRetention()
+	startHistorySweeper(perms)
+
+	// Sightengine request timeout from SIGHTENGINE_TIMEOUT (default 30s)
+	configureSightengineTimeout()
+
+	// Validate Sightengine credentials in the background; a bad secret is logged, not fatal
+	go func() {
+		if err := sightengineCheckCredentials(); err != nil {
+			log.Printf("WARNING: Sightengine credential check failed, analysis commands will not work: %v", err)
+			return
+		}
+		log.Println("sightengine: credentials verified")
+	}()
+
 	// ----------------------------------------
 	// Start lightweight HTTP health server
 	// ----------------------------------------
@@ -80,14 +139,23 @@ func main() {
 	// Register gateway and command handlers
 	registerHandlers(sess)
 
+	// Error-rate alerts (no-op unless ALERT_CHANNEL_ID is set)
+	configureAlerts(sess)
+
 	// Open the WebSocket connection to Discord before creating commands
 	if err := sess.Open(); err != nil {
 		log.Fatal(err)
 	}
 	log.Println("Bot is now online!")
 
-	// Create slash commands (global or guild scoped depending on GUILD_ID)
-	registerCommands(sess)
+	// Re-analyse monitored URLs in the background; alerts need the open session
+	startMonitorScheduler(sess)
+
+	// Create slash commands (global or guild scoped depending on GUILD_ID).
+	// Failures are logged; the bot stays online with whatever did register
+	if err := registerCommands(sess); err != nil {
+		log.Printf("some commands failed to register: %v", err)
+	}
 
 	// ----------------------------------------
 	// Block until termination, then graceful shutdown
@@ -96,9 +164,18 @@ func main() {
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	<-sc
 
+	// Stop in-flight API calls and batch work before tearing down servers
+	appCancel()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if httpServer != nil {
 		_ = httpServer.Shutdown(ctx)
 	}
+
+	// Optionally remove guild-scoped commands so redeploys don't leave stale ones behind
+	if os.Getenv("CLEANUP_COMMANDS_ON_EXIT") == "true" {
+		cleanupGuildCommands(sess)
+	}
+	// Deferred session close, then DB close, run on return
 }
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Defaults for the Sightengine error-rate alert
+const (
+	defaultAlertWindow = 20  // number of recent calls considered
+	defaultAlertRatio  = 0.5 // failure ratio that triggers an alert
+)
+
+// Metrics holds in-process counters used by /stats and operator diagnostics.
+// Counters live in memory only and reset on restart.
+type Metrics struct {
+	mu          sync.RWMutex
+	commands    map[string]uint64        // command name -> invocations
+	commandTime map[string]time.Duration // command name -> total handler time
+	panics      map[string]uint64        // command name -> invocations that panicked
+
+	// Rolling window of recent Sightengine outcomes (true = failure)
+	seWindow   []bool
+	seNext     int
+	seFilled   int
+	seSuccess  uint64
+	seFailure  uint64
+	alertRatio float64
+	alerting   bool
+
+	// notify posts operator alerts; nil disables alerting
+	notify func(title, msg string)
+}
+
+var metrics = newMetrics()
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		commands:    make(map[string]uint64),
+		commandTime: make(map[string]time.Duration),
+		panics:      make(map[string]uint64),
+		seWindow:    make([]bool, defaultAlertWindow),
+		alertRatio:  defaultAlertRatio,
+	}
+}
+
+// RecordCommand records one invocation of the named slash command, how long its handler ran
+// and whether it panicked
+func (m *Metrics) RecordCommand(name string, took time.Duration, panicked bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commands[name]++
+	m.commandTime[name] += took
+	if panicked {
+		m.panics[name]++
+	}
+}
+
+// AverageCommandTime returns the mean handler time across all commands served (0 before the first)
+func (m *Metrics) AverageCommandTime() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var total time.Duration
+	var n uint64
+	for name, c := range m.commands {
+		total += m.commandTime[name]
+		n += c
+	}
+	if n == 0 {
+		return 0
+	}
+	return total / time.Duration(n)
+}
+
+// CommandsTotal returns the total number of commands served since startup
+func (m *Metrics) CommandsTotal() uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var total uint64
+	for _, n := range m.commands {
+		total += n
+	}
+	return total
+}
+
+// CommandCounts returns a sorted snapshot of per-command counters (highest first)
+func (m *Metrics) CommandCounts() []CommandCount {
+	m.mu.RLock()
+	out := make([]CommandCount, 0, len(m.commands))
+	for name, n := range m.commands {
+		out = append(out, CommandCount{Name: name, Count: n})
+	}
+	m.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count == out[j].Count {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].Count > out[j].Count
+	})
+	return out
+}
+
+// CommandCount is a single entry of the per-command counter snapshot
+type CommandCount struct {
+	Name  string
+	Count uint64
+}
+
+// withTelemetry is the single seam for per-command observability: for interactions addressed to
+// the named command it logs one key=value line (command, guild, user, outcome, duration) and feeds
+// the same data to metrics. Panics are recorded and re-raised for safeHandler to answer
+func withTelemetry(name string, h interactionHandler) interactionHandler {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		if i.Type != discordgo.InteractionApplicationCommand || i.ApplicationCommandData().Name != name {
+			h(s, i)
+			return
+		}
+		start := time.Now()
+		outcome := "ok"
+		defer func() {
+			r := recover()
+			if r != nil {
+				outcome = "panic"
+			}
+			took := time.Since(start)
+			metrics.RecordCommand(name, took, r != nil)
+			log.Printf("interaction command=%s guild=%s user=%s outcome=%s duration=%s",
+				name, cmp.Or(i.GuildID, "dm"), cmp.Or(interactionUserID(i), "unknown"), outcome, took.Round(time.Millisecond))
+			if r != nil {
+				panic(r)
+			}
+		}()
+		h(s, i)
+	}
+}
+
+// RecordSightengine records the outcome of a Sightengine call. Only failures that
+// indicate an outage (connectivity, 429, 5xx) count towards the alert ratio;
+// user errors such as a bad image URL are counted as successful round-trips
+func (m *Metrics) RecordSightengine(err error) {
+	// Calls cancelled by shutdown say nothing about upstream health
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	failed := false
+	if err != nil {
+		_, failed = classifySightengineError(err)
+	}
+
+	m.mu.Lock()
+	if failed {
+		m.seFailure++
+	} else {
+		m.seSuccess++
+	}
+	m.seWindow[m.seNext] = failed
+	m.seNext = (m.seNext + 1) % len(m.seWindow)
+	if m.seFilled < len(m.seWindow) {
+		m.seFilled++
+	}
+
+	// Only evaluate once the window is full to avoid alerting on a single early failure
+	var title, msg string
+	if m.seFilled == len(m.seWindow) && m.notify != nil {
+		ratio := m.windowFailureRatioLocked()
+		switch {
+		case !m.alerting && ratio >= m.alertRatio:
+			m.alerting = true
+			title = "Sightengine error rate alert"
+			msg = fmt.Sprintf("%.0f%% of the last %d Sightengine calls failed. Analysis commands are likely degraded.", ratio*100, len(m.seWindow))
+		case m.alerting && ratio < m.alertRatio:
+			m.alerting = false
+			title = "Sightengine recovered"
+			msg = fmt.Sprintf("Failure rate dropped to %.0f%% over the last %d calls.", ratio*100, len(m.seWindow))
+		}
+	}
+	notify := m.notify
+	m.mu.Unlock()
+
+	if title != "" {
+		go notify(title, msg)
+	}
+}
+
+// SightengineCounts returns lifetime success/failure totals and the current window failure ratio
+func (m *Metrics) SightengineCounts() (success, failure uint64, windowRatio float64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.seSuccess, m.seFailure, m.windowFailureRatioLocked()
+}
+
+// windowFailureRatioLocked computes the failure ratio over the filled part of the window; caller holds mu
+func (m *Metrics) windowFailureRatioLocked() float64 {
+	if m.seFilled == 0 {
+		return 0
+	}
+	failures := 0
+	for idx := 0; idx < m.seFilled; idx++ {
+		if m.seWindow[idx] {
+			failures++
+		}
+	}
+	return float64(failures) / float64(m.seFilled)
+}
+
+// configureAlerts enables error-rate alerts posted to ALERT_CHANNEL_ID; no-op when unset
+func configureAlerts(s *discordgo.Session) {
+	channelID := strings.TrimSpace(os.Getenv("ALERT_CHANNEL_ID"))
+	if channelID == "" {
+		return
+	}
+	window := defaultAlertWindow
+	if v, err := strconv.Atoi(strings.TrimSpace(os.Getenv("ALERT_WINDOW"))); err == nil && v > 0 {
+		window = v
+	}
+	ratio := defaultAlertRatio
+	if v, err := strconv.ParseFloat(strings.TrimSpace(os.Getenv("ALERT_FAILURE_RATIO")), 64); err == nil && v > 0 && v <= 1 {
+		ratio = v
+	}
+
+	metrics.mu.Lock()
+	metrics.seWindow = make([]bool, window)
+	metrics.seNext, metrics.seFilled = 0, 0
+	metrics.alertRatio = ratio
+	metrics.notify = func(title, msg string) {
+		embed := fitEmbed(&discordgo.MessageEmbed{Title: title, Description: msg, Color: 0xE67E22,
+			Footer: &discordgo.MessageEmbedFooter{Text: FooterText}})
+		if _, err := s.ChannelMessageSendEmbed(channelID, embed); err != nil {
+			log.Println("failed to post alert:", err)
+		}
+	}
+	metrics.mu.Unlock()
+	log.Printf("alerts: posting Sightengine error-rate alerts to channel %s", channelID)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// modLogChannel resolves the channel that receives audit messages for a guild:
+// the guild's log_channel setting, else LOG_CHANNEL_ID.
+// Returns "" when no log channel is configured or the guild's settings can't be read (audit
+// posting is skipped), so a guild's audit trail never goes to the fallback channel by mistake
+func modLogChannel(guildID string) string {
+	gs, err := settingsStore.Get(guildID)
+	if err != nil {
+		log.Println("mod log skipped, guild settings read error:", err)
+		return ""
+	}
+	if gs.LogChannelID != "" {
+		return gs.LogChannelID
+	}
+	return strings.TrimSpace(os.Getenv("LOG_CHANNEL_ID"))
+}
+
+// thresholdAuditChange is a single old→new threshold transition for the audit post
+type thresholdAuditChange struct {
+	Name     string
+	Old, New float64
+}
+
+// postThresholdAudit posts a threshold change summary, with the guild's current
+// threshold snapshot, to the configured log channel; no-op when none is set
+func postThresholdAudit(s *discordgo.Session, guildID, userID string, changes []thresholdAuditChange) {
+	channelID := modLogChannel(guildID)
+	if channelID == "" || guildID == "" || len(changes) == 0 {
+		return
+	}
+	var b strings.Builder
+	for _, c := range changes {
+		_, _ = fmt.Fprintf(&b, "%s: %.2f%% → %.2f%%\n", c.Name, c.Old*100, c.New*100)
+	}
+	ns, ne, off, ai, df := thresholdsStore.GetGuildThresholds(perms, guildID)
+	snapshot := fmt.Sprintf("Nudity (Explicit): %.0f%%\nNudity (Suggestive): %.0f%%\nOffensive: %.0f%%\nAI Generated: %.0f%%\nDeepfake: %.0f%%",
+		ne*100, ns*100, off*100, ai*100, df*100)
+	embed := fitEmbed(&discordgo.MessageEmbed{Title: "Thresholds Changed", Color: 0x8E44AD,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Changes", Value: strings.TrimRight(b.String(), "\n"), Inline: false},
+			{Name: "Changed By", Value: "<@" + userID + ">", Inline: true},
+			{Name: "Guild", Value: guildID, Inline: true},
+			{Name: "Current Thresholds", Value: snapshot, Inline: false},
+		}, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}})
+	if _, err := s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Embeds:          []*discordgo.MessageEmbed{embed},
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}); err != nil {
+		log.Println("failed to post threshold audit:", err)
+	}
+}
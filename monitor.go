@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Monitor limits: each guild can watch up to maxMonitorsPerGuild URLs, each re-analysed every
+// minMonitorInterval to maxMonitorInterval. The scheduler looks for due monitors every monitorTick
+const (
+	maxMonitorsPerGuild = 5
+	minMonitorInterval  = 15 * time.Minute
+	maxMonitorInterval  = 7 * 24 * time.Hour
+	monitorTick         = time.Minute
+)
+
+// errMonitorLimit is returned by MonitorStore.Add when the guild already has maxMonitorsPerGuild monitors
+var errMonitorLimit = fmt.Errorf("this server already has %d monitors; remove one first", maxMonitorsPerGuild)
+
+// Monitor is a URL that is periodically re-analysed for a guild. LastAllowed is the verdict of
+// the last successful check (nil before the first one)
+type Monitor struct {
+	ID          int64
+	GuildID     string
+	ChannelID   string
+	URL         string
+	Interval    time.Duration
+	CreatedBy   string
+	LastChecked time.Time
+	LastAllowed *bool
+}
+
+// Due reports whether the monitor should be checked at now
+func (m Monitor) Due(now time.Time) bool {
+	return m.LastChecked.IsZero() || !now.Before(m.LastChecked.Add(m.Interval))
+}
+
+// MonitorStore keeps monitors in the monitors table when a DB is configured and in memory
+// otherwise (lost on restart)
+type MonitorStore struct {
+	mu     sync.Mutex
+	ps     *PermStore
+	mem    map[int64]Monitor
+	nextID int64
+}
+
+var monitorStore = &MonitorStore{mem: make(map[int64]Monitor)}
+
+// Init creates the monitors table if a DB is available
+func (ms *MonitorStore) Init(ps *PermStore) error {
+	ms.mu.Lock()
+	ms.ps = ps
+	ms.mu.Unlock()
+	if ps == nil || ps.db == nil {
+		return nil
+	}
+	var ddl string
+	switch ps.dialect {
+	case DialectPostgres:
+		ddl = `CREATE TABLE IF NOT EXISTS monitors (
+			id               BIGSERIAL PRIMARY KEY,
+			guild_id         TEXT NOT NULL,
+			channel_id       TEXT NOT NULL,
+			url              TEXT NOT NULL,
+			interval_seconds BIGINT NOT NULL,
+			created_by       TEXT NOT NULL,
+			last_checked     TIMESTAMPTZ NULL,
+			last_allowed     BOOLEAN NULL,
+			created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`
+	case DialectMySQL:
+		ddl = `CREATE TABLE IF NOT EXISTS monitors (
+			id               BIGINT AUTO_INCREMENT PRIMARY KEY,
+			guild_id         VARCHAR(64) NOT NULL,
+			channel_id       VARCHAR(64) NOT NULL,
+			url              TEXT NOT NULL,
+			interval_seconds BIGINT NOT NULL,
+			created_by       VARCHAR(64) NOT NULL,
+			last_checked     TIMESTAMP NULL,
+			last_allowed     BOOLEAN NULL,
+			created_at       TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			INDEX monitors_guild (guild_id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+	default:
+		return fmt.Errorf("unsupported dialect: %s", ps.dialect)
+	}
+	if _, err := ps.db.Exec(ddl); err != nil {
+		return fmt.Errorf("create monitors table: %w", err)
+	}
+	return nil
+}
+
+// Add stores a new monitor and returns its ID; it fails with errMonitorLimit when the guild is at its cap
+func (ms *MonitorStore) Add(m Monitor) (int64, error) {
+	existing, err := ms.List(m.GuildID)
+	if err != nil {
+		return 0, err
+	}
+	if len(existing) >= maxMonitorsPerGuild {
+		return 0, errMonitorLimit
+	}
+	ms.mu.Lock()
+	ps := ms.ps
+	if ps == nil || ps.db == nil {
+		ms.nextID++
+		m.ID = ms.nextID
+		ms.mem[m.ID] = m
+		ms.mu.Unlock()
+		return m.ID, nil
+	}
+	ms.mu.Unlock()
+
+	secs := int64(m.Interval / time.Second)
+	if ps.dialect == DialectPostgres {
+		var id int64
+		err := ps.db.QueryRow(`INSERT INTO monitors (guild_id, channel_id, url, interval_seconds, created_by)
+			VALUES ($1, $2, $3, $4, $5) RETURNING id`, m.GuildID, m.ChannelID, m.URL, secs, m.CreatedBy).Scan(&id)
+		return id, err
+	}
+	res, err := ps.db.Exec(`INSERT INTO monitors (guild_id, channel_id, url, interval_seconds, created_by)
+		VALUES (?, ?, ?, ?, ?)`, m.GuildID, m.ChannelID, m.URL, secs, m.CreatedBy)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// List returns a guild's monitors ordered by ID; an empty guildID lists every monitor
+func (ms *MonitorStore) List(guildID string) ([]Monitor, error) {
+	ms.mu.Lock()
+	ps := ms.ps
+	if ps == nil || ps.db == nil {
+		var out []Monitor
+		for _, m := range ms.mem {
+			if guildID == "" || m.GuildID == guildID {
+				out = append(out, m)
+			}
+		}
+		ms.mu.Unlock()
+		sort.Slice(out, func(a, b int) bool { return out[a].ID < out[b].ID })
+		return out, nil
+	}
+	ms.mu.Unlock()
+
+	stmt := `SELECT id, guild_id, channel_id, url, interval_seconds, created_by, last_checked, last_allowed FROM monitors`
+	var args []any
+	if guildID != "" {
+		stmt += ` WHERE guild_id = ` + thresholdsStore.param(ps, 1)
+		args = append(args, guildID)
+	}
+	rows, err := ps.db.Query(stmt+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var out []Monitor
+	for rows.Next() {
+		var (
+			m       Monitor
+			secs    int64
+			checked sql.NullTime
+			allowed sql.NullBool
+		)
+		if err := rows.Scan(&m.ID, &m.GuildID, &m.ChannelID, &m.URL, &secs, &m.CreatedBy, &checked, &allowed); err != nil {
+			return nil, err
+		}
+		m.Interval = time.Duration(secs) * time.Second
+		if checked.Valid {
+			m.LastChecked = checked.Time
+		}
+		if allowed.Valid {
+			m.LastAllowed = &allowed.Bool
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// Remove deletes one of a guild's monitors and reports whether it existed
+func (ms *MonitorStore) Remove(guildID string, id int64) (bool, error) {
+	ms.mu.Lock()
+	ps := ms.ps
+	if ps == nil || ps.db == nil {
+		m, ok := ms.mem[id]
+		if ok && m.GuildID == guildID {
+			delete(ms.mem, id)
+		}
+		ms.mu.Unlock()
+		return ok && m.GuildID == guildID, nil
+	}
+	ms.mu.Unlock()
+	res, err := ps.db.Exec(`DELETE FROM monitors WHERE guild_id = `+thresholdsStore.param(ps, 1)+` AND id = `+thresholdsStore.param(ps, 2), guildID, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ClearGuild deletes all of a guild's monitors
+func (ms *MonitorStore) ClearGuild(guildID string) error {
+	ms.mu.Lock()
+	ps := ms.ps
+	if ps == nil || ps.db == nil {
+		for id, m := range ms.mem {
+			if m.GuildID == guildID {
+				delete(ms.mem, id)
+			}
+		}
+		ms.mu.Unlock()
+		return nil
+	}
+	ms.mu.Unlock()
+	_, err := ps.db.Exec(`DELETE FROM monitors WHERE guild_id = `+thresholdsStore.param(ps, 1), guildID)
+	return err
+}
+
+// RecordCheck stores when a monitor was last checked and, when the check succeeded, its verdict
+func (ms *MonitorStore) RecordCheck(id int64, checked time.Time, allowed *bool) error {
+	ms.mu.Lock()
+	ps := ms.ps
+	if ps == nil || ps.db == nil {
+		if m, ok := ms.mem[id]; ok {
+			m.LastChecked = checked
+			if allowed != nil {
+				m.LastAllowed = allowed
+			}
+			ms.mem[id] = m
+		}
+		ms.mu.Unlock()
+		return nil
+	}
+	ms.mu.Unlock()
+	if allowed == nil {
+		_, err := ps.db.Exec(`UPDATE monitors SET last_checked = `+thresholdsStore.param(ps, 1)+` WHERE id = `+thresholdsStore.param(ps, 2), checked, id)
+		return err
+	}
+	_, err := ps.db.Exec(`UPDATE monitors SET last_checked = `+thresholdsStore.param(ps, 1)+`, last_allowed = `+thresholdsStore.param(ps, 2)+
+		` WHERE id = `+thresholdsStore.param(ps, 3), checked, *allowed, id)
+	return err
+}
+
+// startMonitorScheduler re-analyses due monitors every monitorTick until shutdown
+func startMonitorScheduler(s *discordgo.Session) {
+	go func() {
+		ticker := time.NewTicker(monitorTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-appCtx.Done():
+				return
+			case <-ticker.C:
+				runDueMonitors(s, time.Now().UTC())
+			}
+		}
+	}()
+}
+
+// runDueMonitors checks due monitors one at a time, so monitoring adds at most one concurrent
+// Sightengine call. Guilds over their monthly quota are skipped, and a rate-limited response
+// ends the round early; skipped monitors stay due and are retried on the next tick
+func runDueMonitors(s *discordgo.Session, now time.Time) {
+	monitors, err := monitorStore.List("")
+	if err != nil {
+		log.Println("monitor list error:", err)
+		return
+	}
+	for _, m := range monitors {
+		if !m.Due(now) || usageStore.OverQuota(m.GuildID) {
+			continue
+		}
+		if err := checkMonitor(s, m, now); errors.Is(err, ErrRateLimited) {
+			log.Println("monitor round stopped: sightengine rate limited")
+			return
+		}
+	}
+}
+
+// checkMonitor analyses a monitored URL and alerts its channel when the verdict turns to flagged
+func checkMonitor(s *discordgo.Session, m Monitor, now time.Time) error {
+	ctx, cancel := context.WithTimeout(withUsageGuild(appCtx, m.GuildID), analysisCallTimeout)
+	defer cancel()
+	a, err := AnalyseImageURL(ctx, m.GuildID, m.URL)
+	if err != nil {
+		log.Printf("monitor %d check failed: %v", m.ID, err)
+		if !errors.Is(err, ErrRateLimited) {
+			// Wait a full interval before retrying a URL that failed
+			if err := monitorStore.RecordCheck(m.ID, now, nil); err != nil {
+				log.Println("monitor update error:", err)
+			}
+		}
+		return err
+	}
+	if !a.Allowed && (m.LastAllowed == nil || *m.LastAllowed) {
+		postMonitorAlert(s, m, a)
+	}
+	if err := monitorStore.RecordCheck(m.ID, now, &a.Allowed); err != nil {
+		log.Println("monitor update error:", err)
+	}
+	return nil
+}
+
+// postMonitorAlert posts a flagged verdict for a monitor to its channel
+func postMonitorAlert(s *discordgo.Session, m Monitor, a *Analysis) {
+	shownURL := m.URL
+	if hideFlaggedURL(m.GuildID, false) {
+		shownURL = hiddenFlaggedURL
+	}
+	embed := fitEmbed(&discordgo.MessageEmbed{Title: "Monitored Image Flagged", Color: 0xE74C3C,
+		Description: fmt.Sprintf("Monitor #%d: %s", m.ID, shownURL),
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Reasons", Value: strings.Join(a.Reasons, ", "), Inline: false},
+			{Name: "Added By", Value: "<@" + m.CreatedBy + ">", Inline: true},
+			{Name: "Checked Every", Value: m.Interval.String(), Inline: true},
+		}, Footer: &discordgo.MessageEmbedFooter{Text: FooterText}})
+	if _, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+		Embeds:          []*discordgo.MessageEmbed{embed},
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}); err != nil {
+		log.Printf("failed to post monitor %d alert: %v", m.ID, err)
+	}
+}
@@ -1,19 +1,27 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
-	_ "github.com/go-sql-driver/mysql"
-	_ "github.com/lib/pq"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
 )
 
 // OwnerID is a constant fallback for the primary owner user ID
@@ -24,6 +32,7 @@ const OwnerID = "757284149793914900"
 const (
 	PermAdministrator = 1 << 3 // 0x00000008
 	PermManageGuild   = 1 << 5 // 0x00000020
+	PermAttachFiles   = 1 << 15
 )
 
 // Supported DB dialects
@@ -41,6 +50,12 @@ type PermStore struct {
 	guildRoles map[string]map[string]struct{} // guildID -> set(roleID) (used for JSON fallback)
 	filePath   string                         // JSON file path (fallback)
 
+	// Debounced JSON writes: changes mark the store dirty and a timer flushes them
+	// permsSaveDelay later, so a burst of edits is written once (see scheduleSave)
+	dirty      bool
+	flushTimer *time.Timer
+	saveMu     sync.Mutex // serialises file writes
+
 	db      *sql.DB
 	dialect string
 }
@@ -51,9 +66,32 @@ func NewPermStore() *PermStore {
 
 var perms = NewPermStore()
 
+// mysqlUTCDSN makes MySQL timestamps round-trip as UTC: TIMESTAMP columns are parsed into
+// time.Time (parseTime), interpreted as UTC (loc), and the session time zone is UTC so
+// CURRENT_TIMESTAMP defaults are written in UTC regardless of the server's zone
+func mysqlUTCDSN(dsn string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	if cfg.Params == nil {
+		cfg.Params = map[string]string{}
+	}
+	cfg.Params["time_zone"] = "'+00:00'"
+	return cfg.FormatDSN(), nil
+}
+
 // ConfigureDB connects to the database and ensures the permissions table exists
 // dialect: "postgres" or "mysql"
 func (ps *PermStore) ConfigureDB(dialect, dsn string) error {
+	if dialect == DialectMySQL {
+		var err error
+		if dsn, err = mysqlUTCDSN(dsn); err != nil {
+			return fmt.Errorf("parse mysql dsn: %w", err)
+		}
+	}
 	db, err := sql.Open(dialect, dsn)
 	if err != nil {
 		return fmt.Errorf("open db: %w", err)
@@ -87,10 +125,133 @@ func (ps *PermStore) ConfigureDB(dialect, dsn string) error {
 	if _, err := db.Exec(ddl); err != nil {
 		return fmt.Errorf("create table: %w", err)
 	}
+
+	// Audit trail for role whitelist changes, mirroring thresholds_history
+	switch dialect {
+	case DialectPostgres:
+		ddl = `CREATE TABLE IF NOT EXISTS permissions_history (
+			id BIGSERIAL PRIMARY KEY,
+			guild_id TEXT NOT NULL,
+			role_id TEXT NOT NULL,
+			action TEXT NOT NULL,
+			user_id TEXT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`
+	case DialectMySQL:
+		ddl = `CREATE TABLE IF NOT EXISTS permissions_history (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			guild_id VARCHAR(64) NOT NULL,
+			role_id VARCHAR(64) NOT NULL,
+			action VARCHAR(16) NOT NULL,
+			user_id VARCHAR(64) NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+	}
+	if _, err := db.Exec(ddl); err != nil {
+		return fmt.Errorf("create permissions_history table: %w", err)
+	}
 	log.Printf("permissions: using %s database storage", dialect)
 	return nil
 }
 
+// Close releases the database connection pool; no-op in JSON fallback mode
+func (ps *PermStore) Close() error {
+	if ps.db == nil {
+		return nil
+	}
+	return ps.db.Close()
+}
+
+// DB retry policy: connection errors (e.g. a failover dropping pooled connections) are retried
+// up to dbRetryAttempts times, waiting dbRetryBackoff and then twice as long each time. Writes
+// are only retried when the statement can't have reached the server (see isUnsentDBError), so
+// inserts such as audit rows are never written twice
+const (
+	dbRetryAttempts = 3
+	dbRetryBackoff  = 200 * time.Millisecond
+)
+
+// exec is db.Exec retried when the connection failed before the statement was sent
+func (ps *PermStore) exec(query string, args ...any) (sql.Result, error) {
+	var res sql.Result
+	err := ps.withDBRetry(isUnsentDBError, func() (err error) {
+		res, err = ps.db.Exec(query, args...)
+		return err
+	})
+	return res, err
+}
+
+// query is db.Query retried on any connection error; reads are safe to repeat
+func (ps *PermStore) query(query string, args ...any) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := ps.withDBRetry(isTransientDBError, func() (err error) {
+		rows, err = ps.db.Query(query, args...)
+		return err
+	})
+	return rows, err
+}
+
+// withDBRetry runs op, retrying with backoff while it fails with an error retryable accepts.
+// Between attempts the pool is pinged so a fresh connection is open before the retry; the last
+// error is logged when the retries run out. Other errors (constraint violations, bad SQL) are
+// returned at once
+func (ps *PermStore) withDBRetry(retryable func(error) bool, op func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !retryable(err) {
+			return err
+		}
+		if attempt == dbRetryAttempts {
+			log.Printf("db: giving up after %d attempts: %v", attempt, err)
+			return err
+		}
+		time.Sleep(dbRetryBackoff << (attempt - 1))
+		ctx, cancel := context.WithTimeout(appCtx, 5*time.Second)
+		if err := ps.db.PingContext(ctx); err != nil {
+			log.Println("db: reconnect failed:", err)
+		}
+		cancel()
+	}
+}
+
+// isUnsentDBError reports whether err means no statement reached the server: drivers return
+// driver.ErrBadConn only in that case, and a failed dial or refused connection never got as far
+// as sending. Postgres 08001/08004 and 57P03 are raised while connecting
+func isUnsentDBError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "08001" || pqErr.Code == "08004" || pqErr.Code == "57P03"
+	}
+	return false
+}
+
+// isTransientDBError reports whether err means the connection failed rather than the statement:
+// besides isUnsentDBError, connections dropped mid-statement or a server shutting down or failing
+// over. The statement may have run, so only idempotent reads should be retried on these
+func isTransientDBError(err error) bool {
+	if isUnsentDBError(err) || errors.Is(err, sql.ErrConnDone) || errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	// Postgres class 08 is connection exceptions; 57P01-57P03 are shutdown/cannot-connect-now
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code.Class() == "08" || pqErr.Code == "57P01" || pqErr.Code == "57P02" || pqErr.Code == "57P03"
+	}
+	return false
+}
+
 // ConfigureFile sets the JSON file path used for persistence (fallback mode)
 func (ps *PermStore) ConfigureFile(path string) {
 	ps.mu.Lock()
@@ -109,7 +270,7 @@ func (ps *PermStore) AddRole(guildID, roleID string) {
 		case DialectMySQL:
 			sqlStmt = `INSERT IGNORE INTO permissions (guild_id, role_id) VALUES (?, ?)`
 		}
-		if _, err := ps.db.Exec(sqlStmt, guildID, roleID); err != nil {
+		if _, err := ps.exec(sqlStmt, guildID, roleID); err != nil {
 			log.Println("permissions db insert error:", err)
 		}
 		return
@@ -123,13 +284,8 @@ func (ps *PermStore) AddRole(guildID, roleID string) {
 		ps.guildRoles[guildID] = set
 	}
 	set[roleID] = struct{}{}
-	path := ps.filePath
 	ps.mu.Unlock()
-	if path != "" {
-		if err := ps.SaveToFile(); err != nil {
-			log.Println("permissions save (add) error:", err)
-		}
-	}
+	ps.scheduleSave()
 }
 
 // RemoveRole removes a role from the allowed set for a guild and persists
@@ -143,7 +299,7 @@ func (ps *PermStore) RemoveRole(guildID, roleID string) {
 		case DialectMySQL:
 			sqlStmt = `DELETE FROM permissions WHERE guild_id = ? AND role_id = ?`
 		}
-		if _, err := ps.db.Exec(sqlStmt, guildID, roleID); err != nil {
+		if _, err := ps.exec(sqlStmt, guildID, roleID); err != nil {
 			log.Println("permissions db delete error:", err)
 		}
 		return
@@ -157,13 +313,133 @@ func (ps *PermStore) RemoveRole(guildID, roleID string) {
 			delete(ps.guildRoles, guildID)
 		}
 	}
-	path := ps.filePath
 	ps.mu.Unlock()
-	if path != "" {
-		if err := ps.SaveToFile(); err != nil {
-			log.Println("permissions save (remove) error:", err)
+	ps.scheduleSave()
+}
+
+// ClearGuild removes every allowed role for a guild (DB rows or JSON fallback entry)
+func (ps *PermStore) ClearGuild(guildID string) error {
+	// DB-backed path
+	if ps.db != nil {
+		var sqlStmt string
+		switch ps.dialect {
+		case DialectPostgres:
+			sqlStmt = `DELETE FROM permissions WHERE guild_id = $1`
+		case DialectMySQL:
+			sqlStmt = `DELETE FROM permissions WHERE guild_id = ?`
+		}
+		_, err := ps.exec(sqlStmt, guildID)
+		return err
+	}
+
+	// JSON fallback: resets are rare and report failures, so write now instead of debouncing
+	ps.mu.Lock()
+	delete(ps.guildRoles, guildID)
+	ps.mu.Unlock()
+	ps.scheduleSave()
+	return ps.Flush()
+}
+
+// Permission history actions
+const (
+	PermActionAdd    = "add"
+	PermActionRemove = "remove"
+)
+
+// PermChange is one audited role whitelist change
+type PermChange struct {
+	GuildID string
+	RoleID  string
+	Action  string
+	UserID  sql.NullString
+	Created time.Time
+}
+
+// LogChange writes an audit record for a role add/remove; no-op when DB is not configured
+func (ps *PermStore) LogChange(guildID, roleID, action, userID string) error {
+	if ps.db == nil {
+		return nil
+	}
+	var stmt string
+	switch ps.dialect {
+	case DialectPostgres:
+		stmt = `INSERT INTO permissions_history (guild_id, role_id, action, user_id) VALUES ($1, $2, $3, $4)`
+	case DialectMySQL:
+		stmt = `INSERT INTO permissions_history (guild_id, role_id, action, user_id) VALUES (?, ?, ?, ?)`
+	}
+	_, err := ps.exec(stmt, guildID, roleID, action, userID)
+	return err
+}
+
+// History returns recent role whitelist changes for a guild, newest first; empty when DB not configured
+func (ps *PermStore) History(guildID string, limit int) ([]PermChange, error) {
+	changes := []PermChange{}
+	if ps.db == nil {
+		return changes, nil
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 10
+	}
+	var (
+		rows *sql.Rows
+		err  error
+	)
+	switch ps.dialect {
+	case DialectPostgres:
+		rows, err = ps.query(`SELECT guild_id, role_id, action, user_id, created_at
+			FROM permissions_history WHERE guild_id = $1 ORDER BY created_at DESC LIMIT $2`, guildID, limit)
+	case DialectMySQL:
+		rows, err = ps.query(`SELECT guild_id, role_id, action, user_id, created_at
+			FROM permissions_history WHERE guild_id = ? ORDER BY created_at DESC LIMIT ?`, guildID, limit)
+	}
+	if err != nil {
+		return changes, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c PermChange
+		if err := rows.Scan(&c.GuildID, &c.RoleID, &c.Action, &c.UserID, &c.Created); err != nil {
+			log.Println("permissions history scan:", err)
+			continue
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+// ClearGuildHistory deletes the role whitelist audit history for a guild
+func (ps *PermStore) ClearGuildHistory(guildID string) error {
+	if ps.db == nil {
+		return nil
+	}
+	var stmt string
+	switch ps.dialect {
+	case DialectPostgres:
+		stmt = `DELETE FROM permissions_history WHERE guild_id = $1`
+	case DialectMySQL:
+		stmt = `DELETE FROM permissions_history WHERE guild_id = ?`
+	}
+	_, err := ps.exec(stmt, guildID)
+	return err
+}
+
+// guildRoleIDs returns the IDs of a guild's roles, from the state cache when available
+func guildRoleIDs(s *discordgo.Session, guildID string) (map[string]struct{}, error) {
+	var roles []*discordgo.Role
+	if g, err := s.State.Guild(guildID); err == nil && len(g.Roles) > 0 {
+		roles = g.Roles
+	} else {
+		fetched, err := s.GuildRoles(guildID)
+		if err != nil {
+			return nil, err
 		}
+		roles = fetched
+	}
+	ids := make(map[string]struct{}, len(roles))
+	for _, r := range roles {
+		ids[r.ID] = struct{}{}
 	}
+	return ids, nil
 }
 
 // ListRoles returns a copy of the allowed role IDs for a guild
@@ -176,9 +452,9 @@ func (ps *PermStore) ListRoles(guildID string) []string {
 		)
 		switch ps.dialect {
 		case DialectPostgres:
-			rows, err = ps.db.Query(`SELECT role_id FROM permissions WHERE guild_id = $1`, guildID)
+			rows, err = ps.query(`SELECT role_id FROM permissions WHERE guild_id = $1`, guildID)
 		case DialectMySQL:
-			rows, err = ps.db.Query(`SELECT role_id FROM permissions WHERE guild_id = ?`, guildID)
+			rows, err = ps.query(`SELECT role_id FROM permissions WHERE guild_id = ?`, guildID)
 		}
 		if err != nil {
 			log.Println("permissions db list error:", err)
@@ -209,15 +485,65 @@ func (ps *PermStore) ListRoles(guildID string) []string {
 	return out
 }
 
-// IsOwner returns true if the user is the configured owner
+// IsOwner returns true if the user is the configured owner or one of EXTRA_OWNER_IDS
 func IsOwner(userID string) bool {
+	if userID == "" {
+		return false
+	}
+	if slices.Contains(splitCSV(os.Getenv("EXTRA_OWNER_IDS")), userID) {
+		return true
+	}
 	if env := strings.TrimSpace(os.Getenv("OWNER_ID")); env != "" {
 		return userID == env
 	}
 	return userID == OwnerID
 }
 
-// HasAdminContextPermission returns true if the interaction member has Administrator or Manage Guild permissions
+// IsGuildOwner reports whether the invoking user is a delegated owner of the interaction's
+// guild (the "owners" setting). Delegated owners manage the bot in that guild only
+func IsGuildOwner(i *discordgo.InteractionCreate) bool {
+	uid := interactionUserID(i)
+	if i.GuildID == "" || uid == "" {
+		return false
+	}
+	gs, err := settingsStore.Get(i.GuildID)
+	if err != nil {
+		log.Println("guild settings read error:", err)
+		return false
+	}
+	return slices.Contains(gs.DelegatedOwners, uid)
+}
+
+// CanManageGuild reports whether the invoking user may change the bot's configuration here:
+// a global owner, a delegated guild owner, or a member with Administrator/Manage Server
+func CanManageGuild(i *discordgo.InteractionCreate) bool {
+	return IsOwner(interactionUserID(i)) || IsGuildOwner(i) || HasAdminContextPermission(i)
+}
+
+// CanDelegateOwners reports whether the invoking user may change the guild's delegated owners:
+// a global owner or a member with Administrator/Manage Server. Delegated owners can't, so they
+// can't add others or keep themselves in the list once an admin removes them
+func CanDelegateOwners(i *discordgo.InteractionCreate) bool {
+	return IsOwner(interactionUserID(i)) || HasAdminContextPermission(i)
+}
+
+// interactionUserID returns the invoking user's ID for guild (Member) and DM (User) interactions
+func interactionUserID(i *discordgo.InteractionCreate) string {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User.ID
+	}
+	if i.User != nil {
+		return i.User.ID
+	}
+	return ""
+}
+
+// HasAdminContextPermission returns true if the interaction member has Administrator or Manage Guild permissions.
+// i.Member.Permissions is what Discord computed for the invoking member in this channel, overwrites
+// included. DMs and user-installed contexts without a Member carry no guild permissions, so this is
+// false there. i.AppPermissions is deliberately not consulted: it is the bot's own permission set in
+// the channel (see appHasPermission), so honouring it would make every user an admin wherever the
+// bot itself has Administrator or Manage Guild
 func HasAdminContextPermission(i *discordgo.InteractionCreate) bool {
 	if i.Member == nil {
 		return false
@@ -226,26 +552,44 @@ func HasAdminContextPermission(i *discordgo.InteractionCreate) bool {
 	return (permsVal&PermAdministrator) != 0 || (permsVal&PermManageGuild) != 0
 }
 
+// isGuildInstalled reports whether the interaction comes from a guild the app is installed in.
+// Interactions from user-installed contexts only list the user install, so guild-scoped
+// data (role whitelists, settings) may not have been configured by that guild's admins
+func isGuildInstalled(i *discordgo.InteractionCreate) bool {
+	if i.GuildID == "" {
+		return false
+	}
+	if len(i.AuthorizingIntegrationOwners) == 0 {
+		return true // older payloads without integration owners are always guild installs
+	}
+	_, ok := i.AuthorizingIntegrationOwners[discordgo.ApplicationIntegrationGuildInstall]
+	return ok
+}
+
+// appHasPermission reports whether the bot holds perm in the interaction's channel.
+// Payloads without app_permissions are assumed to allow everything
+func appHasPermission(i *discordgo.InteractionCreate, perm int64) bool {
+	return i.AppPermissions == 0 || i.AppPermissions&perm != 0
+}
+
 // IsAllowedForRestricted checks whether the invoking user can access restricted commands in a guild
 func (ps *PermStore) IsAllowedForRestricted(i *discordgo.InteractionCreate) bool {
-	// DMs: allow only owner
+	// DMs: allow only owner (DM interactions carry User rather than Member)
 	if i.GuildID == "" {
-		if i.Member != nil && i.Member.User != nil {
-			return IsOwner(i.Member.User.ID)
-		}
-		return false
+		uid := interactionUserID(i)
+		return uid != "" && IsOwner(uid)
 	}
 
-	// Owner or admin in this context
-	if i.Member != nil && i.Member.User != nil && IsOwner(i.Member.User.ID) {
+	// Owner (Member.User in guilds, User in user-installed contexts) or admin in this context
+	if uid := interactionUserID(i); uid != "" && IsOwner(uid) {
 		return true
 	}
-	if HasAdminContextPermission(i) {
+	if HasAdminContextPermission(i) || IsGuildOwner(i) {
 		return true
 	}
 
-	// Role-based check
-	if i.Member == nil {
+	// Role-based check (needs guild membership data and a guild install to have configured roles)
+	if i.Member == nil || !isGuildInstalled(i) {
 		return false
 	}
 	userRoles := i.Member.Roles
@@ -289,14 +633,83 @@ func FormatRoleList(_ *discordgo.Session, _ string, roleIDs []string) string {
 	return strings.Join(mentions, ", ")
 }
 
+// FormatRoleNames renders role IDs as plain "@Name" text using the session state cache,
+// so it is safe in message content (no mention syntax, nothing can ping).
+// Roles missing from the cache are shown by ID
+func FormatRoleNames(s *discordgo.Session, guildID string, roleIDs []string) string {
+	names := make([]string, 0, len(roleIDs))
+	for _, id := range roleIDs {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if s != nil && s.State != nil {
+			if r, err := s.State.Role(guildID, id); err == nil && r.Name != "" {
+				names = append(names, "@"+r.Name)
+				continue
+			}
+		}
+		names = append(names, "role "+id)
+	}
+	if len(names) == 0 {
+		return "(none configured)"
+	}
+	return strings.Join(names, ", ")
+}
+
 // JSON persistence (fallback)
 
 type permJSON struct {
 	GuildRoles map[string][]string `json:"guild_roles"`
 }
 
+// permsSaveDelay is how long JSON permission changes wait before being written, batching bursts
+const permsSaveDelay = 2 * time.Second
+
+// scheduleSave marks the JSON store dirty and starts the flush timer unless one is pending
+func (ps *PermStore) scheduleSave() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.filePath == "" || ps.db != nil {
+		return
+	}
+	ps.dirty = true
+	if ps.flushTimer == nil {
+		ps.flushTimer = time.AfterFunc(permsSaveDelay, func() {
+			if err := ps.Flush(); err != nil {
+				log.Println("permissions save error:", err)
+			}
+		})
+	}
+}
+
+// Flush writes pending JSON changes now. It is called by the flush timer and on shutdown so
+// no change is lost; a failed write leaves the store dirty for the next attempt
+func (ps *PermStore) Flush() error {
+	ps.mu.Lock()
+	if ps.flushTimer != nil {
+		ps.flushTimer.Stop()
+		ps.flushTimer = nil
+	}
+	if !ps.dirty {
+		ps.mu.Unlock()
+		return nil
+	}
+	ps.dirty = false
+	ps.mu.Unlock()
+	if err := ps.SaveToFile(); err != nil {
+		ps.mu.Lock()
+		ps.dirty = true
+		ps.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
 // SaveToFile writes the current permissions to disk in JSON format with an atomic rename
 func (ps *PermStore) SaveToFile() error {
+	ps.saveMu.Lock()
+	defer ps.saveMu.Unlock()
 	ps.mu.RLock()
 	if ps.filePath == "" || ps.db != nil {
 		ps.mu.RUnlock()
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-sql-driver/mysql"
+)
+
+// newMockPermStore returns a Postgres-dialect PermStore backed by sqlmock
+func newMockPermStore(t *testing.T) (*PermStore, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	ps := NewPermStore()
+	ps.db, ps.dialect = db, DialectPostgres
+	return ps, mock
+}
+
+func TestExecRetriesUnsentErrors(t *testing.T) {
+	ps, mock := newMockPermStore(t)
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	mock.ExpectExec("INSERT INTO permissions").WillReturnError(dialErr)
+	mock.ExpectExec("INSERT INTO permissions").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ps.AddRole("g1", "r1")
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExecDoesNotRetryPossiblySentErrors(t *testing.T) {
+	for _, sentErr := range []error{
+		io.ErrUnexpectedEOF,
+		syscall.ECONNRESET,
+		&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET},
+	} {
+		ps, mock := newMockPermStore(t)
+		mock.ExpectExec("INSERT INTO permissions_history").WillReturnError(sentErr)
+
+		_, err := ps.exec(`INSERT INTO permissions_history (guild_id) VALUES ($1)`, "g1")
+		if !errors.Is(err, sentErr) {
+			t.Errorf("%v: err = %v, want it returned without a retry", sentErr, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%v: %v", sentErr, err)
+		}
+	}
+}
+
+func TestQueryRetriesDroppedConnections(t *testing.T) {
+	ps, mock := newMockPermStore(t)
+	mock.ExpectQuery("SELECT role_id FROM permissions").WillReturnError(io.ErrUnexpectedEOF)
+	mock.ExpectQuery("SELECT role_id FROM permissions").WillReturnRows(sqlmock.NewRows([]string{"role_id"}).AddRow("r1"))
+
+	rows, err := ps.query(`SELECT role_id FROM permissions WHERE guild_id = $1`, "g1")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	_ = rows.Close()
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithDBRetryGivesUp(t *testing.T) {
+	ps, _ := newMockPermStore(t)
+	attempts := 0
+	err := ps.withDBRetry(isUnsentDBError, func() error {
+		attempts++
+		return syscall.ECONNREFUSED
+	})
+	if !errors.Is(err, syscall.ECONNREFUSED) || attempts != dbRetryAttempts {
+		t.Errorf("err = %v after %d attempts, want ECONNREFUSED after %d", err, attempts, dbRetryAttempts)
+	}
+}
+
+func TestMySQLUTCDSN(t *testing.T) {
+	for _, tc := range []struct {
+		name, dsn string
+		keep      []string // other params that must survive
+	}{
+		{"no params", "bot:pw@tcp(db:3306)/chief", nil},
+		{"other params", "bot:pw@tcp(db:3306)/chief?charset=utf8mb4&timeout=5s", []string{"charset=utf8mb4", "timeout=5s"}},
+		{"parseTime and loc already set", "bot:pw@tcp(db:3306)/chief?parseTime=false&loc=Local", nil},
+		{"parseTime and loc already UTC", "bot:pw@tcp(db:3306)/chief?parseTime=true&loc=UTC", nil},
+		{"session time zone set", "bot:pw@tcp(db:3306)/chief?time_zone=%27SYSTEM%27&charset=utf8mb4", []string{"charset=utf8mb4"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := mysqlUTCDSN(tc.dsn)
+			if err != nil {
+				t.Fatalf("mysqlUTCDSN: %v", err)
+			}
+			cfg, err := mysql.ParseDSN(out)
+			if err != nil {
+				t.Fatalf("result %q doesn't parse: %v", out, err)
+			}
+			if !cfg.ParseTime || cfg.Loc != time.UTC {
+				t.Errorf("%q: parseTime=%v loc=%v, want true and UTC", out, cfg.ParseTime, cfg.Loc)
+			}
+			if got := cfg.Params["time_zone"]; got != "'+00:00'" {
+				t.Errorf("%q: time_zone = %q, want '+00:00'", out, got)
+			}
+			if cfg.User != "bot" || cfg.Passwd != "pw" || cfg.Addr != "db:3306" || cfg.DBName != "chief" {
+				t.Errorf("%q: connection settings changed", out)
+			}
+			for _, param := range tc.keep {
+				if !strings.Contains(out, param) {
+					t.Errorf("%q: lost %s", out, param)
+				}
+			}
+			// Applying it twice changes nothing
+			if again, err := mysqlUTCDSN(out); err != nil || again != out {
+				t.Errorf("second pass = %q, %v; want %q", again, err, out)
+			}
+		})
+	}
+	if _, err := mysqlUTCDSN("bot:pw@tcp(db:3306)"); err == nil {
+		t.Error("malformed DSN accepted")
+	}
+}
+
+func TestHasAdminContextPermission(t *testing.T) {
+	member := func(perms int64) *discordgo.Member {
+		return &discordgo.Member{User: &discordgo.User{ID: "u1"}, Permissions: perms}
+	}
+	for _, tc := range []struct {
+		name string
+		in   discordgo.Interaction
+		want bool
+	}{
+		{"administrator", discordgo.Interaction{GuildID: "g1", Member: member(PermAdministrator)}, true},
+		{"manage guild", discordgo.Interaction{GuildID: "g1", Member: member(PermManageGuild)}, true},
+		{"both", discordgo.Interaction{GuildID: "g1", Member: member(PermAdministrator | PermManageGuild | PermAttachFiles)}, true},
+		{"other permissions", discordgo.Interaction{GuildID: "g1", Member: member(PermAttachFiles | discordgo.PermissionManageMessages)}, false},
+		{"no permissions", discordgo.Interaction{GuildID: "g1", Member: member(0)}, false},
+		{"bot is admin, user isn't", discordgo.Interaction{GuildID: "g1", Member: member(0), AppPermissions: PermAdministrator | PermManageGuild}, false},
+		{"DM", discordgo.Interaction{User: &discordgo.User{ID: "u1"}, AppPermissions: PermAdministrator}, false},
+		{"no member or user", discordgo.Interaction{GuildID: "g1"}, false},
+	} {
+		i := &discordgo.InteractionCreate{Interaction: &tc.in}
+		if got := HasAdminContextPermission(i); got != tc.want {
+			t.Errorf("%s: HasAdminContextPermission = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"time"
@@ -8,8 +10,12 @@ import (
 	"github.com/bwmarrin/discordgo"
 )
 
-// registerCommands creates the slash commands either globally or guild-scoped
-func registerCommands(sess *discordgo.Session) {
+// registerCommands registers the full command set either globally or guild-scoped.
+// A single bulk overwrite lets Discord reconcile additions, updates and deletions, so
+// renamed or removed commands don't linger as ghosts.
+// If the bulk call fails, commands are created one by one so a single bad or transiently
+// failing command doesn't take the rest down; the failures are returned joined together
+func registerCommands(sess *discordgo.Session) error {
 	appID := sess.State.User.ID
 	guildID := os.Getenv("GUILD_ID")
 
@@ -19,57 +25,194 @@ func registerCommands(sess *discordgo.Session) {
 		log.Printf("Registering guild-scoped application commands to guild %s", guildID)
 	}
 
+	defs := commandDefinitions()
+	var errs []error
+	created, err := sess.ApplicationCommandBulkOverwrite(appID, guildID, defs)
+	if err != nil && guildID != "" && isGuildAccessError(err) {
+		// Every individual create would fail the same way, so don't bother falling back
+		log.Printf("cannot register commands: the bot is not a member of GUILD_ID %s or lacks the applications.commands scope; "+
+			"re-invite it with the bot and applications.commands scopes, or unset GUILD_ID to register globally (%v)", guildID, err)
+		return fmt.Errorf("guild %s is not accessible: %w", guildID, err)
+	}
+	if err != nil {
+		log.Printf("bulk command registration failed, falling back to individual creates: %v", err)
+		created = created[:0]
+		for _, def := range defs {
+			cmd, err := sess.ApplicationCommandCreate(appID, guildID, def)
+			if err != nil {
+				log.Printf("cannot create command %s: %v", def.Name, err)
+				errs = append(errs, fmt.Errorf("command %s: %w", def.Name, err))
+				continue
+			}
+			created = append(created, cmd)
+		}
+	}
+	for _, cmd := range created {
+		log.Printf("registered command: %s (id=%s)", cmd.Name, cmd.ID)
+	}
+
+	// ----------------------------------------
+	// Debug list stored commands for the chosen scope
+	// ----------------------------------------
+	go func() {
+		time.Sleep(2 * time.Second)
+
+		listScope := guildID
+		scopeLabel := "global"
+		if guildID != "" {
+			scopeLabel = "guild"
+		}
+
+		cmds, err := sess.ApplicationCommands(appID, listScope)
+		if err != nil {
+			log.Printf("failed to list %s application commands: %v", scopeLabel, err)
+			return
+		}
+		for _, c := range cmds {
+			log.Printf("discord stored %s command: name=%s id=%s", scopeLabel, c.Name, c.ID)
+		}
+	}()
+
+	return errors.Join(errs...)
+}
+
+// isGuildAccessError reports whether err is Discord's "Unknown Guild" or "Missing Access" error,
+// i.e. the bot isn't in the guild or wasn't granted the applications.commands scope there
+func isGuildAccessError(err error) bool {
+	var restErr *discordgo.RESTError
+	if !errors.As(err, &restErr) || restErr.Message == nil {
+		return false
+	}
+	return restErr.Message.Code == discordgo.ErrCodeUnknownGuild || restErr.Message.Code == discordgo.ErrCodeMissingAccess
+}
+
+// CommandSpec ties a slash command's definition to its handler and help text, so registration,
+// handler wiring and /help all come from the one list in commandSpecs
+type CommandSpec struct {
+	Command *discordgo.ApplicationCommand
+	Handler interactionHandler
+	// Help replaces Command.Description in /help when set, e.g. to mention who may use it
+	Help string
+}
+
+// commandSpecs returns every slash command with its handler, in registration order
+func commandSpecs() []CommandSpec {
+	var specs []CommandSpec
+
 	// ----------------------------------------
 	// /analyse
 	// ----------------------------------------
-	if cmd, err := sess.ApplicationCommandCreate(appID, guildID, &discordgo.ApplicationCommand{
+	specs = append(specs, CommandSpec{Handler: handleAnalyse, Command: &discordgo.ApplicationCommand{
 		Name:        "analyse",
 		Description: "Analyses an Image URL for inappropriate content",
 		Options: []*discordgo.ApplicationCommandOption{{
 			Type:        discordgo.ApplicationCommandOptionString,
 			Name:        "image_url",
-			Description: "The Image URL to analyse",
-			Required:    true,
+			Description: "The Image URL to analyse (or a message / Imgur album link)",
+			Required:    false,
+		}, {
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "message_id",
+			Description: "Instead of image_url: ID of a message in this channel whose first image to analyse",
+			Required:    false,
 		}, {
 			Type:        discordgo.ApplicationCommandOptionBoolean,
 			Name:        "advanced",
 			Description: "Advanced mode, shows more detailed results",
 			Required:    false,
+		}, {
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+			Name:        "raw",
+			Description: "Attach the raw Sightengine JSON response (owner only)",
+			Required:    false,
+		}, {
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+			Name:        "export",
+			Description: "Attach a downloadable report with scores, thresholds and verdict",
+			Required:    false,
+		}, {
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "format",
+			Description: "How to show the result (default: detailed)",
+			Required:    false,
+			Choices: []*discordgo.ApplicationCommandOptionChoice{
+				{Name: "Compact (one-line verdict)", Value: analysisFormatCompact},
+				{Name: "Detailed (embed)", Value: analysisFormatDetailed},
+				{Name: "JSON (attached file)", Value: analysisFormatJSON},
+			},
+		}, {
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+			Name:        "explain",
+			Description: "Explain which subscore tripped each reason and by how much",
+			Required:    false,
+		}, {
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+			Name:        "scorecard",
+			Description: "Attach a PNG scorecard with a bar per category",
+			Required:    false,
+		}, {
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+			Name:        "as_file",
+			Description: "With advanced: also attach every subscore as advanced.json",
+			Required:    false,
+		}, {
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "models",
+			Description: "Run only these models for a quicker, cheaper check (default: all enabled)",
+			Required:    false,
+			Choices: []*discordgo.ApplicationCommandOptionChoice{
+				{Name: "Nudity", Value: "nudity"},
+				{Name: "Offensive", Value: "offensive"},
+				{Name: "AI generated", Value: "ai"},
+				{Name: "Nudity + Offensive", Value: "nudity,offensive"},
+				{Name: "Nudity + AI generated", Value: "nudity,ai"},
+				{Name: "Offensive + AI generated", Value: "offensive,ai"},
+				{Name: "Gore", Value: "gore"},
+				{Name: "Weapons", Value: "weapons"},
+				{Name: "Gore + Weapons", Value: "gore,weapons"},
+			},
 		}},
-	}); err != nil {
-		log.Fatalf("cannot create command analyse: %v", err)
-	} else {
-		log.Printf("created command: %s (id=%s)", cmd.Name, cmd.ID)
-	}
+	}})
 
 	// ----------------------------------------
 	// /ping
 	// ----------------------------------------
-	if cmd, err := sess.ApplicationCommandCreate(appID, guildID, &discordgo.ApplicationCommand{
+	specs = append(specs, CommandSpec{Handler: handlePing, Command: &discordgo.ApplicationCommand{
 		Name:        "ping",
 		Description: "Pong!",
-	}); err != nil {
-		log.Fatalf("cannot create command ping: %v", err)
-	} else {
-		log.Printf("created command: %s (id=%s)", cmd.Name, cmd.ID)
-	}
+		Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionBoolean, Name: "detailed", Description: "Also show shard, gateway heartbeat and database ping", Required: false},
+		},
+	}})
+
+	// ----------------------------------------
+	// /stats
+	// ----------------------------------------
+	specs = append(specs, CommandSpec{Handler: handleStats, Command: &discordgo.ApplicationCommand{
+		Name:        "stats",
+		Description: "Shows bot uptime, guild count and runtime statistics",
+	}})
+
+	// ----------------------------------------
+	// /about
+	// ----------------------------------------
+	specs = append(specs, CommandSpec{Handler: handleAbout, Command: &discordgo.ApplicationCommand{
+		Name:        "about",
+		Description: "Shows the running build version and source link",
+	}})
 
 	// ----------------------------------------
 	// /help
 	// ----------------------------------------
-	if cmd, err := sess.ApplicationCommandCreate(appID, guildID, &discordgo.ApplicationCommand{
+	specs = append(specs, CommandSpec{Handler: handleHelp, Command: &discordgo.ApplicationCommand{
 		Name:        "help",
 		Description: "Shows a list of commands",
-	}); err != nil {
-		log.Fatalf("cannot create command help: %v", err)
-	} else {
-		log.Printf("created command: %s (id=%s)", cmd.Name, cmd.ID)
-	}
+	}})
 
 	// ----------------------------------------
-	// /thresholds [list | set | reset | history]
+	// /thresholds [list | set | setall | reset | history | preview]
 	// ----------------------------------------
-	if cmd, err := sess.ApplicationCommandCreate(appID, guildID, &discordgo.ApplicationCommand{
+	specs = append(specs, CommandSpec{Handler: handleThresholds, Command: &discordgo.ApplicationCommand{
 		Name:        "thresholds",
 		Description: "Shows or modifies detection thresholds",
 		Options: []*discordgo.ApplicationCommandOption{
@@ -77,11 +220,14 @@ func registerCommands(sess *discordgo.Session) {
 				Type:        discordgo.ApplicationCommandOptionSubCommand,
 				Name:        "list",
 				Description: "List current detection thresholds",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionBoolean, Name: "verbose", Description: "Show where each value comes from (guild, global or default; admins only)", Required: false},
+				},
 			},
 			{
 				Type:        discordgo.ApplicationCommandOptionSubCommand,
 				Name:        "set",
-				Description: "Set a threshold (0.00-1.00 or a percentage like 70%)",
+				Description: "Set a threshold (0.00-1.00, or a percentage like 70% or 70)",
 				Options: []*discordgo.ApplicationCommandOption{
 					{
 						Type:        discordgo.ApplicationCommandOptionString,
@@ -93,11 +239,24 @@ func registerCommands(sess *discordgo.Session) {
 							{Name: "Explicit Nudity", Value: "NudityExplicit"},
 							{Name: "Offensive Content", Value: "Offensive"},
 							{Name: "AI Generated", Value: "AIGenerated"},
+							{Name: "Deepfake", Value: "Deepfake"},
 						},
 					},
 					{Type: discordgo.ApplicationCommandOptionString, Name: "value", Description: "Decimal (0.00-1.00) or percentage (0-100%)", Required: true},
 				},
 			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "setall",
+				Description: "Set all thresholds at once (0.00-1.00, or percentages like 70% or 70)",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "explicit", Description: "Explicit Nudity threshold", Required: true},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "suggestive", Description: "Suggestive Nudity threshold", Required: true},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "offensive", Description: "Offensive Content threshold", Required: true},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "ai", Description: "AI Generated threshold", Required: true},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "deepfake", Description: "Deepfake threshold (default: unchanged)", Required: false},
+				},
+			},
 			{
 				Type:        discordgo.ApplicationCommandOptionSubCommand,
 				Name:        "reset",
@@ -113,6 +272,7 @@ func registerCommands(sess *discordgo.Session) {
 							{Name: "Explicit Nudity", Value: "NudityExplicit"},
 							{Name: "Offensive Content", Value: "Offensive"},
 							{Name: "AI Generated", Value: "AIGenerated"},
+							{Name: "Deepfake", Value: "Deepfake"},
 							{Name: "All (DANGER)", Value: "all"},
 						},
 					},
@@ -123,28 +283,60 @@ func registerCommands(sess *discordgo.Session) {
 				Name:        "history",
 				Description: "Show recent threshold changes",
 				Options: []*discordgo.ApplicationCommandOption{
-					{Type: discordgo.ApplicationCommandOptionInteger, Name: "limit", Description: "How many recent changes to show (1-100)", Required: false},
+					{Type: discordgo.ApplicationCommandOptionInteger, Name: "limit", Description: "Changes per page (1-25, default 10)", Required: false},
+					{Type: discordgo.ApplicationCommandOptionInteger, Name: "page", Description: "Page of older changes to show (default 1)", Required: false},
 					{Type: discordgo.ApplicationCommandOptionString, Name: "threshold", Description: "Filter by threshold name", Required: false,
 						Choices: []*discordgo.ApplicationCommandOptionChoice{
 							{Name: "NuditySuggestive", Value: "NuditySuggestive"},
 							{Name: "NudityExplicit", Value: "NudityExplicit"},
 							{Name: "Offensive", Value: "Offensive"},
 							{Name: "AIGenerated", Value: "AIGenerated"},
+							{Name: "Deepfake", Value: "Deepfake"},
 						},
 					},
 				},
 			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "preview",
+				Description: "Dry-run an image against proposed thresholds without saving them",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "image_url", Description: "Image URL to analyse", Required: true},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "explicit", Description: "Proposed Explicit Nudity threshold (default: current)", Required: false},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "suggestive", Description: "Proposed Suggestive Nudity threshold (default: current)", Required: false},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "offensive", Description: "Proposed Offensive Content threshold (default: current)", Required: false},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "ai", Description: "Proposed AI Generated threshold (default: current)", Required: false},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "deepfake", Description: "Proposed Deepfake threshold (default: current)", Required: false},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "simulate",
+				Description: "Count how stored analyses would change verdict under a proposed threshold",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "threshold",
+						Description: "Select which threshold to simulate",
+						Required:    true,
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "Suggestive Nudity", Value: "NuditySuggestive"},
+							{Name: "Explicit Nudity", Value: "NudityExplicit"},
+							{Name: "Offensive Content", Value: "Offensive"},
+							{Name: "AI Generated", Value: "AIGenerated"},
+							{Name: "Deepfake", Value: "Deepfake"},
+						},
+					},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "value", Description: "Proposed value: decimal (0.00-1.00) or percentage (0-100%)", Required: true},
+				},
+			},
 		},
-	}); err != nil {
-		log.Fatalf("cannot create command thresholds: %v", err)
-	} else {
-		log.Printf("created command: %s (id=%s)", cmd.Name, cmd.ID)
-	}
+	}})
 
 	// ----------------------------------------
 	// /ai
 	// ----------------------------------------
-	if cmd, err := sess.ApplicationCommandCreate(appID, guildID, &discordgo.ApplicationCommand{
+	specs = append(specs, CommandSpec{Handler: handleAI, Command: &discordgo.ApplicationCommand{
 		Name:        "ai",
 		Description: "Checks an Image URL for AI usage",
 		Options: []*discordgo.ApplicationCommandOption{{
@@ -152,17 +344,18 @@ func registerCommands(sess *discordgo.Session) {
 			Name:        "image_url",
 			Description: "The Image URL to check",
 			Required:    true,
+		}, {
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+			Name:        "advanced",
+			Description: "Advanced mode, shows every AI subscore",
+			Required:    false,
 		}},
-	}); err != nil {
-		log.Fatalf("cannot create command ai: %v", err)
-	} else {
-		log.Printf("created command: %s (id=%s)", cmd.Name, cmd.ID)
-	}
+	}})
 
 	// ----------------------------------------
 	// /reverse
 	// ----------------------------------------
-	if cmd, err := sess.ApplicationCommandCreate(appID, guildID, &discordgo.ApplicationCommand{
+	specs = append(specs, CommandSpec{Handler: handleReverse, Command: &discordgo.ApplicationCommand{
 		Name:        "reverse",
 		Description: "Performs a reverse image search on an Image URL",
 		Options: []*discordgo.ApplicationCommandOption{{
@@ -170,17 +363,23 @@ func registerCommands(sess *discordgo.Session) {
 			Name:        "image_url",
 			Description: "The Image URL to check",
 			Required:    true,
+		}, {
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "region",
+			Description: "Two-letter country code to bias results towards, e.g. us or gb",
+			Required:    false,
+		}, {
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+			Name:        "safe",
+			Description: "Turn the provider's safe search on or off (default: provider setting)",
+			Required:    false,
 		}},
-	}); err != nil {
-		log.Fatalf("cannot create command reverse: %v", err)
-	} else {
-		log.Printf("created command: %s (id=%s)", cmd.Name, cmd.ID)
-	}
+	}})
 
 	// ----------------------------------------
 	// /permissions <add | remove | list>
 	// ----------------------------------------
-	if _, err := sess.ApplicationCommandCreate(appID, guildID, &discordgo.ApplicationCommand{
+	specs = append(specs, CommandSpec{Handler: handlePermissions, Help: "Manage which roles can use moderator-only commands and review changes (owner/admin only)", Command: &discordgo.ApplicationCommand{
 		Name:        "permissions",
 		Description: "Manage roles allowed to use moderator-only commands",
 		Options: []*discordgo.ApplicationCommandOption{
@@ -194,6 +393,16 @@ func registerCommands(sess *discordgo.Session) {
 					Description: "Role to add",
 					Required:    true,
 				}}},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "bulkadd",
+				Description: "Add several moderator roles at once",
+				Options: []*discordgo.ApplicationCommandOption{{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "roles",
+					Description: "Role mentions or IDs, separated by spaces or commas",
+					Required:    true,
+				}}},
 			{
 				Type:        discordgo.ApplicationCommandOptionSubCommand,
 				Name:        "remove",
@@ -209,30 +418,224 @@ func registerCommands(sess *discordgo.Session) {
 				Name:        "list",
 				Description: "List moderator roles allowed to use restricted commands",
 			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "history",
+				Description: "Show recent moderator role changes",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionInteger, Name: "limit", Description: "How many recent changes to show (1-100)", Required: false},
+				},
+			},
 		},
-	}); err != nil {
-		log.Fatalf("cannot create command permissions: %v", err)
+	}})
+
+	// ----------------------------------------
+	// /settings <view | set>
+	// ----------------------------------------
+	settingChoices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(settingSpecs))
+	for _, sp := range settingSpecs {
+		settingChoices = append(settingChoices, &discordgo.ApplicationCommandOptionChoice{Name: sp.Key, Value: sp.Key})
 	}
+	specs = append(specs, CommandSpec{Handler: handleSettings, Help: "Shows or changes this server's bot settings (changes: owner/admin only)", Command: &discordgo.ApplicationCommand{
+		Name:        "settings",
+		Description: "View or change this server's bot settings",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "view",
+				Description: "Show all current settings",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "set",
+				Description: "Change a setting (owner/admin only)",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "key", Description: "Setting to change", Required: true, Choices: settingChoices},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "value", Description: "New value ('none' resets to default)", Required: true},
+				},
+			},
+		},
+	}})
 
 	// ----------------------------------------
-	// Debug list stored commands for the chosen scope
+	// /monitor <add | list | remove>
 	// ----------------------------------------
-	go func() {
-		time.Sleep(2 * time.Second)
+	specs = append(specs, CommandSpec{Handler: handleMonitor, Command: &discordgo.ApplicationCommand{
+		Name:        "monitor",
+		Description: "Re-analyse an image URL on a schedule and alert a channel when it becomes flagged",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "add",
+				Description: "Start monitoring an image URL",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "url", Description: "Direct link to the image", Required: true},
+					{Type: discordgo.ApplicationCommandOptionInteger, Name: "interval", Description: "Minutes between checks (15-10080)", Required: true},
+					{Type: discordgo.ApplicationCommandOptionChannel, Name: "channel", Description: "Channel to alert (default: this channel)", Required: false,
+						ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText}},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "List this server's monitors",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "remove",
+				Description: "Stop a monitor",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionInteger, Name: "id", Description: "Monitor number from /monitor list", Required: true},
+				},
+			},
+		},
+	}})
 
-		listScope := guildID
-		scopeLabel := "global"
-		if guildID != "" {
-			scopeLabel = "guild"
-		}
+	// ----------------------------------------
+	// /history purge
+	// ----------------------------------------
+	specs = append(specs, CommandSpec{Handler: handleHistory, Help: "Deletes this server's threshold history and stored analyses after confirmation (owner/admin only)", Command: &discordgo.ApplicationCommand{
+		Name:        "history",
+		Description: "Manage this server's stored history (owner/admin only)",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "purge",
+				Description: "Delete the threshold change history and stored analyses now",
+			},
+		},
+	}})
 
-		cmds, err := sess.ApplicationCommands(appID, listScope)
-		if err != nil {
-			log.Printf("failed to list %s application commands: %v", scopeLabel, err)
-			return
-		}
-		for _, c := range cmds {
-			log.Printf("discord stored %s command: name=%s id=%s", scopeLabel, c.Name, c.ID)
-		}
-	}()
+	// ----------------------------------------
+	// /reset guild
+	// ----------------------------------------
+	specs = append(specs, CommandSpec{Handler: handleReset, Help: "Clears all permissions, thresholds and settings for this server after confirmation (owner/admin only)", Command: &discordgo.ApplicationCommand{
+		Name:        "reset",
+		Description: "Reset this server's bot configuration (owner/admin only)",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "guild",
+				Description: "Clear all permissions, thresholds and settings for this server",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionBoolean, Name: "include_history", Description: "Also delete the threshold and permission change history and stored analyses", Required: false},
+				},
+			},
+		},
+	}})
+
+	// ----------------------------------------
+	// /models <enable|disable|list>
+	// ----------------------------------------
+	modelChoices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(sightengineModels))
+	for _, m := range sightengineModels {
+		modelChoices = append(modelChoices, &discordgo.ApplicationCommandOptionChoice{Name: m.Label + " (" + m.Name + ")", Value: m.Name})
+	}
+	specs = append(specs, CommandSpec{Handler: handleModels, Help: "Chooses which Sightengine models run for this server, e.g. gore or weapons (owner/admin only)", Command: &discordgo.ApplicationCommand{
+		Name:        "models",
+		Description: "Choose which Sightengine models run for this server (owner/admin only)",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "enable",
+				Description: "Enable a model",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "model", Description: "Model to enable", Required: true, Choices: modelChoices},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "disable",
+				Description: "Disable a model",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "model", Description: "Model to disable", Required: true, Choices: modelChoices},
+				},
+			},
+			{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "list", Description: "Show enabled models"},
+		},
+	}})
+
+	// ----------------------------------------
+	// /usage
+	// ----------------------------------------
+	specs = append(specs, CommandSpec{Handler: handleUsage, Command: &discordgo.ApplicationCommand{
+		Name:        "usage",
+		Description: "Shows this month's analysis usage for this server",
+	}})
+
+	// ----------------------------------------
+	// /guilds [page] (owner only)
+	// ----------------------------------------
+	specs = append(specs, CommandSpec{Handler: handleGuilds, Command: &discordgo.ApplicationCommand{
+		Name:        "guilds",
+		Description: "Lists the servers the bot is in (bot owner only)",
+		Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionInteger, Name: "page", Description: "Page to show (20 servers per page)", Required: false},
+		},
+	}})
+
+	// ----------------------------------------
+	// /diagnostics (bot owner only)
+	// ----------------------------------------
+	specs = append(specs, CommandSpec{Handler: handleDiagnostics, Command: &discordgo.ApplicationCommand{
+		Name:        "diagnostics",
+		Description: "Checks the bot's dependencies and shows cache stats (bot owner only)",
+	}})
+
+	// ----------------------------------------
+	// /commands <enable|disable|list>
+	// ----------------------------------------
+	commandChoices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(toggleableCommands))
+	for _, name := range toggleableCommands {
+		commandChoices = append(commandChoices, &discordgo.ApplicationCommandOptionChoice{Name: "/" + name, Value: name})
+	}
+	specs = append(specs, CommandSpec{Handler: handleCommands, Help: "Turns individual commands on or off for this server (owner/admin only)", Command: &discordgo.ApplicationCommand{
+		Name:        "commands",
+		Description: "Enable or disable commands on this server (owner/admin only)",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "enable",
+				Description: "Enable a command on this server",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "name", Description: "Command to enable", Required: true, Choices: commandChoices},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "disable",
+				Description: "Disable a command on this server",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "name", Description: "Command to disable", Required: true, Choices: commandChoices},
+				},
+			},
+			{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "list", Description: "List disabled commands"},
+		},
+	}})
+
+	return specs
+}
+
+// commandDefinitions returns the desired set of slash commands
+func commandDefinitions() []*discordgo.ApplicationCommand {
+	specs := commandSpecs()
+	defs := make([]*discordgo.ApplicationCommand, 0, len(specs))
+	for _, spec := range specs {
+		defs = append(defs, spec.Command)
+	}
+	return defs
+}
+
+// cleanupGuildCommands deletes the guild-scoped commands registered for GUILD_ID.
+// Global commands are left alone: they are shared by every server and take time to propagate
+func cleanupGuildCommands(sess *discordgo.Session) {
+	guildID := os.Getenv("GUILD_ID")
+	if guildID == "" || sess.State == nil || sess.State.User == nil {
+		return
+	}
+	if _, err := sess.ApplicationCommandBulkOverwrite(sess.State.User.ID, guildID, []*discordgo.ApplicationCommand{}); err != nil {
+		log.Println("failed to clean up guild commands:", err)
+		return
+	}
+	log.Printf("removed guild-scoped commands from guild %s", guildID)
 }
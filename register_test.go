@@ -0,0 +1,109 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// funcName returns the unqualified name of a function value, e.g. "handlePing"
+func funcName(fn any) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+func TestCommandSpecsAreComplete(t *testing.T) {
+	names := map[string]bool{}
+	handlers := map[string]string{}
+	for _, spec := range commandSpecs() {
+		if spec.Command == nil || spec.Command.Name == "" {
+			t.Fatalf("spec without a command: %+v", spec)
+		}
+		name := spec.Command.Name
+		if names[name] {
+			t.Errorf("/%s is registered twice", name)
+		}
+		names[name] = true
+		if spec.Handler == nil {
+			t.Errorf("/%s has no handler", name)
+			continue
+		}
+		fn := funcName(spec.Handler)
+		if other, ok := handlers[fn]; ok {
+			t.Errorf("%s handles both /%s and /%s", fn, other, name)
+		}
+		handlers[fn] = name
+	}
+	for _, name := range toggleableCommands {
+		if !names[name] {
+			t.Errorf("toggleable command /%s is not registered", name)
+		}
+	}
+
+	// Every top-level interaction handler in the package must be wired to a command, apart from
+	// the component handler registerHandlers adds separately
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, path := range files {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range f.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok || fd.Recv != nil || !strings.HasPrefix(fd.Name.Name, "handle") || !isInteractionHandler(fd.Type) {
+				continue
+			}
+			if _, ok := handlers[fd.Name.Name]; !ok && fd.Name.Name != "handleConfirmComponent" {
+				t.Errorf("%s (%s) is not registered for any command", fd.Name.Name, path)
+			}
+		}
+	}
+}
+
+// isInteractionHandler reports whether ft is func(*discordgo.Session, *discordgo.InteractionCreate)
+func isInteractionHandler(ft *ast.FuncType) bool {
+	var params []string
+	for _, field := range ft.Params.List {
+		star, ok := field.Type.(*ast.StarExpr)
+		if !ok {
+			return false
+		}
+		sel, ok := star.X.(*ast.SelectorExpr)
+		if !ok {
+			return false
+		}
+		for range max(len(field.Names), 1) {
+			params = append(params, sel.Sel.Name)
+		}
+	}
+	return ft.Results == nil && slices.Equal(params, []string{"Session", "InteractionCreate"})
+}
+
+func TestCommandHandlersAnswerOnlyTheirCommand(t *testing.T) {
+	t.Setenv("OWNER_ID", "owner")
+	t.Setenv("EXTRA_OWNER_IDS", "")
+	specs := commandSpecs()
+	for _, spec := range specs {
+		for _, other := range specs {
+			rt := &recordingTransport{}
+			spec.Handler(offlineSession(t, rt), testCommand(other.Command.Name))
+			answered := rt.count() > 0
+			if own := other.Command.Name == spec.Command.Name; answered != own {
+				t.Errorf("handler of /%s answered /%s: %v, want %v", spec.Command.Name, other.Command.Name, answered, own)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// History retention: threshold change history and stored analyses older than historyRetention
+// are deleted by the sweeper, which runs every historySweepInterval
+const (
+	defaultHistoryRetention = 90 * 24 * time.Hour
+	historySweepInterval    = 6 * time.Hour
+)
+
+// historyRetention is how long history rows are kept (HISTORY_RETENTION_DAYS, default 90 days)
+var historyRetention = defaultHistoryRetention
+
+// configureHistoryRetention reads HISTORY_RETENTION_DAYS; invalid values keep the default
+func configureHistoryRetention() {
+	raw := strings.TrimSpace(os.Getenv("HISTORY_RETENTION_DAYS"))
+	if raw == "" {
+		return
+	}
+	days, err := strconv.Atoi(raw)
+	if err != nil || days <= 0 {
+		log.Printf("invalid HISTORY_RETENTION_DAYS %q, keeping %d days", raw, historyRetentionDays())
+		return
+	}
+	historyRetention = time.Duration(days) * 24 * time.Hour
+	log.Printf("history retention: %d days", days)
+}
+
+// historyRetentionDays is historyRetention in whole days, for messages
+func historyRetentionDays() int {
+	return int(historyRetention / (24 * time.Hour))
+}
+
+// startHistorySweeper deletes expired history rows now and then every historySweepInterval.
+// Without a DB there is nothing to sweep (in-memory analyses are capped per guild instead)
+func startHistorySweeper(ps *PermStore) {
+	if ps == nil || ps.db == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(historySweepInterval)
+		defer ticker.Stop()
+		for {
+			if err := sweepHistory(ps, time.Now().UTC().Add(-historyRetention)); err != nil {
+				log.Println("history sweep error:", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// sweepHistory deletes thresholds_history and analysis_history rows created before cutoff, across
+// all guilds. A failure on one table doesn't stop the other
+func sweepHistory(ps *PermStore, cutoff time.Time) error {
+	if ps == nil || ps.db == nil {
+		return nil
+	}
+	var failed []string
+	for _, table := range []string{"thresholds_history", "analysis_history"} {
+		res, err := ps.db.Exec(`DELETE FROM `+table+` WHERE created_at < `+thresholdsStore.param(ps, 1), cutoff)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", table, err))
+			continue
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			log.Printf("history sweep: deleted %d expired row(s) from %s", n, table)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("sweep %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// purgeGuildHistory immediately deletes a guild's threshold change history and stored analyses,
+// returning the parts that failed
+func purgeGuildHistory(guildID string) []string {
+	var failed []string
+	if err := thresholdsStore.ClearGuildHistory(perms, guildID); err != nil {
+		log.Println("purge guild threshold history error:", err)
+		failed = append(failed, "threshold history")
+	}
+	if err := analysisHistory.ClearGuild(guildID); err != nil {
+		log.Println("purge guild analysis history error:", err)
+		failed = append(failed, "analysis history")
+	}
+	return failed
+}
@@ -2,15 +2,25 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// Reverse API pacing defaults: REVERSE_MIN_INTERVAL_MS between calls, and how long a caller
+// waits in the queue before its queue notice runs
+const (
+	defaultReverseMinInterval = time.Second
+	reverseQueueNoticeAfter   = 2 * time.Second
+)
+
 // ReverseAPIClient is a lightweight HTTP client for the
 // github.com/SOME-1HING/google-reverse-image-api service.
 //
@@ -19,15 +29,51 @@ import (
 // - REVERSE_API_BASE: Base URL; if REVERSE_API_URL not set, uses BASE + "/reverse"
 // - REVERSE_API_KEY:  Optional API key (sent as Authorization: Bearer <key>)
 // - REVERSE_API_TIMEOUT: Optional request timeout in seconds (default: 30)
+// - REVERSE_MIN_INTERVAL_MS: Optional minimum gap between calls in milliseconds (default: 1000)
 //
-// The client performs a POST with JSON body: {"imageUrl": "<image URL>"}
-// and returns raw JSON data (map[string]any) for maximum flexibility.
+// The client performs a POST with JSON body: {"imageUrl": "<image URL>"} (plus "region" and
+// "safe" when ReverseOptions sets them) and returns raw JSON data (map[string]any) for maximum flexibility.
 type ReverseAPIClient struct {
 	Endpoint string
 	APIKey   string
 	Client   *http.Client
 }
 
+// ReverseOptions describes one reverse image lookup. Region and Safe are optional hints that
+// bias the provider's results; left empty/nil they are omitted so the provider's defaults apply
+type ReverseOptions struct {
+	ImageURL string
+	Region   string // lower-case ISO 3166-1 alpha-2 country code, e.g. "gb"
+	Safe     *bool  // explicitly enable or disable safe search
+}
+
+// reverseRegionRe matches a two-letter country code
+var reverseRegionRe = regexp.MustCompile(`^[a-z]{2}$`)
+
+// parseReverseRegion validates a region hint, returning it lower-cased. Empty input means no hint
+func parseReverseRegion(in string) (string, error) {
+	region := strings.ToLower(strings.TrimSpace(in))
+	if region == "" {
+		return "", nil
+	}
+	if !reverseRegionRe.MatchString(region) {
+		return "", fmt.Errorf("region must be a two-letter country code such as `us` or `gb`")
+	}
+	return region, nil
+}
+
+// payload builds the request body for the reverse API
+func (o ReverseOptions) payload() map[string]any {
+	payload := map[string]any{"imageUrl": o.ImageURL}
+	if o.Region != "" {
+		payload["region"] = o.Region
+	}
+	if o.Safe != nil {
+		payload["safe"] = *o.Safe
+	}
+	return payload
+}
+
 // NewReverseAPIClient builds a client from environment variables
 func NewReverseAPIClient() (*ReverseAPIClient, error) {
 	endpoint := strings.TrimSpace(os.Getenv("REVERSE_API_URL"))
@@ -56,25 +102,31 @@ func NewReverseAPIClient() (*ReverseAPIClient, error) {
 
 // ReverseSearch submits an image URL to the reverse image API and returns
 // the raw JSON response. This helper uses a client created from environment vars.
-func ReverseSearch(imageURL string) (map[string]any, error) {
+func ReverseSearch(ctx context.Context, opts ReverseOptions) (map[string]any, error) {
 	cli, err := NewReverseAPIClient()
 	if err != nil {
 		return nil, err
 	}
-	return cli.ReverseSearch(imageURL)
+	return cli.ReverseSearch(ctx, opts)
 }
 
 // ReverseSearch performs the reverse image lookup using POST only.
 // Matches the example in example.py:
 //
 //	POST {endpoint}
-//	Body: {"imageUrl": "<image URL>"}
-func (c *ReverseAPIClient) ReverseSearch(imageURL string) (map[string]any, error) {
-	if strings.TrimSpace(imageURL) == "" {
+//	Body: {"imageUrl": "<image URL>", "region": "<code>", "safe": <bool>}
+//
+// region and safe are only sent when set
+func (c *ReverseAPIClient) ReverseSearch(ctx context.Context, opts ReverseOptions) (map[string]any, error) {
+	if strings.TrimSpace(opts.ImageURL) == "" {
 		return nil, fmt.Errorf("imageURL is empty")
 	}
-	payload := map[string]any{"imageUrl": imageURL}
-	data, status, err := c.postJSON(c.Endpoint, payload)
+	release, err := reverseThrottle.acquire(ctx, reverseMinInterval())
+	if err != nil {
+		return nil, fmt.Errorf("reverse search: %w", err)
+	}
+	defer release()
+	data, status, err := c.postJSON(ctx, c.Endpoint, opts.payload())
 	if err != nil {
 		return nil, fmt.Errorf("reverse search failed: %w", err)
 	}
@@ -85,12 +137,12 @@ func (c *ReverseAPIClient) ReverseSearch(imageURL string) (map[string]any, error
 }
 
 // postJSON performs a POST with JSON payload and decodes JSON response into a generic map
-func (c *ReverseAPIClient) postJSON(u string, payload map[string]any) (map[string]any, int, error) {
+func (c *ReverseAPIClient) postJSON(ctx context.Context, u string, payload map[string]any) (map[string]any, int, error) {
 	b, err := json.Marshal(payload)
 	if err != nil {
 		return nil, 0, fmt.Errorf("encode json: %w", err)
 	}
-	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(b))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(b))
 	if err != nil {
 		return nil, 0, fmt.Errorf("build request: %w", err)
 	}
@@ -122,3 +174,68 @@ func (c *ReverseAPIClient) postJSON(u string, payload map[string]any) (map[strin
 	}
 	return out, resp.StatusCode, nil
 }
+
+// reverseMinInterval returns REVERSE_MIN_INTERVAL_MS, the minimum gap between reverse calls
+func reverseMinInterval() time.Duration {
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("REVERSE_MIN_INTERVAL_MS"))); err == nil && n >= 0 {
+		return time.Duration(n) * time.Millisecond
+	}
+	return defaultReverseMinInterval
+}
+
+// requestThrottle lets one caller at a time through, first come first served, spacing the
+// start of consecutive calls by at least the requested interval
+type requestThrottle struct {
+	turn chan struct{} // holds a token while a caller has its turn; blocked senders are served in order
+	last time.Time     // start of the previous call; only touched while holding the turn
+}
+
+// reverseThrottle paces every reverse API call made by this process
+var reverseThrottle = &requestThrottle{turn: make(chan struct{}, 1)}
+
+type reverseQueueNoticeKey struct{}
+
+// withReverseQueueNotice returns a context whose reverse calls run notice once (on the calling
+// goroutine) if they wait longer than reverseQueueNoticeAfter for their turn
+func withReverseQueueNotice(ctx context.Context, notice func()) context.Context {
+	return context.WithValue(ctx, reverseQueueNoticeKey{}, notice)
+}
+
+// acquire waits for the caller's turn and for interval to pass since the previous call.
+// The returned func ends the turn and must be called once the request has finished
+func (t *requestThrottle) acquire(ctx context.Context, interval time.Duration) (func(), error) {
+	notice, _ := ctx.Value(reverseQueueNoticeKey{}).(func())
+	var noticeC <-chan time.Time
+	if notice != nil {
+		nt := time.NewTimer(reverseQueueNoticeAfter)
+		defer nt.Stop()
+		noticeC = nt.C
+	}
+
+	for queued := true; queued; {
+		select {
+		case t.turn <- struct{}{}:
+			queued = false
+		case <-noticeC:
+			notice()
+			noticeC = nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	wait := time.NewTimer(time.Until(t.last.Add(interval)))
+	defer wait.Stop()
+	for {
+		select {
+		case <-wait.C:
+			t.last = time.Now()
+			return func() { <-t.turn }, nil
+		case <-noticeC:
+			notice()
+			noticeC = nil
+		case <-ctx.Done():
+			<-t.turn
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 )
@@ -20,6 +21,12 @@ type ReverseResult struct {
 	SimilarURL string
 	// ResultText is a short description string returned by the API.
 	ResultText string
+	// BestGuess is Google's "best guess" label for the image (data.bestGuess,
+	// falling back to data.description); empty when the API omits it.
+	BestGuess string
+	// Thumbnail is a preview image URL for the match (data.thumbnail); empty
+	// when the API omits it.
+	Thumbnail string
 }
 
 // AsReverseResultRaw converts a generic decoded JSON object (map[string]any)
@@ -56,13 +63,18 @@ func AsReverseResultRaw(raw map[string]any) (*ReverseResult, error) {
 	data := getMap(raw, "data")
 	res.SimilarURL = getString(data, "similarUrl")
 	res.ResultText = getString(data, "resultText")
+	res.BestGuess = getString(data, "bestGuess")
+	if res.BestGuess == "" {
+		res.BestGuess = getString(data, "description")
+	}
+	res.Thumbnail = getString(data, "thumbnail")
 	return res, nil
 }
 
 // ReverseLookup is a convenience that runs the network call via ReverseSearch
 // and returns the normalised ReverseResult ready for higher-level use
-func ReverseLookup(imageURL string) (*ReverseResult, error) {
-	raw, err := ReverseSearch(imageURL)
+func ReverseLookup(ctx context.Context, opts ReverseOptions) (*ReverseResult, error) {
+	raw, err := ReverseSearch(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -74,5 +86,5 @@ func (r *ReverseResult) String() string {
 	if r == nil {
 		return "<nil>"
 	}
-	return fmt.Sprintf("success=%t message=%q similarUrl=%q resultText=%q", r.Success, r.Message, r.SimilarURL, r.ResultText)
+	return fmt.Sprintf("success=%t message=%q similarUrl=%q resultText=%q bestGuess=%q thumbnail=%q", r.Success, r.Message, r.SimilarURL, r.ResultText, r.BestGuess, r.Thumbnail)
 }
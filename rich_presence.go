@@ -2,21 +2,66 @@ package main
 
 import (
 	"log"
+	"os"
+	"strings"
 
 	"github.com/bwmarrin/discordgo"
 )
 
-// Rich Presence:
-// - Activity Type: Watching
-// - Name: "ChiefXD"
+// PresenceConfig describes the bot's Rich Presence
+// - Name: activity text (default "ChiefXD")
+// - Type: playing | streaming | listening | watching | competing (default watching)
+// - URL: stream URL, only used with the streaming type
+type PresenceConfig struct {
+	Name string
+	Type string
+	URL  string
+}
+
+// defaultPresence is used when no PRESENCE_* overrides are set
+var defaultPresence = PresenceConfig{Name: "ChiefXD", Type: "watching"}
+
+// presenceConfigFromEnv reads PRESENCE_NAME, PRESENCE_TYPE and PRESENCE_URL over the defaults
+func presenceConfigFromEnv() PresenceConfig {
+	cfg := defaultPresence
+	if v := strings.TrimSpace(os.Getenv("PRESENCE_NAME")); v != "" {
+		cfg.Name = v
+	}
+	if v := strings.TrimSpace(os.Getenv("PRESENCE_TYPE")); v != "" {
+		cfg.Type = v
+	}
+	cfg.URL = strings.TrimSpace(os.Getenv("PRESENCE_URL"))
+	return cfg
+}
+
+// BuildActivity converts a PresenceConfig into a Discord activity without side effects.
+// Unknown types fall back to watching; an empty name falls back to the default
+func BuildActivity(cfg PresenceConfig) *discordgo.Activity {
+	name := strings.TrimSpace(cfg.Name)
+	if name == "" {
+		name = defaultPresence.Name
+	}
+	act := &discordgo.Activity{Name: name, Type: discordgo.ActivityTypeWatching}
+	switch strings.ToLower(strings.TrimSpace(cfg.Type)) {
+	case "playing", "game":
+		act.Type = discordgo.ActivityTypeGame
+	case "streaming":
+		if cfg.URL != "" {
+			act.Type = discordgo.ActivityTypeStreaming
+			act.URL = cfg.URL
+		}
+	case "listening":
+		act.Type = discordgo.ActivityTypeListening
+	case "competing":
+		act.Type = discordgo.ActivityTypeCompeting
+	}
+	return act
+}
+
+// onReadySetPresence applies the configured Rich Presence on READY
 func onReadySetPresence(s *discordgo.Session, _ *discordgo.Ready) {
 	if err := s.UpdateStatusComplex(discordgo.UpdateStatusData{
-		Activities: []*discordgo.Activity{
-			{
-				Name: "ChiefXD",
-				Type: discordgo.ActivityTypeWatching,
-			},
-		},
+		Activities: []*discordgo.Activity{BuildActivity(presenceConfigFromEnv())},
 	}); err != nil {
 		log.Println("failed to set rich presence:", err)
 	}
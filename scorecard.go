@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"slices"
+	"strings"
+)
+
+// Scorecard layout, in pixels. Text is drawn with scorecardFont scaled by scorecardTextScale
+const (
+	scorecardWidth     = 320
+	scorecardHeader    = 30
+	scorecardRow       = 38
+	scorecardMargin    = 10
+	scorecardBarWidth  = 240
+	scorecardBarHeight = 12
+	scorecardTextScale = 2
+)
+
+// Scorecard colours: verdict colours match the analysis embeds
+var (
+	scorecardBackground = color.RGBA{0x2B, 0x2D, 0x31, 0xFF}
+	scorecardTrack      = color.RGBA{0x4E, 0x50, 0x58, 0xFF}
+	scorecardText       = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	scorecardSafe       = color.RGBA{0x4C, 0xAF, 0x50, 0xFF}
+	scorecardFlagged    = color.RGBA{0xE5, 0x39, 0x35, 0xFF}
+)
+
+// scorecardFont is a bundled 5x7 bitmap font covering the scorecard's labels. Each glyph is seven
+// rows, top first; bit 4 of a row is the leftmost pixel. Unknown runes render as blanks
+var scorecardFont = map[rune][7]uint8{
+	'A': {0b01110, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'B': {0b11110, 0b10001, 0b10001, 0b11110, 0b10001, 0b10001, 0b11110},
+	'C': {0b01110, 0b10001, 0b10000, 0b10000, 0b10000, 0b10001, 0b01110},
+	'D': {0b11110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b11110},
+	'E': {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b11111},
+	'F': {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b10000},
+	'G': {0b01110, 0b10001, 0b10000, 0b10111, 0b10001, 0b10001, 0b01111},
+	'H': {0b10001, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'I': {0b01110, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'J': {0b00111, 0b00010, 0b00010, 0b00010, 0b00010, 0b10010, 0b01100},
+	'K': {0b10001, 0b10010, 0b10100, 0b11000, 0b10100, 0b10010, 0b10001},
+	'L': {0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b11111},
+	'M': {0b10001, 0b11011, 0b10101, 0b10101, 0b10001, 0b10001, 0b10001},
+	'N': {0b10001, 0b10001, 0b11001, 0b10101, 0b10011, 0b10001, 0b10001},
+	'O': {0b01110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'P': {0b11110, 0b10001, 0b10001, 0b11110, 0b10000, 0b10000, 0b10000},
+	'Q': {0b01110, 0b10001, 0b10001, 0b10001, 0b10101, 0b10010, 0b01101},
+	'R': {0b11110, 0b10001, 0b10001, 0b11110, 0b10100, 0b10010, 0b10001},
+	'S': {0b01111, 0b10000, 0b10000, 0b01110, 0b00001, 0b00001, 0b11110},
+	'T': {0b11111, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100},
+	'U': {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'V': {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01010, 0b00100},
+	'W': {0b10001, 0b10001, 0b10001, 0b10101, 0b10101, 0b10101, 0b01010},
+	'X': {0b10001, 0b10001, 0b01010, 0b00100, 0b01010, 0b10001, 0b10001},
+	'Y': {0b10001, 0b10001, 0b01010, 0b00100, 0b00100, 0b00100, 0b00100},
+	'Z': {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b10000, 0b11111},
+	'0': {0b01110, 0b10001, 0b10011, 0b10101, 0b11001, 0b10001, 0b01110},
+	'1': {0b00100, 0b01100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'2': {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b01000, 0b11111},
+	'3': {0b11111, 0b00010, 0b00100, 0b00010, 0b00001, 0b10001, 0b01110},
+	'4': {0b00010, 0b00110, 0b01010, 0b10010, 0b11111, 0b00010, 0b00010},
+	'5': {0b11111, 0b10000, 0b11110, 0b00001, 0b00001, 0b10001, 0b01110},
+	'6': {0b00110, 0b01000, 0b10000, 0b11110, 0b10001, 0b10001, 0b01110},
+	'7': {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b01000, 0b01000},
+	'8': {0b01110, 0b10001, 0b10001, 0b01110, 0b10001, 0b10001, 0b01110},
+	'9': {0b01110, 0b10001, 0b10001, 0b01111, 0b00001, 0b00010, 0b01100},
+	'%': {0b11000, 0b11001, 0b00010, 0b00100, 0b01000, 0b10011, 0b00011},
+	'.': {0b00000, 0b00000, 0b00000, 0b00000, 0b00000, 0b01100, 0b01100},
+	'-': {0b00000, 0b00000, 0b00000, 0b11111, 0b00000, 0b00000, 0b00000},
+	':': {0b00000, 0b01100, 0b01100, 0b00000, 0b01100, 0b01100, 0b00000},
+}
+
+// scorecardFile is the attachment name of the scorecard, referenced from embeds as attachment://
+const scorecardFile = "scorecard.png"
+
+// renderScorecard draws a PNG summary of a standard analysis: the verdict as a coloured header,
+// then one bar per category. Bars of categories that tripped their threshold are red, others green
+func renderScorecard(a *Analysis) ([]byte, error) {
+	if a == nil {
+		return nil, errors.New("render scorecard: nil analysis")
+	}
+	type row struct {
+		Label  string
+		Reason string
+		Model  string
+		Score  float64
+	}
+	var rows []row
+	for _, r := range []row{
+		{"EXPLICIT NUDITY", "nudity_explicit", "nudity-2.1", a.Scores.NudityExplicit},
+		{"SUGGESTIVE NUDITY", "nudity_suggestive", "nudity-2.1", a.Scores.NuditySuggestive},
+		{"OFFENSIVE", "offensive_symbols", "offensive-2.0", a.Scores.Offensive},
+		{"AI GENERATED", "ai_generated_high", "genai", a.Scores.AIGenerated},
+		{"DEEPFAKE", "deepfake_detected", "genai", a.Scores.Deepfake},
+	} {
+		// Categories of models that weren't requested have no score to draw
+		if a.Scored(r.Model) {
+			rows = append(rows, r)
+		}
+	}
+	height := scorecardHeader + scorecardMargin + len(rows)*scorecardRow
+	img := image.NewRGBA(image.Rect(0, 0, scorecardWidth, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(scorecardBackground), image.Point{}, draw.Src)
+
+	verdict, verdictColour := "FLAGGED", scorecardFlagged
+	if a.Allowed {
+		verdict, verdictColour = "ALLOWED", scorecardSafe
+	}
+	fillRect(img, image.Rect(0, 0, scorecardWidth, scorecardHeader), verdictColour)
+	drawScorecardText(img, scorecardMargin, (scorecardHeader-7*scorecardTextScale)/2, "VERDICT: "+verdict)
+
+	for n, r := range rows {
+		y := scorecardHeader + scorecardMargin + n*scorecardRow
+		drawScorecardText(img, scorecardMargin, y, r.Label)
+		barY := y + 7*scorecardTextScale + 4
+		fillRect(img, image.Rect(scorecardMargin, barY, scorecardMargin+scorecardBarWidth, barY+scorecardBarHeight), scorecardTrack)
+		fill := int(min(max(r.Score, 0), 1) * scorecardBarWidth)
+		barColour := scorecardSafe
+		if slices.Contains(a.Reasons, r.Reason) {
+			barColour = scorecardFlagged
+		}
+		fillRect(img, image.Rect(scorecardMargin, barY, scorecardMargin+fill, barY+scorecardBarHeight), barColour)
+		drawScorecardText(img, scorecardMargin+scorecardBarWidth+8, barY-1, fmt.Sprintf("%.0f%%", r.Score*100))
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("render scorecard: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// fillRect paints r in a solid colour
+func fillRect(img *image.RGBA, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+// drawScorecardText writes s with its top-left corner at (x, y) using scorecardFont
+func drawScorecardText(img *image.RGBA, x, y int, s string) {
+	const cell = 6 * scorecardTextScale // 5px glyph plus 1px spacing
+	for n, ch := range []rune(strings.ToUpper(s)) {
+		glyph, ok := scorecardFont[ch]
+		if !ok {
+			continue
+		}
+		gx := x + n*cell
+		for row, bits := range glyph {
+			for col := range 5 {
+				if bits&(1<<(4-col)) == 0 {
+					continue
+				}
+				px, py := gx+col*scorecardTextScale, y+row*scorecardTextScale
+				fillRect(img, image.Rect(px, py, px+scorecardTextScale, py+scorecardTextScale), scorecardText)
+			}
+		}
+	}
+}
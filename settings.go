@@ -0,0 +1,630 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GuildSettings is the typed view of a guild's configuration
+type GuildSettings struct {
+	// LogChannelID receives audit messages (threshold changes etc.); empty = LOG_CHANNEL_ID fallback
+	LogChannelID string
+	// SuggestiveAggregation combines the suggestive nudity subscores (mean or max); empty = mean
+	SuggestiveAggregation SuggestiveAggregation
+	// MinReasons is the number of tripped categories needed to flag an image; 0 = default (1)
+	MinReasons int
+	// ShowAllowedRoles lists the moderator roles in "no permission" replies
+	ShowAllowedRoles bool
+	// DelegatedOwners are user IDs trusted to manage the bot in this guild like admins
+	DelegatedOwners []string
+	// AnalysisHistory stores the scores of standard analyses for /thresholds simulate
+	AnalysisHistory bool
+	// SkipSmallImages makes /analyse skip images below MIN_IMAGE_DIMENSION / MIN_IMAGE_BYTES
+	SkipSmallImages bool
+	// HideFlaggedURL keeps flagged images' URLs (and previews) out of analysis replies (echo_flagged_url off)
+	HideFlaggedURL bool
+	// TextLanguages are the language codes sent to Sightengine's text model; empty = en
+	TextLanguages []string
+	// CommandCooldowns is the guild-wide wait between invocations of expensive commands; absent = none
+	CommandCooldowns map[string]time.Duration
+}
+
+// settingSpec describes a single configurable key
+// - Key: name used by /settings set and the guild_settings table
+// - Description: shown in /settings view
+// - Normalise: validates user input and returns the stored form ("" clears the setting)
+// - Apply: copies a stored value onto the typed struct
+// - Format: optional display form for /settings view
+type settingSpec struct {
+	Key         string
+	Description string
+	Normalise   func(in string) (string, error)
+	Apply       func(gs *GuildSettings, v string)
+	Format      func(v string) string
+}
+
+var channelMentionRe = regexp.MustCompile(`^<#(\d+)>$`)
+var snowflakeRe = regexp.MustCompile(`^\d{5,25}$`)
+var userMentionRe = regexp.MustCompile(`^<@!?(\d+)>$`)
+var roleMentionRe = regexp.MustCompile(`^<@&(\d+)>$`)
+
+// settingSpecs is the registry of per-guild settings; add new keys here
+var settingSpecs = []settingSpec{
+	{
+		Key:         "log_channel",
+		Description: "Channel that receives audit messages (mention, ID, or 'none')",
+		Normalise:   normaliseChannelSetting,
+		Apply:       func(gs *GuildSettings, v string) { gs.LogChannelID = v },
+		Format:      func(v string) string { return "<#" + v + ">" },
+	},
+	{
+		Key:         "suggestive_mode",
+		Description: "How suggestive nudity subscores are combined: mean (default) or max",
+		Normalise:   normaliseSuggestiveMode,
+		Apply:       func(gs *GuildSettings, v string) { gs.SuggestiveAggregation = SuggestiveAggregation(v) },
+	},
+	{
+		Key:         "min_reasons",
+		Description: "Number of categories that must exceed their threshold to flag an image (1-5, default 1)",
+		Normalise:   normaliseMinReasons,
+		Apply: func(gs *GuildSettings, v string) {
+			if n, err := strconv.Atoi(v); err == nil {
+				gs.MinReasons = n
+			}
+		},
+	},
+	{
+		Key:         "show_allowed_roles",
+		Description: "List the moderator roles when someone is denied a restricted command (on/off, default off)",
+		Normalise:   normaliseBoolSetting,
+		Apply:       func(gs *GuildSettings, v string) { gs.ShowAllowedRoles = v == "on" },
+	},
+	{
+		Key:         "owners",
+		Description: "Users who can manage the bot here like server admins (mentions or IDs, comma-separated, or 'none')",
+		Normalise:   normaliseUserList,
+		Apply:       func(gs *GuildSettings, v string) { gs.DelegatedOwners = splitCSV(v) },
+		Format: func(v string) string {
+			ids := splitCSV(v)
+			for n, id := range ids {
+				ids[n] = "<@" + id + ">"
+			}
+			return strings.Join(ids, ", ")
+		},
+	},
+	{
+		Key:         "analysis_history",
+		Description: "Keep image URLs and scores of analyses (HISTORY_RETENTION_DAYS, default 90) so /thresholds simulate can replay them (on/off, default off)",
+		Normalise:   normaliseBoolSetting,
+		Apply:       func(gs *GuildSettings, v string) { gs.AnalysisHistory = v == "on" },
+	},
+	{
+		Key:         "skip_small_images",
+		Description: "Skip /analyse for images smaller than the bot's minimum size, e.g. emoji (on/off, default off)",
+		Normalise:   normaliseBoolSetting,
+		Apply:       func(gs *GuildSettings, v string) { gs.SkipSmallImages = v == "on" },
+	},
+	{
+		Key:         "echo_flagged_url",
+		Description: "Repeat a flagged image's URL and preview in analysis replies (on/off, default on)",
+		Normalise:   normaliseDefaultOnSetting,
+		Apply:       func(gs *GuildSettings, v string) { gs.HideFlaggedURL = v == "off" },
+	},
+	{
+		Key:         "text_languages",
+		Description: "Languages for text moderation, comma-separated codes like en,fr (default en)",
+		Normalise:   normaliseTextLanguages,
+		Apply:       func(gs *GuildSettings, v string) { gs.TextLanguages = splitCSV(v) },
+	},
+	{
+		Key:         "command_cooldowns",
+		Description: "Server-wide wait between uses of /analyse, /ai or /reverse, e.g. analyse=30,reverse=60 (seconds)",
+		Normalise:   normaliseCommandCooldowns,
+		Apply:       func(gs *GuildSettings, v string) { gs.CommandCooldowns = parseCommandCooldowns(v) },
+		Format:      formatCommandCooldowns,
+	},
+}
+
+// findSettingSpec looks up a setting by key (case-insensitive)
+func findSettingSpec(key string) (settingSpec, bool) {
+	key = strings.ToLower(strings.TrimSpace(key))
+	for _, sp := range settingSpecs {
+		if sp.Key == key {
+			return sp, true
+		}
+	}
+	return settingSpec{}, false
+}
+
+// normaliseChannelSetting accepts <#id>, a raw ID, or none/off to clear
+func normaliseChannelSetting(in string) (string, error) {
+	s := strings.TrimSpace(in)
+	if isClearValue(s) {
+		return "", nil
+	}
+	if m := channelMentionRe.FindStringSubmatch(s); m != nil {
+		return m[1], nil
+	}
+	if snowflakeRe.MatchString(s) {
+		return s, nil
+	}
+	return "", fmt.Errorf("expected a channel mention or ID")
+}
+
+// normaliseSuggestiveMode accepts mean or max; mean is stored as unset since it is the default
+func normaliseSuggestiveMode(in string) (string, error) {
+	s := strings.ToLower(strings.TrimSpace(in))
+	if isClearValue(s) {
+		return "", nil
+	}
+	switch SuggestiveAggregation(s) {
+	case SuggestiveMean:
+		return "", nil
+	case SuggestiveMax:
+		return s, nil
+	}
+	return "", fmt.Errorf("expected mean or max")
+}
+
+// normaliseMinReasons accepts 1..len(thresholdNames); 1 is stored as unset since it is the default
+func normaliseMinReasons(in string) (string, error) {
+	s := strings.TrimSpace(in)
+	if isClearValue(s) {
+		return "", nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > len(thresholdNames) {
+		return "", fmt.Errorf("expected a whole number between 1 and %d", len(thresholdNames))
+	}
+	if n == 1 {
+		return "", nil
+	}
+	return s, nil
+}
+
+// normaliseUserList accepts a comma/space separated list of user mentions or IDs, or none to clear
+func normaliseUserList(in string) (string, error) {
+	s := strings.TrimSpace(in)
+	if isClearValue(s) {
+		return "", nil
+	}
+	var ids []string
+	for _, tok := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if m := userMentionRe.FindStringSubmatch(tok); m != nil {
+			tok = m[1]
+		}
+		if !snowflakeRe.MatchString(tok) {
+			return "", fmt.Errorf("expected user mentions or IDs, got %q", tok)
+		}
+		if !slices.Contains(ids, tok) {
+			ids = append(ids, tok)
+		}
+	}
+	return strings.Join(ids, ","), nil
+}
+
+// normaliseBoolSetting accepts on/off style input; off is stored as unset since it is the default
+func normaliseBoolSetting(in string) (string, error) {
+	s := strings.ToLower(strings.TrimSpace(in))
+	if isClearValue(s) {
+		return "", nil
+	}
+	switch s {
+	case "on", "true", "yes", "enable", "enabled":
+		return "on", nil
+	case "false", "no", "disable", "disabled":
+		return "", nil
+	}
+	return "", fmt.Errorf("expected on or off")
+}
+
+// normaliseDefaultOnSetting is normaliseBoolSetting for settings that default to on:
+// only "off" is stored, and on/none/default clear the setting
+func normaliseDefaultOnSetting(in string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(in)) {
+	case "off", "false", "no", "disable", "disabled":
+		return "off", nil
+	case "", "none", "default", "clear", "on", "true", "yes", "enable", "enabled":
+		return "", nil
+	}
+	return "", fmt.Errorf("expected on or off")
+}
+
+// normaliseTextLanguages accepts a comma-separated list of Sightengine text language codes;
+// duplicates are dropped and plain "en" is stored as unset since it is the default
+func normaliseTextLanguages(in string) (string, error) {
+	if isClearValue(in) {
+		return "", nil
+	}
+	var codes []string
+	for _, c := range splitCSV(strings.ToLower(in)) {
+		if !slices.Contains(sightengineTextLanguages, c) {
+			return "", fmt.Errorf("unsupported language %q; use one or more of %s", c, strings.Join(sightengineTextLanguages, ", "))
+		}
+		if !slices.Contains(codes, c) {
+			codes = append(codes, c)
+		}
+	}
+	if len(codes) == 0 || (len(codes) == 1 && codes[0] == "en") {
+		return "", nil
+	}
+	return strings.Join(codes, ","), nil
+}
+
+// isClearValue reports whether the input means "unset this setting"
+func isClearValue(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "none", "off", "default", "clear":
+		return true
+	}
+	return false
+}
+
+// GuildSettingsStore persists per-guild settings in the guild_settings table when a DB is
+// configured; otherwise values are kept in memory only
+type GuildSettingsStore struct {
+	mu    sync.RWMutex
+	ps    *PermStore
+	cache map[string]map[string]string // guildID -> key -> value
+	// writes counts setRaw and ClearGuild calls; a load that raced with one is not cached
+	writes uint64
+}
+
+var settingsStore = &GuildSettingsStore{cache: make(map[string]map[string]string)}
+
+// Init creates the guild_settings table if a DB is available
+func (gs *GuildSettingsStore) Init(ps *PermStore) error {
+	gs.mu.Lock()
+	gs.ps = ps
+	gs.mu.Unlock()
+	if ps == nil || ps.db == nil {
+		return nil
+	}
+	var ddl string
+	switch ps.dialect {
+	case DialectPostgres:
+		ddl = `CREATE TABLE IF NOT EXISTS guild_settings (
+			guild_id TEXT NOT NULL,
+			key      TEXT NOT NULL,
+			value    TEXT NOT NULL,
+			PRIMARY KEY (guild_id, key)
+		)`
+	case DialectMySQL:
+		ddl = `CREATE TABLE IF NOT EXISTS guild_settings (
+			guild_id VARCHAR(64) NOT NULL,
+			` + "`key`" + ` VARCHAR(64) NOT NULL,
+			value    TEXT NOT NULL,
+			PRIMARY KEY (guild_id, ` + "`key`" + `)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+	default:
+		return fmt.Errorf("unsupported dialect: %s", ps.dialect)
+	}
+	if _, err := ps.db.Exec(ddl); err != nil {
+		return fmt.Errorf("create guild_settings table: %w", err)
+	}
+	return nil
+}
+
+// raw returns the stored key/value pairs for a guild, loading from DB on first access. A failed
+// load is returned rather than read as "no settings" and isn't cached, so the next call retries
+func (gs *GuildSettingsStore) raw(guildID string) (map[string]string, error) {
+	gs.mu.RLock()
+	m, ok := gs.cache[guildID]
+	ps, writes := gs.ps, gs.writes
+	gs.mu.RUnlock()
+	if ok {
+		return m, nil
+	}
+
+	m = make(map[string]string)
+	persisted := ps != nil && ps.db != nil
+	if persisted {
+		if err := gs.load(ps, guildID, m); err != nil {
+			return nil, fmt.Errorf("load guild settings: %w", err)
+		}
+	}
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if cur, ok := gs.cache[guildID]; ok {
+		return cur, nil
+	}
+	// A setRaw or ClearGuild since the read may have changed the rows; use them once, but let
+	// the next call load the current values
+	if !persisted || gs.writes == writes {
+		gs.cache[guildID] = m
+	}
+	return m, nil
+}
+
+// load reads a guild's stored settings into m
+func (gs *GuildSettingsStore) load(ps *PermStore, guildID string, m map[string]string) error {
+	var (
+		rows *sql.Rows
+		err  error
+	)
+	switch ps.dialect {
+	case DialectPostgres:
+		rows, err = ps.query(`SELECT key, value FROM guild_settings WHERE guild_id = $1`, guildID)
+	case DialectMySQL:
+		rows, err = ps.query("SELECT `key`, value FROM guild_settings WHERE guild_id = ?", guildID)
+	default:
+		return fmt.Errorf("unsupported dialect: %s", ps.dialect)
+	}
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return err
+		}
+		m[k] = v
+	}
+	return rows.Err()
+}
+
+// Get returns the typed settings for a guild (zero values for unset keys). The error is set
+// when the settings couldn't be loaded; callers must not treat that as defaults where the
+// defaults allow more than the guild's settings might
+func (gs *GuildSettingsStore) Get(guildID string) (GuildSettings, error) {
+	var out GuildSettings
+	if guildID == "" {
+		return out, nil
+	}
+	m, err := gs.raw(guildID)
+	if err != nil {
+		return out, err
+	}
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	for _, sp := range settingSpecs {
+		if v, ok := m[sp.Key]; ok {
+			sp.Apply(&out, v)
+		}
+	}
+	return out, nil
+}
+
+// GetRaw returns the stored value for a key and whether it is set
+func (gs *GuildSettingsStore) GetRaw(guildID, key string) (string, bool, error) {
+	m, err := gs.raw(guildID)
+	if err != nil {
+		return "", false, err
+	}
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	v, ok := m[key]
+	return v, ok, nil
+}
+
+// Set validates and stores a setting; a value that normalises to "" clears the key
+func (gs *GuildSettingsStore) Set(guildID, field, value string) error {
+	sp, ok := findSettingSpec(field)
+	if !ok {
+		return fmt.Errorf("unknown setting: %s", field)
+	}
+	v, err := sp.Normalise(value)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", sp.Key, err)
+	}
+	return gs.setRaw(guildID, sp.Key, v)
+}
+
+// setRaw stores an already-validated value; "" deletes the key
+func (gs *GuildSettingsStore) setRaw(guildID, key, v string) error {
+	// Load first so the cached map holds every stored key, not only this one
+	if _, err := gs.raw(guildID); err != nil {
+		return err
+	}
+
+	gs.mu.RLock()
+	ps := gs.ps
+	gs.mu.RUnlock()
+	if ps != nil && ps.db != nil {
+		var (
+			stmt string
+			args []any
+		)
+		switch {
+		case v == "" && ps.dialect == DialectPostgres:
+			stmt, args = `DELETE FROM guild_settings WHERE guild_id = $1 AND key = $2`, []any{guildID, key}
+		case v == "" && ps.dialect == DialectMySQL:
+			stmt, args = "DELETE FROM guild_settings WHERE guild_id = ? AND `key` = ?", []any{guildID, key}
+		case ps.dialect == DialectPostgres:
+			stmt = `INSERT INTO guild_settings (guild_id, key, value) VALUES ($1, $2, $3)
+				ON CONFLICT (guild_id, key) DO UPDATE SET value = EXCLUDED.value`
+			args = []any{guildID, key, v}
+		case ps.dialect == DialectMySQL:
+			stmt = "INSERT INTO guild_settings (guild_id, `key`, value) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE value = VALUES(value)"
+			args = []any{guildID, key, v}
+		}
+		if _, err := ps.exec(stmt, args...); err != nil {
+			return err
+		}
+	}
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.writes++
+	m := gs.cache[guildID]
+	switch {
+	case m == nil && ps != nil && ps.db != nil:
+		// Cleared or not cached since the load above; the next raw reads the DB again
+		return nil
+	case m == nil:
+		m = make(map[string]string)
+		gs.cache[guildID] = m
+	}
+	if v == "" {
+		delete(m, key)
+	} else {
+		m[key] = v
+	}
+	return nil
+}
+
+// ClearGuild removes every stored setting for a guild
+func (gs *GuildSettingsStore) ClearGuild(guildID string) error {
+	gs.mu.RLock()
+	ps := gs.ps
+	gs.mu.RUnlock()
+	if ps != nil && ps.db != nil {
+		var stmt string
+		switch ps.dialect {
+		case DialectPostgres:
+			stmt = `DELETE FROM guild_settings WHERE guild_id = $1`
+		case DialectMySQL:
+			stmt = `DELETE FROM guild_settings WHERE guild_id = ?`
+		}
+		if _, err := ps.exec(stmt, guildID); err != nil {
+			return err
+		}
+	}
+	gs.mu.Lock()
+	gs.writes++
+	delete(gs.cache, guildID)
+	gs.mu.Unlock()
+	return nil
+}
+
+// disabledCommandsKey stores a guild's disabled slash commands as a sorted comma-separated list.
+// It is managed by /commands rather than /settings, so it is not part of settingSpecs
+const disabledCommandsKey = "disabled_commands"
+
+// CommandEnabled reports whether a slash command is enabled in a guild (DMs are always enabled)
+func (gs *GuildSettingsStore) CommandEnabled(guildID, name string) (bool, error) {
+	if guildID == "" {
+		return true, nil
+	}
+	disabled, err := gs.DisabledCommands(guildID)
+	if err != nil {
+		return false, err
+	}
+	return !slices.Contains(disabled, name), nil
+}
+
+// DisabledCommands returns the sorted list of commands disabled in a guild
+func (gs *GuildSettingsStore) DisabledCommands(guildID string) ([]string, error) {
+	v, _, err := gs.GetRaw(guildID, disabledCommandsKey)
+	if err != nil {
+		return nil, err
+	}
+	return splitCSV(v), nil
+}
+
+// SetCommandEnabled enables or disables a slash command in a guild
+func (gs *GuildSettingsStore) SetCommandEnabled(guildID, name string, enabled bool) error {
+	disabled, err := gs.DisabledCommands(guildID)
+	if err != nil {
+		return err
+	}
+	set := make(map[string]struct{})
+	for _, c := range disabled {
+		set[c] = struct{}{}
+	}
+	if enabled {
+		delete(set, name)
+	} else {
+		set[name] = struct{}{}
+	}
+	list := make([]string, 0, len(set))
+	for c := range set {
+		list = append(list, c)
+	}
+	sort.Strings(list)
+	return gs.setRaw(guildID, disabledCommandsKey, strings.Join(list, ","))
+}
+
+// enabledModelsKey stores a guild's enabled Sightengine models as a comma-separated list,
+// managed by /models; unset means the default sightengineModelsFull set
+const enabledModelsKey = "models"
+
+// EnabledModels returns the Sightengine models enabled for a guild, in catalogue order
+func (gs *GuildSettingsStore) EnabledModels(guildID string) ([]string, error) {
+	v, ok := "", false
+	if guildID != "" {
+		var err error
+		if v, ok, err = gs.GetRaw(guildID, enabledModelsKey); err != nil {
+			return nil, err
+		}
+	}
+	if !ok {
+		v = sightengineModelsFull
+	}
+	enabled := splitCSV(v)
+	out := make([]string, 0, len(enabled))
+	for _, m := range sightengineModels {
+		if slices.Contains(enabled, m.Name) {
+			out = append(out, m.Name)
+		}
+	}
+	return out, nil
+}
+
+// SetModelEnabled enables or disables a Sightengine model for a guild; at least one model must stay enabled
+func (gs *GuildSettingsStore) SetModelEnabled(guildID, model string, enabled bool) error {
+	if !isSightengineModel(model) {
+		return fmt.Errorf("unknown model: %s", model)
+	}
+	current, err := gs.EnabledModels(guildID)
+	if err != nil {
+		return err
+	}
+	next := make([]string, 0, len(current)+1)
+	for _, m := range sightengineModels {
+		on := slices.Contains(current, m.Name)
+		if m.Name == model {
+			on = enabled
+		}
+		if on {
+			next = append(next, m.Name)
+		}
+	}
+	if len(next) == 0 {
+		return fmt.Errorf("at least one model must stay enabled")
+	}
+	v := strings.Join(next, ",")
+	if v == sightengineModelsFull {
+		v = "" // back to the default set
+	}
+	return gs.setRaw(guildID, enabledModelsKey, v)
+}
+
+// SettingView is a display row for /settings view
+type SettingView struct {
+	Key, Value, Description string
+}
+
+// View returns every known setting with its current display value, sorted by key
+func (gs *GuildSettingsStore) View(guildID string) ([]SettingView, error) {
+	m, err := gs.raw(guildID)
+	if err != nil {
+		return nil, err
+	}
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	specs := make([]settingSpec, len(settingSpecs))
+	copy(specs, settingSpecs)
+	sort.Slice(specs, func(a, b int) bool { return specs[a].Key < specs[b].Key })
+	out := make([]SettingView, 0, len(specs))
+	for _, sp := range specs {
+		v, ok := m[sp.Key]
+		switch {
+		case !ok:
+			v = "(default)"
+		case sp.Format != nil:
+			v = sp.Format(v)
+		}
+		out = append(out, SettingView{Key: sp.Key, Value: v, Description: sp.Description})
+	}
+	return out, nil
+}
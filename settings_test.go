@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newMockSettingsStore returns a settings store on a sqlmock-backed Postgres PermStore
+func newMockSettingsStore(t *testing.T) (*GuildSettingsStore, sqlmock.Sqlmock) {
+	t.Helper()
+	ps, mock := newMockPermStore(t)
+	return &GuildSettingsStore{ps: ps, cache: make(map[string]map[string]string)}, mock
+}
+
+func settingRows(kv ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"key", "value"})
+	for n := 0; n+1 < len(kv); n += 2 {
+		rows.AddRow(kv[n], kv[n+1])
+	}
+	return rows
+}
+
+func TestGuildSettingsLoadErrorIsNotDefaults(t *testing.T) {
+	gs, mock := newMockSettingsStore(t)
+	readErr := errors.New("connection reset by peer")
+	mock.ExpectQuery("SELECT key, value FROM guild_settings").WithArgs("g1").WillReturnError(readErr)
+	mock.ExpectQuery("SELECT key, value FROM guild_settings").WithArgs("g1").
+		WillReturnRows(settingRows(disabledCommandsKey, "analyse", "echo_flagged_url", "off"))
+
+	// The failed load must neither read as "nothing disabled" nor be cached
+	if enabled, err := gs.CommandEnabled("g1", "analyse"); !errors.Is(err, readErr) || enabled {
+		t.Fatalf("CommandEnabled after a failed load = %v, %v; want false and the read error", enabled, err)
+	}
+	if enabled, err := gs.CommandEnabled("g1", "analyse"); err != nil || enabled {
+		t.Fatalf("CommandEnabled after reloading = %v, %v; want false", enabled, err)
+	}
+	if s, err := gs.Get("g1"); err != nil || !s.HideFlaggedURL {
+		t.Fatalf("Get = %+v, %v; want the cached settings", s, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGuildSettingsScanErrorFailsLoad(t *testing.T) {
+	gs, mock := newMockSettingsStore(t)
+	rowErr := errors.New("bad connection")
+	mock.ExpectQuery("SELECT key, value FROM guild_settings").WithArgs("g1").
+		WillReturnRows(settingRows("echo_flagged_url", "off", "owners", "100000001").RowError(1, rowErr))
+	if _, err := gs.Get("g1"); !errors.Is(err, rowErr) {
+		t.Fatalf("Get with a row error = %v, want %v", err, rowErr)
+	}
+}
+
+func TestGuildSettingsSetNeedsLoadedSettings(t *testing.T) {
+	gs, mock := newMockSettingsStore(t)
+	readErr := errors.New("connection reset by peer")
+	mock.ExpectQuery("SELECT key, value FROM guild_settings").WithArgs("g1").WillReturnError(readErr)
+	// A read-modify-write on an unknown list would drop the guild's other disabled commands
+	if err := gs.SetCommandEnabled("g1", "ping", false); !errors.Is(err, readErr) {
+		t.Fatalf("SetCommandEnabled = %v, want the read error", err)
+	}
+
+	mock.ExpectQuery("SELECT key, value FROM guild_settings").WithArgs("g1").WillReturnRows(settingRows(disabledCommandsKey, "analyse"))
+	mock.ExpectExec("INSERT INTO guild_settings").WithArgs("g1", disabledCommandsKey, "analyse,ping").WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := gs.SetCommandEnabled("g1", "ping", false); err != nil {
+		t.Fatal(err)
+	}
+	if disabled, err := gs.DisabledCommands("g1"); err != nil || strings.Join(disabled, ",") != "analyse,ping" {
+		t.Fatalf("DisabledCommands = %v, %v; want [analyse ping]", disabled, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGuildSettingsLoadRacingClearIsNotCached(t *testing.T) {
+	gs, mock := newMockSettingsStore(t)
+	mock.MatchExpectationsInOrder(false)
+	// The first load reads the rows as they were before ClearGuild deletes them
+	mock.ExpectQuery("SELECT key, value FROM guild_settings").WithArgs("g1").
+		WillDelayFor(200 * time.Millisecond).WillReturnRows(settingRows("echo_flagged_url", "off"))
+	mock.ExpectExec("DELETE FROM guild_settings").WithArgs("g1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT key, value FROM guild_settings").WithArgs("g1").WillReturnRows(settingRows())
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := gs.Get("g1")
+		done <- err
+	}()
+	// Clear once the load has matched its query and is waiting on it
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if err := mock.ExpectationsWereMet(); err != nil && strings.Contains(err.Error(), "DELETE") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("load never started")
+		}
+	}
+	if err := gs.ClearGuild("g1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if s, err := gs.Get("g1"); err != nil || s.HideFlaggedURL {
+		t.Fatalf("Get after ClearGuild = %+v, %v; the stale load was cached", s, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,94 +1,629 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-// sightengine calls the Sightengine API with the full model set used by standard/advanced analysis
-func sightengine(imageLink string) (map[string]any, error) {
-	apiUser := os.Getenv("SIGHTENGINE_USER")
-	apiSecret := os.Getenv("SIGHTENGINE_SECRET")
-	if apiUser == "" || apiSecret == "" {
-		return nil, fmt.Errorf("SIGHTENGINE_USER and SIGHTENGINE_SECRET must be set")
+// Sightengine model sets
+const (
+	sightengineModelsFull   = "nudity-2.1,offensive-2.0,genai"
+	sightengineModelsAIOnly = "genai"
+)
+
+// sightengineModels lists every model a guild can enable with /models; the ones in
+// sightengineModelsFull are enabled by default. Gore and weapon detection cost extra
+var sightengineModels = []struct{ Name, Label string }{
+	{"nudity-2.1", "Nudity"},
+	{"offensive-2.0", "Offensive symbols"},
+	{"genai", "AI-generated"},
+	{"gore-2.0", "Gore"},
+	{"weapon", "Weapons"},
+}
+
+// sightengineTextModel is the model that moderates text found in images. It isn't offered by
+// /models yet; once it is enabled, its profanity lists follow the guild's text_languages
+const sightengineTextModel = "text-content"
+
+// sightengineTextLanguages are the language codes Sightengine's text moderation accepts
+var sightengineTextLanguages = []string{"da", "de", "en", "es", "fi", "fr", "it", "nl", "no", "pl", "pt", "ru", "sv", "tl", "tr", "zh"}
+
+// sightengineLang returns the lang parameter for a request: the guild's text_languages when the
+// text model is among models, else "" (the parameter is omitted)
+func sightengineLang(guildID, models string) (string, error) {
+	if !slices.Contains(splitCSV(models), sightengineTextModel) {
+		return "", nil
+	}
+	if guildID != "" {
+		gs, err := settingsStore.Get(guildID)
+		if err != nil {
+			return "", err
+		}
+		if len(gs.TextLanguages) > 0 {
+			return strings.Join(gs.TextLanguages, ","), nil
+		}
 	}
+	return "en", nil
+}
 
-	base := "https://api.sightengine.com/1.0/check.json"
-	params := url.Values{}
-	params.Set("url", imageLink)
-	params.Set("models", "nudity-2.1,offensive-2.0,genai")
-	params.Set("api_user", apiUser)
-	params.Set("api_secret", apiSecret)
+// modelAliases are the short names accepted by /analyse models
+var modelAliases = map[string]string{
+	"nudity": "nudity-2.1", "offensive": "offensive-2.0", "ai": "genai", "gore": "gore-2.0", "weapons": "weapon",
+}
 
-	u, err := url.Parse(base)
-	if err != nil {
-		return nil, fmt.Errorf("parse base url: %w", err)
+// parseModelSelection turns a comma-separated list of models or aliases (e.g. "nudity,ai")
+// into model names in canonical order. Each must be enabled for the guild, so a quick check
+// can't run a model the server turned off with /models
+func parseModelSelection(in string, enabled []string) ([]string, error) {
+	var picked []string
+	for _, name := range splitCSV(strings.ToLower(in)) {
+		if m, ok := modelAliases[name]; ok {
+			name = m
+		}
+		if !isSightengineModel(name) {
+			return nil, fmt.Errorf("unknown model %q", name)
+		}
+		if !slices.Contains(enabled, name) {
+			return nil, fmt.Errorf("the %s model is disabled on this server (see /models)", name)
+		}
+		picked = append(picked, name)
 	}
-	u.RawQuery = params.Encode()
+	if len(picked) == 0 {
+		return nil, errors.New("no models selected")
+	}
+	out := make([]string, 0, len(picked))
+	for _, m := range sightengineModels {
+		if slices.Contains(picked, m.Name) {
+			out = append(out, m.Name)
+		}
+	}
+	return out, nil
+}
+
+// isSightengineModel reports whether name is a known model
+func isSightengineModel(name string) bool {
+	for _, m := range sightengineModels {
+		if m.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// maxRawJSONBytes caps the size of raw API output attached to Discord messages
+const maxRawJSONBytes = 1 << 20
+
+// defaultCredentialCooldown is how long a rate-limited credential is skipped
+const defaultCredentialCooldown = 60 * time.Second
+
+// SightengineStatusError is returned when the API answers with a non-200 status
+type SightengineStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *SightengineStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.StatusCode, e.Body)
+}
+
+// Error kinds for the analysis layer. Returned errors wrap (or, for SightengineStatusError and
+// SightengineAPIError, match via errors.Is) one of these so callers can pick a response without
+// inspecting status codes or payload types
+var (
+	// ErrMissingCredentials: SIGHTENGINE_USER/SIGHTENGINE_SECRET are unset, malformed or rejected
+	ErrMissingCredentials = errors.New("sightengine credentials missing or rejected")
+	// ErrUpstreamUnavailable: Sightengine couldn't be reached or answered with a 5xx
+	ErrUpstreamUnavailable = errors.New("sightengine unavailable")
+	// ErrBadImage: the image couldn't be fetched, read or accepted for analysis
+	ErrBadImage = errors.New("image could not be analysed")
+	// ErrRateLimited: every credential is rate limited or out of operations
+	ErrRateLimited = errors.New("sightengine rate limited")
+)
+
+// Is maps HTTP statuses onto the analysis error kinds
+func (e *SightengineStatusError) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUpstreamUnavailable:
+		return e.StatusCode >= 500
+	case ErrMissingCredentials:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrBadImage:
+		return e.StatusCode >= 400 && e.StatusCode < 500 && e.StatusCode != http.StatusTooManyRequests &&
+			e.StatusCode != http.StatusUnauthorized && e.StatusCode != http.StatusForbidden
+	}
+	return false
+}
+
+// SightengineAPIError is returned when the API answers 200 but reports status "failure"
+// in the body (e.g. the media could not be downloaded)
+type SightengineAPIError struct {
+	Type    string
+	Code    int
+	Message string
+}
+
+func (e *SightengineAPIError) Error() string {
+	return fmt.Sprintf("sightengine error (type=%s, code=%d): %s", e.Type, e.Code, e.Message)
+}
 
-	resp, err := sharedHTTPClient.Get(u.String())
+// Is maps failure payload types onto the analysis error kinds
+func (e *SightengineAPIError) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return e.Type == "rate_limit" || e.Type == "usage_limit"
+	case ErrMissingCredentials:
+		return e.Type == "credentials_error"
+	case ErrBadImage:
+		return e.Type == "media_error"
+	}
+	return false
+}
+
+// isSightengineRateLimited reports whether err means the credential is rate limited,
+// either via HTTP 429 or a failure payload of type rate_limit/usage_limit
+func isSightengineRateLimited(err error) bool {
+	return errors.Is(err, ErrRateLimited)
+}
+
+// withoutRequestURL drops the request URL from an HTTP client error: GET requests carry the
+// API secret in the query string, which must not end up in logs
+func withoutRequestURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// sightengineCredential is a single api_user/api_secret pair
+type sightengineCredential struct {
+	User      string
+	Secret    string
+	coolUntil time.Time // skipped until this time after a 429
+}
+
+// sightengineCredentialPool round-robins requests across configured credentials.
+// SIGHTENGINE_USER and SIGHTENGINE_SECRET accept comma-separated lists of equal length;
+// a single pair behaves exactly like before
+type sightengineCredentialPool struct {
+	once     sync.Once
+	loadErr  error
+	mu       sync.Mutex
+	creds    []*sightengineCredential
+	next     int
+	cooldown time.Duration
+}
+
+var sightengineCreds = &sightengineCredentialPool{}
+
+// load parses the credential lists from the environment on first use
+func (p *sightengineCredentialPool) load() error {
+	p.once.Do(func() {
+		users := splitCSV(os.Getenv("SIGHTENGINE_USER"))
+		secrets := splitCSV(os.Getenv("SIGHTENGINE_SECRET"))
+		if len(users) == 0 || len(secrets) == 0 {
+			p.loadErr = fmt.Errorf("%w: SIGHTENGINE_USER and SIGHTENGINE_SECRET must be set", ErrMissingCredentials)
+			return
+		}
+		if len(users) != len(secrets) {
+			p.loadErr = fmt.Errorf("%w: SIGHTENGINE_USER has %d entries but SIGHTENGINE_SECRET has %d", ErrMissingCredentials, len(users), len(secrets))
+			return
+		}
+		for idx := range users {
+			p.creds = append(p.creds, &sightengineCredential{User: users[idx], Secret: secrets[idx]})
+		}
+		p.cooldown = defaultCredentialCooldown
+		if v, err := strconv.Atoi(strings.TrimSpace(os.Getenv("SIGHTENGINE_COOLDOWN_SECONDS"))); err == nil && v > 0 {
+			p.cooldown = time.Duration(v) * time.Second
+		}
+	})
+	return p.loadErr
+}
+
+// pick returns the next credential that is not cooling down
+func (p *sightengineCredentialPool) pick() (*sightengineCredential, error) {
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for range p.creds {
+		c := p.creds[p.next]
+		p.next = (p.next + 1) % len(p.creds)
+		if now.After(c.coolUntil) {
+			return c, nil
+		}
+	}
+	return nil, &SightengineStatusError{StatusCode: http.StatusTooManyRequests, Body: "all Sightengine credentials are rate limited"}
+}
+
+// markRateLimited puts a credential into cooldown after a 429
+func (p *sightengineCredentialPool) markRateLimited(c *sightengineCredential) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c.coolUntil = time.Now().Add(p.cooldown)
+}
+
+// size returns the number of configured credentials (0 when misconfigured)
+func (p *sightengineCredentialPool) size() int {
+	if err := p.load(); err != nil {
+		return 0
+	}
+	return len(p.creds)
+}
+
+// splitCSV splits a comma-separated list, trimming blanks
+func splitCSV(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// defaultSightengineBaseURL is the production API root; check.json lives beneath it
+const defaultSightengineBaseURL = "https://api.sightengine.com/1.0"
+
+// sightengineClient holds the HTTP seam for the Sightengine API: the HTTP client, base URL
+// and credential pool can be swapped (e.g. for an httptest.Server) without touching callers
+type sightengineClient struct {
+	httpClient *http.Client
+	baseURL    string
+	creds      *sightengineCredentialPool
+}
+
+// defaultSightengineClient is used by the package-level helpers below
+var defaultSightengineClient = &sightengineClient{
+	httpClient: sharedHTTPClient,
+	baseURL:    defaultSightengineBaseURL,
+	creds:      sightengineCreds,
+}
+
+// defaultSightengineTimeout bounds a single Sightengine request unless SIGHTENGINE_TIMEOUT is set
+const defaultSightengineTimeout = 30 * time.Second
+
+// configureSightengineTimeout applies SIGHTENGINE_TIMEOUT (seconds) to the default client.
+// Called once at startup; the client keeps the shared transport so connections are pooled
+func configureSightengineTimeout() {
+	d := envSeconds("SIGHTENGINE_TIMEOUT", defaultSightengineTimeout)
+	defaultSightengineClient.httpClient = newHTTPClientWithTimeout(d)
+	log.Printf("sightengine: request timeout %s", d)
+}
+
+// checkURL returns the check.json endpoint for this client
+func (c *sightengineClient) checkURL() string {
+	return strings.TrimRight(c.baseURL, "/") + "/check.json"
+}
+
+// sightengineCheckCredentials verifies every configured credential against the account
+// endpoint, which doesn't consume operations. Used at startup to catch typo'd secrets
+func sightengineCheckCredentials() error {
+	ctx, cancel := context.WithTimeout(appCtx, 15*time.Second)
+	defer cancel()
+	return defaultSightengineClient.checkCredentials(ctx)
+}
+
+// checkCredentials calls account.json once per credential and joins the failures
+func (c *sightengineClient) checkCredentials(ctx context.Context) error {
+	if err := c.creds.load(); err != nil {
+		return err
+	}
+	var errs []error
+	for _, cred := range c.creds.creds {
+		params := url.Values{}
+		params.Set("api_user", cred.User)
+		params.Set("api_secret", cred.Secret)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(c.baseURL, "/")+"/account.json?"+params.Encode(), nil)
+		if err != nil {
+			return fmt.Errorf("build request: %w", err)
+		}
+		resp, err := c.httpClient.Do(req)
+		if err == nil {
+			_, err = decodeSightengineResponse(resp)
+		} else {
+			err = fmt.Errorf("%w: %w", ErrUpstreamUnavailable, withoutRequestURL(err))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("api_user %s: %w", cred.User, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sightengine calls the Sightengine API with the models enabled for the guild (see /models),
+// used by standard/advanced analysis. When ctx opted in (see withRepostDedupe), an image analysed
+// for the same guild within the repost dedupe window reuses that response (see RecentAnalyses)
+func sightengine(ctx context.Context, guildID, imageLink string) (map[string]any, error) {
+	models, err := settingsStore.EnabledModels(guildID)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, err
 	}
-	defer func() { _ = resp.Body.Close() }()
+	return sightengineWithModels(ctx, guildID, imageLink, models)
+}
 
-	body, err := io.ReadAll(resp.Body)
+// sightengineWithModels is sightengine for an explicit model list (see /analyse models)
+func sightengineWithModels(ctx context.Context, guildID, imageLink string, modelList []string) (map[string]any, error) {
+	models := strings.Join(modelList, ",")
+	lang, err := sightengineLang(guildID, models)
 	if err != nil {
-		return nil, fmt.Errorf("read response: %w", err)
+		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	if !repostDedupeEnabled(ctx) {
+		return defaultSightengineClient.forURL(ctx, imageLink, models, lang)
 	}
-
-	var out map[string]any
-	if err := json.Unmarshal(body, &out); err != nil {
-		return nil, fmt.Errorf("decode json: %w", err)
+	key := repostKey(guildID, models+"|"+lang, imageLink)
+	if out, ok := recentAnalyses.Get(key); ok {
+		return out, nil
 	}
-	return out, nil
+	out, err := defaultSightengineClient.forURL(ctx, imageLink, models, lang)
+	if err == nil {
+		recentAnalyses.Put(key, out)
+	}
+	return out, err
 }
 
 // sightengineAIOnly calls the Sightengine API with the AI detection only model
-func sightengineAIOnly(imageLink string) (map[string]any, error) {
-	apiUser := os.Getenv("SIGHTENGINE_USER")
-	apiSecret := os.Getenv("SIGHTENGINE_SECRET")
-	if apiUser == "" || apiSecret == "" {
-		return nil, fmt.Errorf("SIGHTENGINE_USER and SIGHTENGINE_SECRET must be set")
+func sightengineAIOnly(ctx context.Context, imageLink string) (map[string]any, error) {
+	return defaultSightengineClient.forURL(ctx, imageLink, sightengineModelsAIOnly, "")
+}
+
+// sightengineUpload posts raw image bytes to check.json (multipart "media" field) using
+// the given models (the guild's enabled ones); used for images the bot already holds (see AnalyseImageBytes)
+func sightengineUpload(ctx context.Context, guildID string, data []byte, filename string, modelList []string) (map[string]any, error) {
+	models := strings.Join(modelList, ",")
+	lang, err := sightengineLang(guildID, models)
+	if err != nil {
+		return nil, err
+	}
+	return defaultSightengineClient.upload(ctx, data, filename, models, lang)
+}
+
+// check calls check.json for the given models (and text language, when non-empty), rotating
+// credentials and retrying with the next credential when one is rate limited
+func (c *sightengineClient) check(ctx context.Context, imageLink, models, lang string) (map[string]any, error) {
+	return c.withRotation(ctx, func(cred *sightengineCredential) (map[string]any, error) {
+		return c.checkWith(ctx, cred, imageLink, models, lang)
+	})
+}
+
+// upload posts raw image bytes for the given models
+func (c *sightengineClient) upload(ctx context.Context, data []byte, filename, models, lang string) (map[string]any, error) {
+	return c.withRotation(ctx, func(cred *sightengineCredential) (map[string]any, error) {
+		return c.uploadWith(ctx, cred, data, filename, models, lang)
+	})
+}
+
+// forURL analyses an image URL with the given models, downloading and uploading
+// the bytes instead when the host is not reachable by Sightengine (see requiresUpload)
+func (c *sightengineClient) forURL(ctx context.Context, imageLink, models, lang string) (map[string]any, error) {
+	if !requiresUpload(imageLink) {
+		return c.check(ctx, imageLink, models, lang)
+	}
+	data, filename, err := downloadImage(ctx, imageLink)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadImage, err)
 	}
+	return c.upload(ctx, data, filename, models, lang)
+}
+
+// withRotation runs call with rotating credentials, moving to the next
+// credential when one is rate limited, and records the outcome in metrics and, for
+// successful calls, in the usage counter of the guild attached to ctx
+func (c *sightengineClient) withRotation(ctx context.Context, call func(cred *sightengineCredential) (map[string]any, error)) (out map[string]any, err error) {
+	defer func() {
+		metrics.RecordSightengine(err)
+		if err == nil {
+			usageStore.Increment(usageGuildFromContext(ctx))
+		}
+	}()
+
+	attempts := c.creds.size()
+	if attempts == 0 {
+		attempts = 1
+	}
+	for attempt := 0; attempt < attempts; attempt++ {
+		var cred *sightengineCredential
+		cred, err = c.creds.pick()
+		if err != nil {
+			return nil, err
+		}
+		out, err = call(cred)
+		if isSightengineRateLimited(err) {
+			c.creds.markRateLimited(cred)
+			continue
+		}
+		return out, err
+	}
+	return nil, err
+}
 
-	base := "https://api.sightengine.com/1.0/check.json"
+// checkWith performs a single check.json request using one credential
+func (c *sightengineClient) checkWith(ctx context.Context, cred *sightengineCredential, imageLink, models, lang string) (map[string]any, error) {
 	params := url.Values{}
 	params.Set("url", imageLink)
-	params.Set("models", "genai")
-	params.Set("api_user", apiUser)
-	params.Set("api_secret", apiSecret)
+	params.Set("models", models)
+	if lang != "" {
+		params.Set("lang", lang)
+	}
+	params.Set("api_user", cred.User)
+	params.Set("api_secret", cred.Secret)
 
-	u, err := url.Parse(base)
+	u, err := url.Parse(c.checkURL())
 	if err != nil {
 		return nil, fmt.Errorf("parse base url: %w", err)
 	}
 	u.RawQuery = params.Encode()
 
-	resp, err := sharedHTTPClient.Get(u.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: request failed: %w", ErrUpstreamUnavailable, withoutRequestURL(err))
+	}
+	return decodeSightengineResponse(resp)
+}
+
+// uploadWith performs a single multipart check.json request using one credential
+func (c *sightengineClient) uploadWith(ctx context.Context, cred *sightengineCredential, data []byte, filename, models, lang string) (map[string]any, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	_ = mw.WriteField("models", models)
+	if lang != "" {
+		_ = mw.WriteField("lang", lang)
+	}
+	_ = mw.WriteField("api_user", cred.User)
+	_ = mw.WriteField("api_secret", cred.Secret)
+	fw, err := mw.CreateFormFile("media", filename)
+	if err != nil {
+		return nil, fmt.Errorf("build multipart: %w", err)
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, fmt.Errorf("build multipart: %w", err)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("build multipart: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.checkURL(), &buf)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, fmt.Errorf("build request: %w", err)
 	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: request failed: %w", ErrUpstreamUnavailable, err)
+	}
+	return decodeSightengineResponse(resp)
+}
+
+// decodeSightengineResponse reads and decodes a check.json response, closing the body. Rate
+// limit headers are recorded first, so even failed calls update the quota snapshot
+func decodeSightengineResponse(resp *http.Response) (map[string]any, error) {
 	defer func() { _ = resp.Body.Close() }()
+	recordSightengineQuota(resp.Header)
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("read response: %w", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+		return nil, &SightengineStatusError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	var out map[string]any
 	if err := json.Unmarshal(body, &out); err != nil {
 		return nil, fmt.Errorf("decode json: %w", err)
 	}
+	// Some failures (e.g. unreachable media) come back as 200 with status "failure"
+	if status, _ := out["status"].(string); status != "success" {
+		apiErr := &SightengineAPIError{Message: "unknown error"}
+		if status != "" {
+			apiErr.Message = "status " + status
+		}
+		if e := getMap(out, "error"); e != nil {
+			if t, ok := e["type"].(string); ok {
+				apiErr.Type = t
+			}
+			apiErr.Code = int(getFloat(e, "code"))
+			if msg, ok := e["message"].(string); ok && msg != "" {
+				apiErr.Message = msg
+			}
+		}
+		return nil, apiErr
+	}
 	return out, nil
 }
+
+// classifySightengineError maps an analysis error to a user-facing message and whether
+// retrying later is likely to help (connectivity problems, timeouts, 429 and 5xx)
+func classifySightengineError(err error) (userMsg string, retryable bool) {
+	if err == nil {
+		return "", false
+	}
+
+	if errors.Is(err, errImageTooLarge) {
+		return fmt.Sprintf("The image is too large to analyse (the limit is %s MB).", strconv.FormatFloat(math.Round(float64(maxUploadBytes())/(1<<20)*100)/100, 'f', -1, 64)), false
+	}
+	if errors.Is(err, errDiscordLinkExpired) {
+		return "The Discord attachment link has expired and a fresh link could not be fetched. Re-upload the image or copy a new link and try again.", false
+	}
+
+	var apiErr *SightengineAPIError
+	isAPIErr := errors.As(err, &apiErr)
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		return "The moderation service is rate limiting requests. Please try again shortly.", true
+	case errors.Is(err, ErrMissingCredentials):
+		return "The moderation service credentials are missing or were rejected. Please contact the bot owner.", false
+	case errors.Is(err, ErrUpstreamUnavailable):
+		return "The moderation service is temporarily unavailable. Please try again shortly.", true
+	case errors.Is(err, ErrBadImage) && isAPIErr:
+		return "The moderation service could not download or read the image: " + apiErr.Message, false
+	case errors.Is(err, ErrBadImage):
+		return "The image could not be analysed. Check that the URL is correct and points directly to a publicly accessible image.", false
+	case isAPIErr:
+		return "The image could not be analysed: " + apiErr.Message, false
+	}
+
+	return "The image could not be analysed. Please check the URL and try again.", false
+}
+
+// rawSightengineJSON pretty-prints a decoded response for debugging, redacting any
+// credential-like keys and truncating output larger than maxRawJSONBytes
+func rawSightengineJSON(out map[string]any) ([]byte, error) {
+	b, err := json.MarshalIndent(redactSecrets(out), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxRawJSONBytes {
+		b = append(b[:maxRawJSONBytes], []byte("\n... (truncated)")...)
+	}
+	return b, nil
+}
+
+// redactSecrets returns a copy of v with api_user/api_secret values replaced at any depth
+func redactSecrets(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		cp := make(map[string]any, len(t))
+		for k, val := range t {
+			switch strings.ToLower(k) {
+			case "api_user", "api_secret":
+				cp[k] = "[redacted]"
+			default:
+				cp[k] = redactSecrets(val)
+			}
+		}
+		return cp
+	case []any:
+		cp := make([]any, len(t))
+		for idx, val := range t {
+			cp[idx] = redactSecrets(val)
+		}
+		return cp
+	default:
+		return v
+	}
+}
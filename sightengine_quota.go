@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultQuotaWarnRemaining is the remaining-requests level below which a warning is logged
+// (SIGHTENGINE_QUOTA_WARN overrides it)
+const defaultQuotaWarnRemaining = 100
+
+// SightengineQuota is the rate/quota state reported by the most recent Sightengine response
+// that carried the headers. Fields are -1 (or empty) when the header was absent
+type SightengineQuota struct {
+	Limit     int64
+	Remaining int64
+	Reset     string
+	Seen      time.Time
+}
+
+// String renders the snapshot for /diagnostics, e.g. "42 / 500 remaining (reset 3600), seen 2m ago"
+func (q SightengineQuota) String() string {
+	if q.Seen.IsZero() {
+		return "no rate limit headers seen yet"
+	}
+	s := "limit unknown"
+	switch {
+	case q.Remaining >= 0 && q.Limit >= 0:
+		s = fmt.Sprintf("%d / %d remaining", q.Remaining, q.Limit)
+	case q.Remaining >= 0:
+		s = fmt.Sprintf("%d remaining", q.Remaining)
+	}
+	if q.Reset != "" {
+		s += " (reset " + q.Reset + ")"
+	}
+	return s + ", seen " + time.Since(q.Seen).Round(time.Second).String() + " ago"
+}
+
+// quotaSnapshot holds the latest SightengineQuota across all credentials
+var quotaSnapshot struct {
+	mu sync.Mutex
+	q  SightengineQuota
+}
+
+// latestSightengineQuota returns the most recent quota snapshot
+func latestSightengineQuota() SightengineQuota {
+	quotaSnapshot.mu.Lock()
+	defer quotaSnapshot.mu.Unlock()
+	return quotaSnapshot.q
+}
+
+// quotaWarnRemaining returns SIGHTENGINE_QUOTA_WARN (default 100)
+func quotaWarnRemaining() int64 {
+	if n, err := strconv.ParseInt(strings.TrimSpace(os.Getenv("SIGHTENGINE_QUOTA_WARN")), 10, 64); err == nil && n >= 0 {
+		return n
+	}
+	return defaultQuotaWarnRemaining
+}
+
+// recordSightengineQuota stores the X-RateLimit-* headers of a response. Responses without them
+// leave the snapshot alone. A warning is logged when remaining requests first drop below
+// quotaWarnRemaining, not on every call after that
+func recordSightengineQuota(h http.Header) {
+	limit, remaining := headerInt(h, "X-RateLimit-Limit"), headerInt(h, "X-RateLimit-Remaining")
+	reset := strings.TrimSpace(h.Get("X-RateLimit-Reset"))
+	if limit < 0 && remaining < 0 && reset == "" {
+		return
+	}
+	quotaSnapshot.mu.Lock()
+	prev := quotaSnapshot.q
+	quotaSnapshot.q = SightengineQuota{Limit: limit, Remaining: remaining, Reset: reset, Seen: time.Now()}
+	quotaSnapshot.mu.Unlock()
+
+	warn := quotaWarnRemaining()
+	if remaining >= 0 && remaining < warn && (prev.Seen.IsZero() || prev.Remaining < 0 || prev.Remaining >= warn) {
+		log.Printf("WARNING: Sightengine quota low: %d requests remaining (limit %d, reset %q)", remaining, limit, reset)
+	}
+}
+
+// headerInt parses an integer header, returning -1 when it is missing or malformed
+func headerInt(h http.Header, name string) int64 {
+	n, err := strconv.ParseInt(strings.TrimSpace(h.Get(name)), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCredentialPool returns a loaded pool with one credential per user (secret "s-"+user)
+func testCredentialPool(users ...string) *sightengineCredentialPool {
+	p := &sightengineCredentialPool{cooldown: time.Minute}
+	p.once.Do(func() {})
+	for _, u := range users {
+		p.creds = append(p.creds, &sightengineCredential{User: u, Secret: "s-" + u})
+	}
+	return p
+}
+
+// newTestSightengineClient points a client at an httptest server running h
+func newTestSightengineClient(t *testing.T, h http.HandlerFunc, users ...string) *sightengineClient {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return &sightengineClient{httpClient: srv.Client(), baseURL: srv.URL + "/1.0/", creds: testCredentialPool(users...)}
+}
+
+// resetQuotaSnapshot clears the package-level quota state around a test
+func resetQuotaSnapshot(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		quotaSnapshot.mu.Lock()
+		quotaSnapshot.q = SightengineQuota{}
+		quotaSnapshot.mu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestSightengineCheckSendsURL(t *testing.T) {
+	c := newTestSightengineClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/1.0/check.json" {
+			t.Errorf("got %s %s, want GET /1.0/check.json", r.Method, r.URL.Path)
+		}
+		q := r.URL.Query()
+		for key, want := range map[string]string{
+			"url": "https://example.com/cat.png", "models": "genai", "lang": "en", "api_user": "u1", "api_secret": "s-u1",
+		} {
+			if got := q.Get(key); got != want {
+				t.Errorf("query %s = %q, want %q", key, got, want)
+			}
+		}
+		_, _ = io.WriteString(w, `{"status":"success","type":{"ai_generated":0.9}}`)
+	}, "u1")
+
+	out, err := c.check(context.Background(), "https://example.com/cat.png", "genai", "en")
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if got := getFloat(getMap(out, "type"), "ai_generated"); got != 0.9 {
+		t.Errorf("ai_generated = %v, want 0.9", got)
+	}
+}
+
+func TestSightengineUploadPostsMultipart(t *testing.T) {
+	c := newTestSightengineClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("parse multipart: %v", err)
+			return
+		}
+		if got := r.FormValue("models"); got != "nudity-2.1,genai" {
+			t.Errorf("models = %q", got)
+		}
+		if got := r.FormValue("api_user"); got != "u1" {
+			t.Errorf("api_user = %q", got)
+		}
+		if r.FormValue("url") != "" {
+			t.Error("upload must not send a url parameter")
+		}
+		f, hdr, err := r.FormFile("media")
+		if err != nil {
+			t.Errorf("media field: %v", err)
+			return
+		}
+		defer func() { _ = f.Close() }()
+		data, _ := io.ReadAll(f)
+		if hdr.Filename != "cat.png" || string(data) != "PNGDATA" {
+			t.Errorf("media = %q (%q), want cat.png (PNGDATA)", hdr.Filename, data)
+		}
+		_, _ = io.WriteString(w, `{"status":"success"}`)
+	}, "u1")
+
+	if _, err := c.upload(context.Background(), []byte("PNGDATA"), "cat.png", "nudity-2.1,genai", ""); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+}
+
+func TestSightengineFailurePayload(t *testing.T) {
+	c := newTestSightengineClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"status":"failure","error":{"type":"media_error","code":21,"message":"Media could not be downloaded"}}`)
+	}, "u1")
+
+	_, err := c.check(context.Background(), "https://example.com/gone.png", "genai", "")
+	var apiErr *SightengineAPIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want *SightengineAPIError", err)
+	}
+	if apiErr.Type != "media_error" || apiErr.Code != 21 || apiErr.Message != "Media could not be downloaded" {
+		t.Errorf("apiErr = %+v", apiErr)
+	}
+	if !errors.Is(err, ErrBadImage) {
+		t.Error("media_error should match ErrBadImage")
+	}
+}
+
+func TestSightengineRotatesOnRateLimit(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	c := newTestSightengineClient(t, func(w http.ResponseWriter, r *http.Request) {
+		user := r.URL.Query().Get("api_user")
+		mu.Lock()
+		calls[user]++
+		mu.Unlock()
+		if user == "limited" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = io.WriteString(w, `{"status":"failure"}`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"status":"success"}`)
+	}, "limited", "spare")
+
+	for n := 0; n < 3; n++ {
+		if _, err := c.check(context.Background(), "https://example.com/a.png", "genai", ""); err != nil {
+			t.Fatalf("call %d: %v", n, err)
+		}
+	}
+	// The limited credential is tried once, then skipped while cooling down
+	if calls["limited"] != 1 || calls["spare"] != 3 {
+		t.Errorf("calls = %v, want limited=1 spare=3", calls)
+	}
+	if until := c.creds.creds[0].coolUntil; time.Until(until) <= 0 {
+		t.Errorf("limited credential not cooling down (coolUntil %v)", until)
+	}
+}
+
+func TestSightengineAllCredentialsRateLimited(t *testing.T) {
+	c := newTestSightengineClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}, "a", "b")
+
+	_, err := c.check(context.Background(), "https://example.com/a.png", "genai", "")
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("err = %v, want ErrRateLimited", err)
+	}
+	if _, err := c.creds.pick(); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("pick while all cooling down = %v, want ErrRateLimited", err)
+	}
+}
+
+func TestSightengineRecordsQuotaHeaders(t *testing.T) {
+	resetQuotaSnapshot(t)
+	c := newTestSightengineClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "500")
+		w.Header().Set("X-RateLimit-Remaining", "42")
+		w.Header().Set("X-RateLimit-Reset", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, "u1")
+
+	_, err := c.check(context.Background(), "https://example.com/a.png", "genai", "")
+	if !errors.Is(err, ErrUpstreamUnavailable) {
+		t.Fatalf("err = %v, want ErrUpstreamUnavailable", err)
+	}
+	q := latestSightengineQuota()
+	if q.Limit != 500 || q.Remaining != 42 || q.Reset != "3600" || q.Seen.IsZero() {
+		t.Errorf("quota = %+v, want 42/500 reset 3600", q)
+	}
+}
+
+func TestRepostDedupeIsOptIn(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hits
+	}
+	useTestSightengine(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		_, _ = io.WriteString(w, `{"status":"success"}`)
+	})
+	const link = "https://example.com/repost-dedupe.png"
+
+	// Interactive analyses always call the API
+	for n := 0; n < 2; n++ {
+		if _, err := sightengine(context.Background(), "g1", link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := count(); n != 2 {
+		t.Fatalf("hits without opt-in = %d, want 2", n)
+	}
+	// Opted-in callers reuse the first response
+	ctx := withRepostDedupe(context.Background())
+	for n := 0; n < 2; n++ {
+		if _, err := sightengine(ctx, "g1", link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := count(); n != 3 {
+		t.Errorf("hits with opt-in = %d, want 3", n)
+	}
+}
+
+// sightengineResponse builds a check.json response with the given status and body
+func sightengineResponse(status int, body string) *http.Response {
+	return &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+func TestDecodeSightengineFailurePayloads(t *testing.T) {
+	kinds := []error{ErrRateLimited, ErrMissingCredentials, ErrUpstreamUnavailable, ErrBadImage}
+	for _, tc := range []struct {
+		name      string
+		body      string
+		want      error // nil: matches no kind
+		apiErr    SightengineAPIError
+		retryable bool
+		msg       string
+	}{
+		{
+			name:   "media error",
+			body:   `{"status":"failure","error":{"type":"media_error","code":21,"message":"Media could not be downloaded"}}`,
+			want:   ErrBadImage,
+			apiErr: SightengineAPIError{Type: "media_error", Code: 21, Message: "Media could not be downloaded"},
+			msg:    "could not download or read the image: Media could not be downloaded",
+		},
+		{
+			name:      "rate limit",
+			body:      `{"status":"failure","error":{"type":"rate_limit","code":32,"message":"Too many requests"}}`,
+			want:      ErrRateLimited,
+			apiErr:    SightengineAPIError{Type: "rate_limit", Code: 32, Message: "Too many requests"},
+			retryable: true,
+			msg:       "rate limiting",
+		},
+		{
+			name:      "usage limit",
+			body:      `{"status":"failure","error":{"type":"usage_limit","code":33,"message":"Daily usage limit reached"}}`,
+			want:      ErrRateLimited,
+			apiErr:    SightengineAPIError{Type: "usage_limit", Code: 33, Message: "Daily usage limit reached"},
+			retryable: true,
+			msg:       "rate limiting",
+		},
+		{
+			name:   "credentials error",
+			body:   `{"status":"failure","error":{"type":"credentials_error","code":1,"message":"Incorrect API secret"}}`,
+			want:   ErrMissingCredentials,
+			apiErr: SightengineAPIError{Type: "credentials_error", Code: 1, Message: "Incorrect API secret"},
+			msg:    "credentials are missing or were rejected",
+		},
+		{
+			name:   "unknown type",
+			body:   `{"status":"failure","error":{"type":"argument_error","code":2,"message":"Unknown model"}}`,
+			apiErr: SightengineAPIError{Type: "argument_error", Code: 2, Message: "Unknown model"},
+			msg:    "could not be analysed: Unknown model",
+		},
+		{
+			name:   "failure without error object",
+			body:   `{"status":"failure"}`,
+			apiErr: SightengineAPIError{Message: "status failure"},
+			msg:    "could not be analysed: status failure",
+		},
+		{
+			name:   "missing status",
+			body:   `{"nudity":{}}`,
+			apiErr: SightengineAPIError{Message: "unknown error"},
+			msg:    "could not be analysed: unknown error",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := decodeSightengineResponse(sightengineResponse(http.StatusOK, tc.body))
+			var apiErr *SightengineAPIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("err = %v, want *SightengineAPIError", err)
+			}
+			if *apiErr != tc.apiErr {
+				t.Errorf("apiErr = %+v, want %+v", *apiErr, tc.apiErr)
+			}
+			for _, kind := range kinds {
+				if got := errors.Is(err, kind); got != (kind == tc.want) {
+					t.Errorf("errors.Is(err, %v) = %v", kind, got)
+				}
+			}
+			msg, retryable := classifySightengineError(err)
+			if retryable != tc.retryable || !strings.Contains(msg, tc.msg) {
+				t.Errorf("classify = %q, %v; want containing %q, %v", msg, retryable, tc.msg, tc.retryable)
+			}
+		})
+	}
+}
+
+func TestDecodeSightengineStatusErrors(t *testing.T) {
+	for _, tc := range []struct {
+		status    int
+		want      error
+		retryable bool
+	}{
+		{http.StatusTooManyRequests, ErrRateLimited, true},
+		{http.StatusUnauthorized, ErrMissingCredentials, false},
+		{http.StatusForbidden, ErrMissingCredentials, false},
+		{http.StatusBadRequest, ErrBadImage, false},
+		{http.StatusBadGateway, ErrUpstreamUnavailable, true},
+	} {
+		_, err := decodeSightengineResponse(sightengineResponse(tc.status, `{"status":"failure"}`))
+		var statusErr *SightengineStatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != tc.status {
+			t.Errorf("status %d: err = %v, want *SightengineStatusError", tc.status, err)
+			continue
+		}
+		if !errors.Is(err, tc.want) {
+			t.Errorf("status %d: errors.Is(err, %v) = false", tc.status, tc.want)
+		}
+		if _, retryable := classifySightengineError(err); retryable != tc.retryable {
+			t.Errorf("status %d: retryable = %v, want %v", tc.status, retryable, tc.retryable)
+		}
+	}
+}
+
+// useUserContentClient lets downloadImage reach httptest servers on loopback for the rest of the test
+func useUserContentClient(t *testing.T, c *http.Client) {
+	t.Helper()
+	prev := userContentHTTPClient
+	userContentHTTPClient = c
+	t.Cleanup(func() { userContentHTTPClient = prev })
+}
+
+func TestForURLWrapsDownloadErrors(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR" + strings.Repeat("x", 64)
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page.html" {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = io.WriteString(w, "<html></html>")
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = io.WriteString(w, png)
+	}))
+	defer images.Close()
+	c := newTestSightengineClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Sightengine called after a failed download")
+	}, "u1")
+	t.Setenv("UPLOAD_IMAGE_HOSTS", "127.0.0.1")
+
+	for _, tc := range []struct {
+		name  string
+		path  string
+		setup func(t *testing.T)
+		cause error
+		msg   string
+	}{
+		{"private address", "/a.png", func(t *testing.T) {}, errPrivateAddress, "could not be analysed"},
+		{"not an image", "/page.html", func(t *testing.T) { useUserContentClient(t, images.Client()) }, errNotAnImage, "could not be analysed"},
+		{"too large", "/a.png", func(t *testing.T) {
+			useUserContentClient(t, images.Client())
+			t.Setenv("MAX_UPLOAD_BYTES", "16")
+		}, errImageTooLarge, "too large"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.setup(t)
+			_, err := c.forURL(context.Background(), images.URL+tc.path, "genai", "")
+			if !errors.Is(err, ErrBadImage) || !errors.Is(err, tc.cause) {
+				t.Fatalf("err = %v, want ErrBadImage wrapping %v", err, tc.cause)
+			}
+			if msg, retryable := classifySightengineError(err); retryable || !strings.Contains(msg, tc.msg) {
+				t.Errorf("classify = %q, %v; want containing %q, not retryable", msg, retryable, tc.msg)
+			}
+		})
+	}
+}
+
+func TestCheckWrapsTransportErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Close()
+	c := &sightengineClient{httpClient: http.DefaultClient, baseURL: srv.URL + "/1.0/", creds: testCredentialPool("u1")}
+
+	_, err := c.check(context.Background(), "https://example.com/a.png", "genai", "")
+	if !errors.Is(err, ErrUpstreamUnavailable) {
+		t.Fatalf("err = %v, want ErrUpstreamUnavailable", err)
+	}
+	if strings.Contains(err.Error(), "s-u1") {
+		t.Errorf("error leaks the API secret: %v", err)
+	}
+	if _, retryable := classifySightengineError(err); !retryable {
+		t.Error("connection failures should be retryable")
+	}
+}
+
+func TestCheckCredentialsJoinsTypedErrors(t *testing.T) {
+	c := newTestSightengineClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api_user") == "bad" {
+			_, _ = io.WriteString(w, `{"status":"failure","error":{"type":"credentials_error","code":1,"message":"Incorrect API user"}}`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"status":"success"}`)
+	}, "good", "bad")
+
+	err := c.checkCredentials(context.Background())
+	if !errors.Is(err, ErrMissingCredentials) {
+		t.Fatalf("err = %v, want ErrMissingCredentials", err)
+	}
+	if !strings.Contains(err.Error(), "api_user bad") || strings.Contains(err.Error(), "api_user good") {
+		t.Errorf("err = %v, want only the rejected credential", err)
+	}
+}
+
+// newCountingTLSServer starts a TLS server answering check.json and counts the connections it accepts
+func newCountingTLSServer(tb testing.TB) (*httptest.Server, func() int) {
+	tb.Helper()
+	var mu sync.Mutex
+	conns := 0
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"status":"success"}`)
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
+	}
+	srv.StartTLS()
+	tb.Cleanup(srv.Close)
+	return srv, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return conns
+	}
+}
+
+// sharedTransportFor clones sharedTransport (keeping its pooling settings) to trust srv's certificate
+func sharedTransportFor(srv *httptest.Server) *http.Transport {
+	tr := sharedTransport.Clone()
+	tr.TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	return tr
+}
+
+func TestSightengineClientUsesSharedTransport(t *testing.T) {
+	prev := defaultSightengineClient.httpClient
+	t.Cleanup(func() { defaultSightengineClient.httpClient = prev })
+	t.Setenv("SIGHTENGINE_TIMEOUT", "7")
+	configureSightengineTimeout()
+	if c := defaultSightengineClient.httpClient; c.Transport != sharedTransport || c.Timeout != 7*time.Second {
+		t.Errorf("client = %+v, want sharedTransport with a 7s timeout", c)
+	}
+}
+
+func TestSightengineClientReusesConnections(t *testing.T) {
+	srv, conns := newCountingTLSServer(t)
+	c := &sightengineClient{httpClient: &http.Client{Transport: sharedTransportFor(srv)}, baseURL: srv.URL + "/1.0/", creds: testCredentialPool("u1")}
+	for n := 0; n < 20; n++ {
+		if _, err := c.check(context.Background(), "https://example.com/a.png", "genai", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := conns(); n != 1 {
+		t.Errorf("20 sequential calls opened %d connections, want 1", n)
+	}
+}
+
+// BenchmarkSightengineCheck compares sequential check.json calls over the pooled shared transport
+// with a fresh transport per call; conns/op reports the TLS connections opened per call
+func BenchmarkSightengineCheck(b *testing.B) {
+	for _, bc := range []struct {
+		name    string
+		perCall bool
+	}{{"shared", false}, {"per-call", true}} {
+		b.Run(bc.name, func(b *testing.B) {
+			srv, conns := newCountingTLSServer(b)
+			tr := sharedTransportFor(srv)
+			c := &sightengineClient{httpClient: &http.Client{Transport: tr}, baseURL: srv.URL + "/1.0/", creds: testCredentialPool("u1")}
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				if bc.perCall {
+					tr.CloseIdleConnections()
+					tr = sharedTransportFor(srv)
+					c.httpClient = &http.Client{Transport: tr}
+				}
+				if _, err := c.check(context.Background(), "https://example.com/a.png", "genai", ""); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(conns())/float64(b.N), "conns/op")
+		})
+	}
+}
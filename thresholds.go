@@ -2,23 +2,49 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"slices"
+	"strings"
 	"time"
 )
 
-// Active thresholds (mutable at runtime)
+// DMThresholdsKey is the synthetic guild key under which the owner's DM thresholds are stored
+const DMThresholdsKey = "dm"
+
+// thresholdsGuildKey maps an interaction guild ID to its storage key (DMs use DMThresholdsKey)
+func thresholdsGuildKey(guildID string) string {
+	if guildID == "" {
+		return DMThresholdsKey
+	}
+	return guildID
+}
+
+// Active global thresholds (mutable at runtime). Precedence for a guild is
+// guild value > global value > default; see resolveThresholds
 var (
 	NuditySuggestiveThreshold = DefaultNuditySuggestiveThreshold
 	NudityExplicitThreshold   = DefaultNudityExplicitThreshold
 	OffensiveThreshold        = DefaultOffensiveThreshold
 	AIGeneratedThreshold      = DefaultAIGeneratedThreshold
+	DeepfakeThreshold         = DefaultDeepfakeThreshold
 )
 
 // ThresholdsStore persists active thresholds if a DB is configured.
-// If no DB is configured, it is a no-op and values remain in-memory.
+// If no DB is configured, global values remain in-memory and per-guild (and DM) values can't be
+// stored at all: SetGuild and EnsureGuildSnapshot return errThresholdsNeedDB
 type ThresholdsStore struct{}
 
+// errThresholdsNeedDB is returned when per-guild thresholds are changed without a database
+var errThresholdsNeedDB = errors.New("per-guild thresholds require a database")
+
+// GuildStorageAvailable reports whether per-guild (and DM) thresholds can be stored
+func (ts *ThresholdsStore) GuildStorageAvailable(ps *PermStore) bool {
+	return ps != nil && ps.db != nil
+}
+
 var thresholdsStore = &ThresholdsStore{}
 
 // Init creates the thresholds table if DB is available and loads current values
@@ -41,7 +67,7 @@ func (ts *ThresholdsStore) Init(ps *PermStore) error {
 	default:
 		return fmt.Errorf("unsupported dialect: %s", ps.dialect)
 	}
-	if _, err := ps.db.Exec(ddl); err != nil {
+	if _, err := ps.exec(ddl); err != nil {
 		return fmt.Errorf("create thresholds table: %w", err)
 	}
 
@@ -68,7 +94,7 @@ func (ts *ThresholdsStore) Init(ps *PermStore) error {
 			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
 	}
-	if _, err := ps.db.Exec(ddl); err != nil {
+	if _, err := ps.exec(ddl); err != nil {
 		return fmt.Errorf("create thresholds_history table: %w", err)
 	}
 
@@ -80,7 +106,7 @@ func (ts *ThresholdsStore) Load(ps *PermStore) error {
 	if ps == nil || ps.db == nil {
 		return nil
 	}
-	rows, err := ps.db.Query(`SELECT name, value FROM thresholds`)
+	rows, err := ps.query(`SELECT name, value FROM thresholds`)
 	if err != nil {
 		return err
 	}
@@ -92,23 +118,13 @@ func (ts *ThresholdsStore) Load(ps *PermStore) error {
 			log.Println("thresholds scan:", err)
 			continue
 		}
-		switch name {
-		case "NuditySuggestive":
-			NuditySuggestiveThreshold = value
-		case "NudityExplicit":
-			NudityExplicitThreshold = value
-		case "Offensive":
-			OffensiveThreshold = value
-		case "AIGenerated":
-			AIGeneratedThreshold = value
-		}
+		setGlobalThreshold(name, value)
 	}
 	return nil
 }
 
-// Set updates a single threshold in DB (and memory). value must be between 0 and 1
-func (ts *ThresholdsStore) Set(ps *PermStore, name string, value float64) error {
-	// update memory
+// setGlobalThreshold updates the in-memory global for a canonical name; false if unknown
+func setGlobalThreshold(name string, value float64) bool {
 	switch name {
 	case "NuditySuggestive":
 		NuditySuggestiveThreshold = value
@@ -118,7 +134,19 @@ func (ts *ThresholdsStore) Set(ps *PermStore, name string, value float64) error
 		OffensiveThreshold = value
 	case "AIGenerated":
 		AIGeneratedThreshold = value
+	case "Deepfake":
+		DeepfakeThreshold = value
 	default:
+		return false
+	}
+	return true
+}
+
+// Set updates a single global threshold in DB (and memory). value must be between 0 and 1.
+// It only touches the global layer: guilds with their own value for name are unaffected
+func (ts *ThresholdsStore) Set(ps *PermStore, name string, value float64) error {
+	// update memory
+	if !setGlobalThreshold(name, value) {
 		return fmt.Errorf("unknown threshold: %s", name)
 	}
 	if ps == nil || ps.db == nil {
@@ -133,41 +161,24 @@ func (ts *ThresholdsStore) Set(ps *PermStore, name string, value float64) error
 		stmt = `INSERT INTO thresholds (name, value) VALUES (?, ?)
 			ON DUPLICATE KEY UPDATE value = VALUES(value)`
 	}
-	_, err := ps.db.Exec(stmt, name, value)
+	_, err := ps.exec(stmt, name, value)
 	return err
 }
 
 // ResetOne resets a single threshold to default and persists
 func (ts *ThresholdsStore) ResetOne(ps *PermStore, name string) error {
-	var def float64
-	switch name {
-	case "NuditySuggestive":
-		def = DefaultNuditySuggestiveThreshold
-	case "NudityExplicit":
-		def = DefaultNudityExplicitThreshold
-	case "Offensive":
-		def = DefaultOffensiveThreshold
-	case "AIGenerated":
-		def = DefaultAIGeneratedThreshold
-	default:
+	if !isThresholdName(name) {
 		return fmt.Errorf("unknown threshold: %s", name)
 	}
-	return ts.Set(ps, name, def)
+	return ts.Set(ps, name, defaultThresholdValue(name))
 }
 
 // ResetAll resets all thresholds to their default values and persists if DB
 func (ts *ThresholdsStore) ResetAll(ps *PermStore) error {
-	if err := ts.Set(ps, "NuditySuggestive", DefaultNuditySuggestiveThreshold); err != nil {
-		return err
-	}
-	if err := ts.Set(ps, "NudityExplicit", DefaultNudityExplicitThreshold); err != nil {
-		return err
-	}
-	if err := ts.Set(ps, "Offensive", DefaultOffensiveThreshold); err != nil {
-		return err
-	}
-	if err := ts.Set(ps, "AIGenerated", DefaultAIGeneratedThreshold); err != nil {
-		return err
+	for _, name := range thresholdNames {
+		if err := ts.Set(ps, name, defaultThresholdValue(name)); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -194,7 +205,7 @@ func (ts *ThresholdsStore) LogChange(ps *PermStore, name string, oldVal, newVal
 	case DialectMySQL:
 		stmt = `INSERT INTO thresholds_history (name, old_value, new_value, user_id, guild_id) VALUES (?, ?, ?, ?, ?)`
 	}
-	_, err := ps.db.Exec(stmt, name, oldVal, newVal, userID, guildID)
+	_, err := ps.exec(stmt, name, oldVal, newVal, userID, thresholdsGuildKey(guildID))
 	return err
 }
 
@@ -213,9 +224,9 @@ func (ts *ThresholdsStore) History(ps *PermStore, limit int) ([]ThresholdChange,
 	)
 	switch ps.dialect {
 	case DialectPostgres:
-		rows, err = ps.db.Query(`SELECT name, old_value, new_value, user_id, guild_id, created_at FROM thresholds_history ORDER BY created_at DESC LIMIT $1`, limit)
+		rows, err = ps.query(`SELECT name, old_value, new_value, user_id, guild_id, created_at FROM thresholds_history ORDER BY created_at DESC LIMIT $1`, limit)
 	case DialectMySQL:
-		rows, err = ps.db.Query(`SELECT name, old_value, new_value, user_id, guild_id, created_at FROM thresholds_history ORDER BY created_at DESC LIMIT ?`, limit)
+		rows, err = ps.query(`SELECT name, old_value, new_value, user_id, guild_id, created_at FROM thresholds_history ORDER BY created_at DESC LIMIT ?`, limit)
 	}
 	if err != nil {
 		return changes, err
@@ -247,10 +258,10 @@ func (ts *ThresholdsStore) HistoryFiltered(ps *PermStore, name string, limit int
 	)
 	switch ps.dialect {
 	case DialectPostgres:
-		rows, err = ps.db.Query(`SELECT name, old_value, new_value, user_id, guild_id, created_at
+		rows, err = ps.query(`SELECT name, old_value, new_value, user_id, guild_id, created_at
 			FROM thresholds_history WHERE name = $1 ORDER BY created_at DESC LIMIT $2`, name, limit)
 	case DialectMySQL:
-		rows, err = ps.db.Query(`SELECT name, old_value, new_value, user_id, guild_id, created_at
+		rows, err = ps.query(`SELECT name, old_value, new_value, user_id, guild_id, created_at
 			FROM thresholds_history WHERE name = ? ORDER BY created_at DESC LIMIT ?`, name, limit)
 	}
 	if err != nil {
@@ -290,84 +301,132 @@ func (ts *ThresholdsStore) ensureGuildTable(ps *PermStore) error {
 			PRIMARY KEY (guild_id, name)
 		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
 	}
-	_, err := ps.db.Exec(ddl)
+	_, err := ps.exec(ddl)
 	return err
 }
 
-// GetGuildThresholds returns the active thresholds for a guild, with fallback to global table, else defaults
-func (ts *ThresholdsStore) GetGuildThresholds(ps *PermStore, guildID string) (float64, float64, float64, float64) {
-	// defaults
-	ns := DefaultNuditySuggestiveThreshold
-	ne := DefaultNudityExplicitThreshold
-	off := DefaultOffensiveThreshold
-	ai := DefaultAIGeneratedThreshold
-
-	if ps == nil || ps.db == nil || guildID == "" {
-		return ns, ne, off, ai
-	}
-	// load guild-specific
-	rows, err := ps.db.Query(`SELECT name, value FROM thresholds_guild WHERE guild_id = `+ts.param(ps, 1), guildID)
-	if err == nil {
-		defer rows.Close()
-		for rows.Next() {
-			var name string
-			var v float64
-			if err := rows.Scan(&name, &v); err == nil {
-				switch name {
-				case "NuditySuggestive":
-					ns = v
-				case "NudityExplicit":
-					ne = v
-				case "Offensive":
-					off = v
-				case "AIGenerated":
-					ai = v
-				}
+// ThresholdSource identifies where an effective threshold value came from
+type ThresholdSource string
+
+const (
+	ThresholdSourceGuild   ThresholdSource = "guild"
+	ThresholdSourceGlobal  ThresholdSource = "global"
+	ThresholdSourceDefault ThresholdSource = "default"
+)
+
+// SourcedThreshold is an effective threshold value together with its source
+type SourcedThreshold struct {
+	Value  float64
+	Source ThresholdSource
+}
+
+// thresholdNames lists the canonical threshold names in display order
+var thresholdNames = []string{"NudityExplicit", "NuditySuggestive", "Offensive", "AIGenerated", "Deepfake"}
+
+// GetGuildThresholds returns the active thresholds for a guild (see resolveThresholds for precedence).
+// An empty guildID (DM context) resolves to the owner's DM threshold set
+func (ts *ThresholdsStore) GetGuildThresholds(ps *PermStore, guildID string) (float64, float64, float64, float64, float64) {
+	m := ts.GetGuildThresholdsWithSource(ps, guildID)
+	return m["NuditySuggestive"].Value, m["NudityExplicit"].Value, m["Offensive"].Value, m["AIGenerated"].Value, m["Deepfake"].Value
+}
+
+// GetGuildThresholdsWithSource returns, per canonical threshold name, the effective value and
+// whether it came from the guild table, the global table, or the built-in default. A layer that
+// can't be read is logged and skipped, so analyses keep working during a DB outage
+func (ts *ThresholdsStore) GetGuildThresholdsWithSource(ps *PermStore, guildID string) map[string]SourcedThreshold {
+	if ps == nil || ps.db == nil {
+		// No DB: the in-memory globals are the global layer; per-guild values aren't stored
+		global := make(map[string]float64, len(thresholdNames))
+		for name, v := range globalThresholdValues() {
+			if v != defaultThresholdValue(name) {
+				global[name] = v
 			}
 		}
+		return resolveThresholds(global, nil)
 	}
-	// Fallback to global table for any values still default (optional)
-	// We won’t override guild values; only fill from global thresholds table if value equals default and a global override exists
-	glob, err := ps.db.Query(`SELECT name, value FROM thresholds`)
-	if err == nil {
-		defer glob.Close()
-		for glob.Next() {
-			var name string
-			var v float64
-			if err := glob.Scan(&name, &v); err == nil {
-				switch name {
-				case "NuditySuggestive":
-					if ns == DefaultNuditySuggestiveThreshold {
-						ns = v
-					}
-				case "NudityExplicit":
-					if ne == DefaultNudityExplicitThreshold {
-						ne = v
-					}
-				case "Offensive":
-					if off == DefaultOffensiveThreshold {
-						off = v
-					}
-				case "AIGenerated":
-					if ai == DefaultAIGeneratedThreshold {
-						ai = v
-					}
-				}
-			}
+	m, err := ts.loadGuildThresholds(ps, guildID)
+	if err != nil {
+		log.Println("thresholds read error:", err)
+	}
+	return m
+}
+
+// loadGuildThresholds resolves the stored global and guild layers for guildID. On error the
+// result covers only what was read; callers that write based on it must not use it then
+func (ts *ThresholdsStore) loadGuildThresholds(ps *PermStore, guildID string) (map[string]SourcedThreshold, error) {
+	global, err := readThresholdValues(ps, `SELECT name, value FROM thresholds`)
+	if err != nil {
+		return resolveThresholds(nil, nil), fmt.Errorf("read global thresholds: %w", err)
+	}
+	guild, err := readThresholdValues(ps, `SELECT name, value FROM thresholds_guild WHERE guild_id = `+ts.param(ps, 1), thresholdsGuildKey(guildID))
+	if err != nil {
+		return resolveThresholds(global, nil), fmt.Errorf("read guild thresholds: %w", err)
+	}
+	return resolveThresholds(global, guild), nil
+}
+
+// readThresholdValues runs a name/value query and collects the rows by name
+func readThresholdValues(ps *PermStore, query string, args ...any) (map[string]float64, error) {
+	rows, err := ps.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]float64, len(thresholdNames))
+	for rows.Next() {
+		var name string
+		var v float64
+		if err := rows.Scan(&name, &v); err != nil {
+			return nil, err
 		}
+		out[name] = v
 	}
-	return ns, ne, off, ai
+	return out, rows.Err()
 }
 
-// SetGuild upserts a single guild-specific threshold
+// resolveThresholds applies the single precedence rule for thresholds: a guild value wins,
+// else a global value, else the built-in default. A guild value equal to the default still
+// wins over a global override. Unknown names in either layer are ignored
+func resolveThresholds(global, guild map[string]float64) map[string]SourcedThreshold {
+	out := make(map[string]SourcedThreshold, len(thresholdNames))
+	for _, name := range thresholdNames {
+		switch {
+		case hasKey(guild, name):
+			out[name] = SourcedThreshold{Value: guild[name], Source: ThresholdSourceGuild}
+		case hasKey(global, name):
+			out[name] = SourcedThreshold{Value: global[name], Source: ThresholdSourceGlobal}
+		default:
+			out[name] = SourcedThreshold{Value: defaultThresholdValue(name), Source: ThresholdSourceDefault}
+		}
+	}
+	return out
+}
+
+func hasKey(m map[string]float64, k string) bool {
+	_, ok := m[k]
+	return ok
+}
+
+// globalThresholdValues snapshots the in-memory global thresholds by canonical name
+func globalThresholdValues() map[string]float64 {
+	return map[string]float64{
+		"NuditySuggestive": NuditySuggestiveThreshold,
+		"NudityExplicit":   NudityExplicitThreshold,
+		"Offensive":        OffensiveThreshold,
+		"AIGenerated":      AIGeneratedThreshold,
+		"Deepfake":         DeepfakeThreshold,
+	}
+}
+
+// SetGuild upserts a single guild-specific threshold; without a DB it returns errThresholdsNeedDB
 func (ts *ThresholdsStore) SetGuild(ps *PermStore, guildID, name string, value float64) error {
-	if ps == nil || ps.db == nil {
-		// no-op when DB not configured
-		return nil
+	if !ts.GuildStorageAvailable(ps) {
+		return errThresholdsNeedDB
 	}
 	if err := ts.ensureGuildTable(ps); err != nil {
 		return err
 	}
+	guildID = thresholdsGuildKey(guildID)
 	var stmt string
 	switch ps.dialect {
 	case DialectPostgres:
@@ -377,65 +436,118 @@ func (ts *ThresholdsStore) SetGuild(ps *PermStore, guildID, name string, value f
 		stmt = `INSERT INTO thresholds_guild (guild_id, name, value) VALUES (?, ?, ?)
 			ON DUPLICATE KEY UPDATE value = VALUES(value)`
 	}
-	_, err := ps.db.Exec(stmt, guildID, name, value)
+	_, err := ps.exec(stmt, guildID, name, value)
+	return err
+}
+
+// EnsureGuildSnapshot persists every current effective threshold for a guild that has no
+// guild-specific values yet. Call it before a guild's first SetGuild: from then on the guild keeps
+// the values it saw when it started customising, and later global or default changes no longer
+// alter the categories it left alone. It aborts when the current values can't be read, and its
+// single insert skips names the guild already has, so it never overwrites a guild's own values
+func (ts *ThresholdsStore) EnsureGuildSnapshot(ps *PermStore, guildID string) error {
+	if !ts.GuildStorageAvailable(ps) {
+		return errThresholdsNeedDB
+	}
+	current, err := ts.loadGuildThresholds(ps, guildID)
+	if err != nil {
+		return fmt.Errorf("thresholds snapshot: %w", err)
+	}
+	for _, t := range current {
+		if t.Source == ThresholdSourceGuild {
+			return nil
+		}
+	}
+	if err := ts.ensureGuildTable(ps); err != nil {
+		return err
+	}
+	key := thresholdsGuildKey(guildID)
+	values := make([]string, 0, len(thresholdNames))
+	args := make([]any, 0, 3*len(thresholdNames))
+	for _, name := range thresholdNames {
+		n := len(args)
+		values = append(values, "("+ts.param(ps, n+1)+", "+ts.param(ps, n+2)+", "+ts.param(ps, n+3)+")")
+		args = append(args, key, name, current[name].Value)
+	}
+	var stmt string
+	switch ps.dialect {
+	case DialectPostgres:
+		stmt = `INSERT INTO thresholds_guild (guild_id, name, value) VALUES ` + strings.Join(values, ", ") +
+			` ON CONFLICT (guild_id, name) DO NOTHING`
+	case DialectMySQL:
+		stmt = `INSERT IGNORE INTO thresholds_guild (guild_id, name, value) VALUES ` + strings.Join(values, ", ")
+	}
+	_, err = ps.exec(stmt, args...)
 	return err
 }
 
 // ResetOneGuild resets one threshold for the guild to default
 func (ts *ThresholdsStore) ResetOneGuild(ps *PermStore, guildID, name string) error {
-	var def float64
-	switch name {
-	case "NuditySuggestive":
-		def = DefaultNuditySuggestiveThreshold
-	case "NudityExplicit":
-		def = DefaultNudityExplicitThreshold
-	case "Offensive":
-		def = DefaultOffensiveThreshold
-	case "AIGenerated":
-		def = DefaultAIGeneratedThreshold
-	default:
+	if !isThresholdName(name) {
 		return fmt.Errorf("unknown threshold: %s", name)
 	}
-	return ts.SetGuild(ps, guildID, name, def)
+	return ts.SetGuild(ps, guildID, name, defaultThresholdValue(name))
 }
 
 // ResetAllGuild resets all thresholds for a guild to defaults
 func (ts *ThresholdsStore) ResetAllGuild(ps *PermStore, guildID string) error {
-	if err := ts.SetGuild(ps, guildID, "NuditySuggestive", DefaultNuditySuggestiveThreshold); err != nil {
-		return err
+	for _, name := range thresholdNames {
+		if err := ts.SetGuild(ps, guildID, name, defaultThresholdValue(name)); err != nil {
+			return err
+		}
 	}
-	if err := ts.SetGuild(ps, guildID, "NudityExplicit", DefaultNudityExplicitThreshold); err != nil {
-		return err
+	return nil
+}
+
+// ClearGuild deletes all guild-specific thresholds so the guild falls back to global/defaults
+func (ts *ThresholdsStore) ClearGuild(ps *PermStore, guildID string) error {
+	if ps == nil || ps.db == nil {
+		return nil
 	}
-	if err := ts.SetGuild(ps, guildID, "Offensive", DefaultOffensiveThreshold); err != nil {
+	if err := ts.ensureGuildTable(ps); err != nil {
 		return err
 	}
-	if err := ts.SetGuild(ps, guildID, "AIGenerated", DefaultAIGeneratedThreshold); err != nil {
-		return err
+	_, err := ps.exec(`DELETE FROM thresholds_guild WHERE guild_id = `+ts.param(ps, 1), thresholdsGuildKey(guildID))
+	return err
+}
+
+// ClearGuildHistory deletes the threshold audit history for a guild
+func (ts *ThresholdsStore) ClearGuildHistory(ps *PermStore, guildID string) error {
+	if ps == nil || ps.db == nil {
+		return nil
 	}
-	return nil
+	_, err := ps.exec(`DELETE FROM thresholds_history WHERE guild_id = `+ts.param(ps, 1), thresholdsGuildKey(guildID))
+	return err
 }
 
 // HistoryForGuild returns recent changes for a guild
 func (ts *ThresholdsStore) HistoryForGuild(ps *PermStore, guildID string, limit int) ([]ThresholdChange, error) {
+	return ts.HistoryForGuildPaged(ps, guildID, limit, 0)
+}
+
+// HistoryForGuildPaged returns up to limit changes for a guild, newest first, skipping the
+// first offset; limit is capped at 100 per page but offset is not, so audits can page back
+func (ts *ThresholdsStore) HistoryForGuildPaged(ps *PermStore, guildID string, limit, offset int) ([]ThresholdChange, error) {
 	changes := []ThresholdChange{}
 	if ps == nil || ps.db == nil {
 		return changes, nil
 	}
+	guildID = thresholdsGuildKey(guildID)
 	if limit <= 0 || limit > 100 {
 		limit = 10
 	}
+	offset = max(offset, 0)
 	var (
 		rows *sql.Rows
 		err  error
 	)
 	switch ps.dialect {
 	case DialectPostgres:
-		rows, err = ps.db.Query(`SELECT name, old_value, new_value, user_id, guild_id, created_at
-			FROM thresholds_history WHERE guild_id = $1 ORDER BY created_at DESC LIMIT $2`, guildID, limit)
+		rows, err = ps.query(`SELECT name, old_value, new_value, user_id, guild_id, created_at
+			FROM thresholds_history WHERE guild_id = $1 ORDER BY created_at DESC LIMIT $2 OFFSET $3`, guildID, limit, offset)
 	case DialectMySQL:
-		rows, err = ps.db.Query(`SELECT name, old_value, new_value, user_id, guild_id, created_at
-			FROM thresholds_history WHERE guild_id = ? ORDER BY created_at DESC LIMIT ?`, guildID, limit)
+		rows, err = ps.query(`SELECT name, old_value, new_value, user_id, guild_id, created_at
+			FROM thresholds_history WHERE guild_id = ? ORDER BY created_at DESC LIMIT ? OFFSET ?`, guildID, limit, offset)
 	}
 	if err != nil {
 		return changes, err
@@ -454,24 +566,31 @@ func (ts *ThresholdsStore) HistoryForGuild(ps *PermStore, guildID string, limit
 
 // HistoryFilteredForGuild returns recent changes for a specific threshold in a guild
 func (ts *ThresholdsStore) HistoryFilteredForGuild(ps *PermStore, guildID, name string, limit int) ([]ThresholdChange, error) {
+	return ts.HistoryFilteredForGuildPaged(ps, guildID, name, limit, 0)
+}
+
+// HistoryFilteredForGuildPaged is HistoryForGuildPaged restricted to one threshold
+func (ts *ThresholdsStore) HistoryFilteredForGuildPaged(ps *PermStore, guildID, name string, limit, offset int) ([]ThresholdChange, error) {
 	changes := []ThresholdChange{}
 	if ps == nil || ps.db == nil {
 		return changes, nil
 	}
+	guildID = thresholdsGuildKey(guildID)
 	if limit <= 0 || limit > 100 {
 		limit = 10
 	}
+	offset = max(offset, 0)
 	var (
 		rows *sql.Rows
 		err  error
 	)
 	switch ps.dialect {
 	case DialectPostgres:
-		rows, err = ps.db.Query(`SELECT name, old_value, new_value, user_id, guild_id, created_at
-			FROM thresholds_history WHERE guild_id = $1 AND name = $2 ORDER BY created_at DESC LIMIT $3`, guildID, name, limit)
+		rows, err = ps.query(`SELECT name, old_value, new_value, user_id, guild_id, created_at
+			FROM thresholds_history WHERE guild_id = $1 AND name = $2 ORDER BY created_at DESC LIMIT $3 OFFSET $4`, guildID, name, limit, offset)
 	case DialectMySQL:
-		rows, err = ps.db.Query(`SELECT name, old_value, new_value, user_id, guild_id, created_at
-			FROM thresholds_history WHERE guild_id = ? AND name = ? ORDER BY created_at DESC LIMIT ?`, guildID, name, limit)
+		rows, err = ps.query(`SELECT name, old_value, new_value, user_id, guild_id, created_at
+			FROM thresholds_history WHERE guild_id = ? AND name = ? ORDER BY created_at DESC LIMIT ? OFFSET ?`, guildID, name, limit, offset)
 	}
 	if err != nil {
 		return changes, err
@@ -488,8 +607,49 @@ func (ts *ThresholdsStore) HistoryFilteredForGuild(ps *PermStore, guildID, name
 	return changes, nil
 }
 
-// defaultThresholdValue returns the built-in default for a canonical threshold name
+// defaultThresholdEnv maps canonical threshold names to the env vars that override their defaults
+var defaultThresholdEnv = map[string]string{
+	"NuditySuggestive": "DEFAULT_NUDITY_SUGGESTIVE",
+	"NudityExplicit":   "DEFAULT_NUDITY_EXPLICIT",
+	"Offensive":        "DEFAULT_OFFENSIVE",
+	"AIGenerated":      "DEFAULT_AI_GENERATED",
+	"Deepfake":         "DEFAULT_DEEPFAKE",
+}
+
+// defaultThresholdOverrides holds operator defaults read by loadDefaultThresholds
+var defaultThresholdOverrides = map[string]float64{}
+
+// loadDefaultThresholds reads DEFAULT_* env overrides (decimals or percentages in [0,1]) and
+// moves the in-memory globals onto them. Invalid values are logged and the compiled default kept.
+// Must run before the thresholds store loads persisted globals
+func loadDefaultThresholds() {
+	for _, name := range thresholdNames {
+		env := defaultThresholdEnv[name]
+		raw := strings.TrimSpace(os.Getenv(env))
+		if raw == "" {
+			continue
+		}
+		v, err := parseThresholdInput(raw)
+		if err != nil {
+			log.Printf("ignoring %s=%q: %v; using the built-in default %.2f", env, raw, err, defaultThresholdValue(name))
+			continue
+		}
+		defaultThresholdOverrides[name] = v
+		setGlobalThreshold(name, v)
+	}
+}
+
+// isThresholdName reports whether name is a canonical threshold name
+func isThresholdName(name string) bool {
+	return slices.Contains(thresholdNames, name)
+}
+
+// defaultThresholdValue returns the default for a canonical threshold name: the DEFAULT_*
+// env override when set, otherwise the compiled constant
 func defaultThresholdValue(name string) float64 {
+	if v, ok := defaultThresholdOverrides[name]; ok {
+		return v
+	}
 	switch name {
 	case "NuditySuggestive":
 		return DefaultNuditySuggestiveThreshold
@@ -499,6 +659,8 @@ func defaultThresholdValue(name string) float64 {
 		return DefaultOffensiveThreshold
 	case "AIGenerated":
 		return DefaultAIGeneratedThreshold
+	case "Deepfake":
+		return DefaultDeepfakeThreshold
 	default:
 		return 0
 	}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"maps"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGuildThresholdsRequireDB(t *testing.T) {
+	file := &PermStore{}
+	for name, err := range map[string]error{
+		"SetGuild":            thresholdsStore.SetGuild(file, "g1", "Offensive", 0.5),
+		"SetGuild (DM)":       thresholdsStore.SetGuild(file, "", "Offensive", 0.5),
+		"EnsureGuildSnapshot": thresholdsStore.EnsureGuildSnapshot(file, "g1"),
+		"ResetOneGuild":       thresholdsStore.ResetOneGuild(file, "g1", "Offensive"),
+		"ResetAllGuild":       thresholdsStore.ResetAllGuild(file, "g1"),
+	} {
+		if !errors.Is(err, errThresholdsNeedDB) {
+			t.Errorf("%s without a DB = %v, want errThresholdsNeedDB", name, err)
+		}
+	}
+	// Clearing has nothing to remove, so /reset still succeeds in file-storage mode
+	if err := thresholdsStore.ClearGuild(file, "g1"); err != nil {
+		t.Errorf("ClearGuild without a DB = %v, want nil", err)
+	}
+	if got := thresholdsStore.GetGuildThresholdsWithSource(file, "g1")["Offensive"]; got.Source == ThresholdSourceGuild {
+		t.Errorf("Offensive = %+v, want a non-guild source", got)
+	}
+}
+
+func TestParseThresholdInput(t *testing.T) {
+	for in, want := range map[string]float64{"0": 0, "1": 1, "0.25": 0.25, "70%": 0.7, "70": 0.7, "100%": 1} {
+		if got, err := parseThresholdInput(in); err != nil || math.Abs(got-want) > 1e-9 {
+			t.Errorf("parseThresholdInput(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"NaN", "nan%", "Inf", "-Inf", "1.5", "150", "101%", "-0.1", "-5%", "50.5", "", "abc"} {
+		if got, err := parseThresholdInput(in); !errors.Is(err, errThresholdRange) {
+			t.Errorf("parseThresholdInput(%q) = %v, %v; want errThresholdRange", in, got, err)
+		}
+	}
+}
+
+// resetGlobalThresholds sets the in-memory globals to their defaults and restores them after the test
+func resetGlobalThresholds(t *testing.T) {
+	t.Helper()
+	saved := globalThresholdValues()
+	for _, name := range thresholdNames {
+		setGlobalThreshold(name, defaultThresholdValue(name))
+	}
+	t.Cleanup(func() {
+		for name, v := range saved {
+			setGlobalThreshold(name, v)
+		}
+	})
+}
+
+// capturedRow records the arguments of an upsert so a later query can return them
+type capturedRow []driver.Value
+
+// capture returns sqlmock arguments that store the statement's n arguments in a new row of table
+func capture(table *[]capturedRow, n int) []driver.Value {
+	row := make(capturedRow, n)
+	*table = append(*table, row)
+	args := make([]driver.Value, n)
+	for i := range args {
+		args[i] = captureArg{&row[i]}
+	}
+	return args
+}
+
+type captureArg struct{ dst *driver.Value }
+
+func (c captureArg) Match(v driver.Value) bool {
+	*c.dst = v
+	return true
+}
+
+// nameValueRows returns the name/value columns of the captured rows whose leading columns equal key
+func nameValueRows(table []capturedRow, key ...driver.Value) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"name", "value"})
+	for _, r := range table {
+		if len(r) == len(key)+2 && slices.Equal(r[:len(key)], key) {
+			rows.AddRow(r[len(key)], r[len(key)+1])
+		}
+	}
+	return rows
+}
+
+func TestThresholdsPersistAndReload(t *testing.T) {
+	resetGlobalThresholds(t)
+	ps, mock := newMockPermStore(t)
+	var globalTable, guildTable []capturedRow
+
+	// set: two global values and two guild values, one of them for a category with a global value
+	global := map[string]float64{"Offensive": 0.4, "AIGenerated": 0.5}
+	for _, name := range []string{"Offensive", "AIGenerated"} {
+		mock.ExpectExec(`INSERT INTO thresholds \(name`).WithArgs(capture(&globalTable, 2)...).WillReturnResult(sqlmock.NewResult(0, 1))
+		if err := thresholdsStore.Set(ps, name, global[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	guild := map[string]float64{"Offensive": 0.7, "NudityExplicit": 0.9}
+	for _, name := range []string{"Offensive", "NudityExplicit"} {
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS thresholds_guild").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO thresholds_guild").WithArgs(capture(&guildTable, 3)...).WillReturnResult(sqlmock.NewResult(0, 1))
+		if err := thresholdsStore.SetGuild(ps, "g1", name, guild[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantG1 := resolveThresholds(global, guild)
+	wantG2 := resolveThresholds(global, nil)
+	if wantG1["Offensive"] != (SourcedThreshold{0.7, ThresholdSourceGuild}) || wantG2["Offensive"] != (SourcedThreshold{0.4, ThresholdSourceGlobal}) {
+		t.Fatalf("precedence: g1 %+v, g2 %+v", wantG1["Offensive"], wantG2["Offensive"])
+	}
+
+	// reload: a restart starts from the defaults and reads back what was written
+	for _, name := range thresholdNames {
+		setGlobalThreshold(name, defaultThresholdValue(name))
+	}
+	mock.ExpectQuery("SELECT name, value FROM thresholds$").WillReturnRows(nameValueRows(globalTable))
+	if err := thresholdsStore.Load(ps); err != nil {
+		t.Fatal(err)
+	}
+	wantGlobals := map[string]float64{}
+	for _, name := range thresholdNames {
+		wantGlobals[name] = defaultThresholdValue(name)
+	}
+	maps.Copy(wantGlobals, global)
+	if got := globalThresholdValues(); !maps.Equal(got, wantGlobals) {
+		t.Errorf("reloaded globals = %v, want %v", got, wantGlobals)
+	}
+
+	for guildID, want := range map[string]map[string]SourcedThreshold{"g1": wantG1, "g2": wantG2} {
+		mock.ExpectQuery("SELECT name, value FROM thresholds$").WillReturnRows(nameValueRows(globalTable))
+		mock.ExpectQuery("SELECT name, value FROM thresholds_guild").WithArgs(guildID).WillReturnRows(nameValueRows(guildTable, guildID))
+		if got := thresholdsStore.GetGuildThresholdsWithSource(ps, guildID); !maps.Equal(got, want) {
+			t.Errorf("%s after reload = %v, want %v", guildID, got, want)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestThresholdPercentRoundTrip(t *testing.T) {
+	// Decimal and percent spellings of a value must display the same in list and history
+	for _, tc := range []struct {
+		inputs []string
+		want   string
+	}{
+		{[]string{"0", "0%", "0.0"}, "0%"},
+		{[]string{"1", "1.0", "100%", "100"}, "100%"},
+		{[]string{"0.7", "70%", "70", "0.70"}, "70%"},
+		{[]string{"0.005", "0.5%"}, "0.5%"},
+		{[]string{"0.125", "12.5%"}, "12.5%"},
+		{[]string{"0.00001", "0.001%"}, "<0.01%"},
+		{[]string{"0.99999", "99.999%"}, ">99.99%"},
+	} {
+		for _, in := range tc.inputs {
+			v, err := parseThresholdValue(in)
+			if err != nil {
+				t.Errorf("parseThresholdValue(%q): %v", in, err)
+				continue
+			}
+			if got := formatThresholdPercent(v); got != tc.want {
+				t.Errorf("%q displays as %q, want %q", in, got, tc.want)
+			}
+		}
+	}
+	// Out-of-range stored values are clamped rather than shown as e.g. 150%
+	for v, want := range map[float64]string{1.5: "100%", -0.1: "0%"} {
+		if got := formatThresholdPercent(v); got != want {
+			t.Errorf("formatThresholdPercent(%v) = %q, want %q", v, got, want)
+		}
+	}
+}
+
+func TestLoadDefaultThresholdsIgnoresInvalidValues(t *testing.T) {
+	resetGlobalThresholds(t)
+	saved := maps.Clone(defaultThresholdOverrides)
+	clear(defaultThresholdOverrides)
+	t.Cleanup(func() {
+		clear(defaultThresholdOverrides)
+		maps.Copy(defaultThresholdOverrides, saved)
+	})
+	want := globalThresholdValues()
+	t.Setenv("DEFAULT_NUDITY_SUGGESTIVE", "NaN")
+	t.Setenv("DEFAULT_NUDITY_EXPLICIT", "inf%")
+	t.Setenv("DEFAULT_OFFENSIVE", "1.5")
+	t.Setenv("DEFAULT_AI_GENERATED", "-Inf")
+	t.Setenv("DEFAULT_DEEPFAKE", "40%")
+	want["Deepfake"] = 0.4
+	loadDefaultThresholds()
+	if got := globalThresholdValues(); !maps.Equal(got, want) {
+		t.Fatalf("globals after loading defaults = %v, want %v", got, want)
+	}
+	if len(defaultThresholdOverrides) != 1 || defaultThresholdOverrides["Deepfake"] != 0.4 {
+		t.Fatalf("overrides = %v, want only Deepfake=0.4", defaultThresholdOverrides)
+	}
+}
+
+func TestEnsureGuildSnapshot(t *testing.T) {
+	resetGlobalThresholds(t)
+	ps, mock := newMockPermStore(t)
+	globalTable := []capturedRow{{"Offensive", 0.4}}
+	var guildTable []capturedRow
+
+	// First customisation: every effective value is stored for the guild in one insert that
+	// leaves existing rows alone
+	var insert []capturedRow
+	mock.ExpectQuery("SELECT name, value FROM thresholds$").WillReturnRows(nameValueRows(globalTable))
+	mock.ExpectQuery("SELECT name, value FROM thresholds_guild").WithArgs("g1").WillReturnRows(nameValueRows(nil))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS thresholds_guild").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO thresholds_guild .* ON CONFLICT \(guild_id, name\) DO NOTHING$`).
+		WithArgs(capture(&insert, 3*len(thresholdNames))...).WillReturnResult(sqlmock.NewResult(0, 5))
+	if err := thresholdsStore.EnsureGuildSnapshot(ps, "g1"); err != nil {
+		t.Fatal(err)
+	}
+	for row := range slices.Chunk(insert[0], 3) {
+		guildTable = append(guildTable, row)
+	}
+	want := map[string]float64{}
+	for _, name := range thresholdNames {
+		want[name] = defaultThresholdValue(name)
+	}
+	want["Offensive"] = 0.4
+	got := map[string]float64{}
+	for _, r := range guildTable {
+		if r[0] != "g1" {
+			t.Errorf("snapshot row for guild %v", r[0])
+		}
+		got[r[1].(string)] = r[2].(float64)
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("snapshot = %v, want %v", got, want)
+	}
+
+	// Later calls see the guild's values and write nothing
+	for range 2 {
+		mock.ExpectQuery("SELECT name, value FROM thresholds$").WillReturnRows(nameValueRows(globalTable))
+		mock.ExpectQuery("SELECT name, value FROM thresholds_guild").WithArgs("g1").WillReturnRows(nameValueRows(guildTable, "g1"))
+		if err := thresholdsStore.EnsureGuildSnapshot(ps, "g1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// The snapshot pins the guild: a later global change doesn't reach it
+	guild := map[string]float64{}
+	for _, r := range guildTable {
+		guild[r[1].(string)] = r[2].(float64)
+	}
+	if got := resolveThresholds(map[string]float64{"Offensive": 0.1, "AIGenerated": 0.2}, guild); got["AIGenerated"].Value != defaultThresholdValue("AIGenerated") {
+		t.Errorf("AIGenerated after a global change = %+v, want the snapshotted default", got["AIGenerated"])
+	}
+}
+
+func TestEnsureGuildSnapshotAbortsOnReadError(t *testing.T) {
+	resetGlobalThresholds(t)
+	readErr := errors.New("canceling statement due to statement timeout")
+	for _, tc := range []struct {
+		name   string
+		expect func(mock sqlmock.Sqlmock)
+	}{
+		{"guild read fails", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("SELECT name, value FROM thresholds$").WillReturnRows(nameValueRows(nil))
+			mock.ExpectQuery("SELECT name, value FROM thresholds_guild").WithArgs("g1").WillReturnError(readErr)
+		}},
+		{"global read fails", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("SELECT name, value FROM thresholds$").WillReturnError(readErr)
+		}},
+		{"guild row fails mid-read", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("SELECT name, value FROM thresholds$").WillReturnRows(nameValueRows(nil))
+			mock.ExpectQuery("SELECT name, value FROM thresholds_guild").WithArgs("g1").
+				WillReturnRows(sqlmock.NewRows([]string{"name", "value"}).AddRow("Offensive", 0.7).RowError(0, readErr))
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// No exec is expected: the guild's real values must not be overwritten
+			ps, mock := newMockPermStore(t)
+			tc.expect(mock)
+			if err := thresholdsStore.EnsureGuildSnapshot(ps, "g1"); !errors.Is(err, readErr) {
+				t.Errorf("err = %v, want the read error", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestParseThresholdValue(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"1", 1, false},
+		{"0", 0, false},
+		{"1.0", 1, false},
+		{"0.25", 0.25, false},
+		{"70%", 0.7, false},
+		{" 70 % ", 0.7, false},
+		{"70", 0.7, false},
+		{"100", 1, false},
+		{"0%", 0, false},
+		{"100%", 1, false},
+		{"12.5%", 0.125, false},
+		{"50.5", 50.5, false}, // a fraction, so out of range for the caller to reject
+		{"150", 1.5, false},
+		{"-5%", -0.05, false},
+		{"", 0, true},
+		{"%", 0, true},
+		{"abc", 0, true},
+		{"NaN", 0, true},
+		{"nan", 0, true},
+		{"nan%", 0, true},
+		{"Inf", 0, true},
+		{"+Inf", 0, true},
+		{"-inf%", 0, true},
+		{"infinity", 0, true},
+		{"1e400", 0, true},
+	} {
+		got, err := parseThresholdValue(tc.in)
+		if (err != nil) != tc.wantErr || math.IsNaN(got) {
+			t.Errorf("parseThresholdValue(%q) error = %v, wantErr %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("parseThresholdValue(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// usageGuildKey is the context key carrying the guild a Sightengine call is billed to
+type usageGuildKey struct{}
+
+// withUsageGuild attributes Sightengine calls made with ctx to a guild (DMs use DMThresholdsKey)
+func withUsageGuild(ctx context.Context, guildID string) context.Context {
+	return context.WithValue(ctx, usageGuildKey{}, thresholdsGuildKey(guildID))
+}
+
+// usageGuildFromContext returns the guild key attached by withUsageGuild, or ""
+func usageGuildFromContext(ctx context.Context) string {
+	g, _ := ctx.Value(usageGuildKey{}).(string)
+	return g
+}
+
+// usagePeriod returns the calendar month (UTC) a call made at t is counted under, e.g. "2026-10"
+func usagePeriod(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// UsageStore counts Sightengine calls per guild per calendar month in the usage_counters
+// table when a DB is configured; otherwise counts are kept in memory and reset on restart
+type UsageStore struct {
+	mu  sync.Mutex
+	ps  *PermStore
+	mem map[string]int64 // guildKey + "|" + period -> calls
+}
+
+var usageStore = &UsageStore{mem: make(map[string]int64)}
+
+// Init creates the usage_counters table if a DB is available
+func (us *UsageStore) Init(ps *PermStore) error {
+	us.mu.Lock()
+	us.ps = ps
+	us.mu.Unlock()
+	if ps == nil || ps.db == nil {
+		return nil
+	}
+	var ddl string
+	switch ps.dialect {
+	case DialectPostgres:
+		ddl = `CREATE TABLE IF NOT EXISTS usage_counters (
+			guild_id   TEXT NOT NULL,
+			period     TEXT NOT NULL,
+			calls      BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (guild_id, period)
+		)`
+	case DialectMySQL:
+		ddl = `CREATE TABLE IF NOT EXISTS usage_counters (
+			guild_id   VARCHAR(64) NOT NULL,
+			period     CHAR(7) NOT NULL,
+			calls      BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (guild_id, period)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+	default:
+		return fmt.Errorf("unsupported dialect: %s", ps.dialect)
+	}
+	if _, err := ps.db.Exec(ddl); err != nil {
+		return fmt.Errorf("create usage_counters table: %w", err)
+	}
+	return nil
+}
+
+// Increment records one call for the guild in the current month; a new month starts a new row
+func (us *UsageStore) Increment(guildKey string) {
+	if guildKey == "" {
+		return
+	}
+	period := usagePeriod(time.Now())
+	us.mu.Lock()
+	ps := us.ps
+	if ps == nil || ps.db == nil {
+		us.mem[guildKey+"|"+period]++
+		us.mu.Unlock()
+		return
+	}
+	us.mu.Unlock()
+
+	var stmt string
+	switch ps.dialect {
+	case DialectPostgres:
+		stmt = `INSERT INTO usage_counters (guild_id, period, calls) VALUES ($1, $2, 1)
+			ON CONFLICT (guild_id, period) DO UPDATE SET calls = usage_counters.calls + 1`
+	case DialectMySQL:
+		stmt = `INSERT INTO usage_counters (guild_id, period, calls) VALUES (?, ?, 1)
+			ON DUPLICATE KEY UPDATE calls = calls + 1`
+	}
+	if _, err := ps.db.Exec(stmt, guildKey, period); err != nil {
+		log.Println("usage counter increment error:", err)
+	}
+}
+
+// CurrentMonth returns the number of calls made for the guild in the current calendar month
+func (us *UsageStore) CurrentMonth(guildKey string) (int64, error) {
+	period := usagePeriod(time.Now())
+	us.mu.Lock()
+	ps := us.ps
+	if ps == nil || ps.db == nil {
+		n := us.mem[guildKey+"|"+period]
+		us.mu.Unlock()
+		return n, nil
+	}
+	us.mu.Unlock()
+
+	var stmt string
+	switch ps.dialect {
+	case DialectPostgres:
+		stmt = `SELECT calls FROM usage_counters WHERE guild_id = $1 AND period = $2`
+	case DialectMySQL:
+		stmt = `SELECT calls FROM usage_counters WHERE guild_id = ? AND period = ?`
+	}
+	var n int64
+	if err := ps.db.QueryRow(stmt, guildKey, period).Scan(&n); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return n, nil
+}
+
+// monthlyQuota returns MONTHLY_QUOTA (calls per guild per month); 0 means unlimited
+func monthlyQuota() int64 {
+	n, err := strconv.ParseInt(strings.TrimSpace(os.Getenv("MONTHLY_QUOTA")), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// OverQuota reports whether the guild has used up this month's quota.
+// Lookup errors fail open so a DB hiccup doesn't block moderation
+func (us *UsageStore) OverQuota(guildID string) bool {
+	quota := monthlyQuota()
+	if quota == 0 {
+		return false
+	}
+	n, err := us.CurrentMonth(thresholdsGuildKey(guildID))
+	if err != nil {
+		log.Println("usage quota lookup error:", err)
+		return false
+	}
+	return n >= quota
+}
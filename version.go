@@ -0,0 +1,15 @@
+package main
+
+// Build metadata, injected at build time via -ldflags, e.g.
+//
+//	go build -ldflags "-X main.Version=v1.2.3 -X main.Commit=$(git rev-parse --short HEAD) -X main.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Defaults apply when running via `go run` or an un-stamped build
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// SourceRepoURL is the public location of the bot's source code
+const SourceRepoURL = "https://github.com/wafflerdot/ChiefXD-Art"
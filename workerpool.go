@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Worker pool defaults for batch analysis
+const (
+	defaultAnalysisConcurrency = 4
+	analysisCallTimeout        = 60 * time.Second
+)
+
+// analysisConcurrency returns the worker pool size from ANALYSIS_CONCURRENCY (default 4)
+func analysisConcurrency() int {
+	if v, err := strconv.Atoi(strings.TrimSpace(os.Getenv("ANALYSIS_CONCURRENCY"))); err == nil && v > 0 {
+		return v
+	}
+	return defaultAnalysisConcurrency
+}
+
+// PoolResult is the outcome of one unit of pooled work, kept at the same index as its input URL
+type PoolResult[T any] struct {
+	URL   string
+	Value T
+	Err   error
+}
+
+// runPool applies work to every URL using at most analysisConcurrency() concurrent calls.
+// Each call gets its own timeout derived from ctx; results are returned in input order.
+// URLs not yet started when ctx is cancelled report ctx.Err()
+func runPool[T any](ctx context.Context, urls []string, work func(ctx context.Context, url string) (T, error)) []PoolResult[T] {
+	results := make([]PoolResult[T], len(urls))
+	workers := analysisConcurrency()
+	if workers > len(urls) {
+		workers = len(urls)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				callCtx, cancel := context.WithTimeout(ctx, analysisCallTimeout)
+				v, err := work(callCtx, urls[idx])
+				cancel()
+				results[idx] = PoolResult[T]{URL: urls[idx], Value: v, Err: err}
+			}
+		}()
+	}
+
+	next := 0
+feed:
+	for ; next < len(urls); next++ {
+		select {
+		case jobs <- next:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for idx := next; idx < len(urls); idx++ {
+		results[idx] = PoolResult[T]{URL: urls[idx], Err: ctx.Err()}
+	}
+	return results
+}